@@ -0,0 +1,241 @@
+// Package a11y checks saved HTML for common accessibility problems without
+// Node or a browser. It only sees the markup, so it can't check colour
+// contrast or anything scripts change, but it finds the same structural
+// problems as pa11y and reports them with the same WCAG codes.
+package a11y
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"boem-web-thing/storage"
+
+	"golang.org/x/net/html"
+)
+
+// Runner is the runner name on the issues this package finds
+const Runner = "go"
+
+// Issue types, as pa11y names them
+const (
+	TypeError   = "error"
+	TypeWarning = "warning"
+	TypeNotice  = "notice"
+)
+
+// contextLength is how much of an element's HTML is kept as the context of
+// an issue, like pa11y does
+const contextLength = 255
+
+// finding is an element a rule found a problem with
+type finding struct {
+	node    *html.Node
+	message string
+}
+
+// rule is one check. Code is the WCAG technique code pa11y's default runner
+// uses for the same problem, so results from both can be compared.
+type rule struct {
+	code  string
+	typ   string
+	check func(d *document) []finding
+}
+
+// Check parses an HTML document and runs every rule over it
+func Check(r io.Reader) ([]storage.Issues, error) {
+	root, err := html.Parse(r)
+	if err != nil {
+		return nil, fmt.Errorf("parsing html: %w", err)
+	}
+	d := newDocument(root)
+
+	issues := make([]storage.Issues, 0)
+	for _, rule := range rules {
+		for _, f := range rule.check(d) {
+			issues = append(issues, storage.Issues{
+				Code:     rule.code,
+				Type:     rule.typ,
+				Message:  f.message,
+				Selector: d.selector(f.node),
+				Context:  snippet(f.node),
+				Runner:   Runner,
+			})
+		}
+	}
+	return issues, nil
+}
+
+// document is a parsed page with the lookups the rules share
+type document struct {
+	root     *html.Node
+	elements []*html.Node            // every element in document order
+	ids      map[string][]*html.Node // elements by id
+	labelFor map[string]bool         // ids named by a label's for attribute
+}
+
+func newDocument(root *html.Node) *document {
+	d := &document{root: root, ids: make(map[string][]*html.Node), labelFor: make(map[string]bool)}
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			d.elements = append(d.elements, n)
+			if id := attr(n, "id"); id != "" {
+				d.ids[id] = append(d.ids[id], n)
+			}
+			if n.Data == "label" {
+				if target := attr(n, "for"); target != "" {
+					d.labelFor[target] = true
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(root)
+	return d
+}
+
+// byTag returns the elements with any of the tag names, in document order
+func (d *document) byTag(tags ...string) []*html.Node {
+	var found []*html.Node
+	for _, n := range d.elements {
+		for _, tag := range tags {
+			if n.Data == tag {
+				found = append(found, n)
+				break
+			}
+		}
+	}
+	return found
+}
+
+// selector builds a CSS selector for the element in the style pa11y uses,
+// like "html > body > main > p:nth-child(2)". It starts from the nearest
+// element with a unique id when there is one.
+func (d *document) selector(n *html.Node) string {
+	var parts []string
+	for ; n != nil && n.Type == html.ElementNode; n = n.Parent {
+		if id := attr(n, "id"); id != "" && len(d.ids[id]) == 1 && !strings.ContainsAny(id, " \t\n#.>:[]\"'") {
+			parts = append(parts, "#"+id)
+			break
+		}
+		part := n.Data
+		if n.Parent != nil && n.Parent.Type == html.ElementNode && sameTagSiblings(n) {
+			part += fmt.Sprintf(":nth-child(%d)", childIndex(n))
+		}
+		parts = append(parts, part)
+	}
+	for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+		parts[i], parts[j] = parts[j], parts[i]
+	}
+	return strings.Join(parts, " > ")
+}
+
+// sameTagSiblings reports if another child of the element's parent has the
+// same tag, so the selector needs a position to pick it out
+func sameTagSiblings(n *html.Node) bool {
+	for c := n.Parent.FirstChild; c != nil; c = c.NextSibling {
+		if c != n && c.Type == html.ElementNode && c.Data == n.Data {
+			return true
+		}
+	}
+	return false
+}
+
+// childIndex is the element's position among its parent's element children,
+// counting from 1 like :nth-child
+func childIndex(n *html.Node) int {
+	i := 1
+	for c := n.PrevSibling; c != nil; c = c.PrevSibling {
+		if c.Type == html.ElementNode {
+			i++
+		}
+	}
+	return i
+}
+
+// snippet is the element's HTML, cut short
+func snippet(n *html.Node) string {
+	var buf bytes.Buffer
+	if err := html.Render(&buf, n); err != nil {
+		return ""
+	}
+	s := strings.TrimSpace(buf.String())
+	if len(s) > contextLength {
+		s = strings.ToValidUTF8(s[:contextLength], "") + "..."
+	}
+	return s
+}
+
+// attr returns the value of an attribute, or "" when it isn't set
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Namespace == "" && a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// hasAttr reports if an attribute is set, even to ""
+func hasAttr(n *html.Node, key string) bool {
+	for _, a := range n.Attr {
+		if a.Namespace == "" && a.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// text returns the text inside an element, with the alt text of any images
+// in it, as a screen reader would read it
+func text(n *html.Node) string {
+	var b strings.Builder
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch {
+		case n.Type == html.TextNode:
+			b.WriteString(n.Data)
+		case n.Type == html.ElementNode && n.Data == "img":
+			b.WriteString(attr(n, "alt"))
+		case n.Type == html.ElementNode && hidden(n):
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return strings.TrimSpace(b.String())
+}
+
+// hidden reports if an element is hidden from assistive technology
+func hidden(n *html.Node) bool {
+	return hasAttr(n, "hidden") || attr(n, "aria-hidden") == "true"
+}
+
+// accessibleName returns the name an element gets from ARIA attributes,
+// its content or its title
+func (d *document) accessibleName(n *html.Node) string {
+	if label := strings.TrimSpace(attr(n, "aria-label")); label != "" {
+		return label
+	}
+	if ids := strings.Fields(attr(n, "aria-labelledby")); len(ids) > 0 {
+		var names []string
+		for _, id := range ids {
+			if targets := d.ids[id]; len(targets) > 0 {
+				names = append(names, text(targets[0]))
+			}
+		}
+		if name := strings.TrimSpace(strings.Join(names, " ")); name != "" {
+			return name
+		}
+	}
+	if content := text(n); content != "" {
+		return content
+	}
+	return strings.TrimSpace(attr(n, "title"))
+}
@@ -0,0 +1,95 @@
+package a11y
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+// codes returns the last part of each issue's code, the technique
+func codes(t *testing.T, page string) []string {
+	t.Helper()
+	issues, err := Check(strings.NewReader(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []string
+	for _, issue := range issues {
+		if issue.Runner != Runner || issue.Type != TypeError || issue.Message == "" {
+			t.Errorf("issue = %+v", issue)
+		}
+		parts := strings.SplitN(issue.Code, ".", 5)
+		out = append(out, parts[len(parts)-1])
+	}
+	sort.Strings(out)
+	return out
+}
+
+func TestCleanPage(t *testing.T) {
+	page := `<!doctype html>
+<html lang="en"><head><title>Home</title></head>
+<body>
+<h1>Home</h1>
+<h2>News</h2>
+<img src="a.png" alt="A chart"> <img src="b.png" alt="">
+<a href="/x">Read more</a> <a href="/y"><img src="y.png" alt="Why"></a> <a href="/z" aria-label="Zed"></a>
+<a name="anchor"></a>
+<button>Save</button> <input type="submit"> <input type="button" value="Go">
+<label for="q">Search</label> <input id="q"> <label>Name <input></label>
+<select title="Size"></select> <input type="hidden" name="token">
+<table><tr><th>Name</th><th>Age</th></tr><tr><td>A</td><td>1</td></tr></table>
+<table role="presentation"><tr><td>a</td><td>b</td></tr><tr><td>c</td><td>d</td></tr></table>
+<h3>Details</h3>
+</body></html>`
+	if got := codes(t, page); len(got) != 0 {
+		t.Errorf("Check() of a clean page = %v", got)
+	}
+}
+
+func TestRules(t *testing.T) {
+	tests := []struct {
+		name string
+		page string
+		want string
+	}{
+		{"missing lang", `<html><head><title>t</title></head></html>`, "H57.2"},
+		{"missing title", `<html lang="en"><head></head></html>`, "H25.1.NoTitleEl"},
+		{"empty title", `<html lang="en"><head><title> </title></head></html>`, "H25.1.EmptyTitle"},
+		{"image without alt", `<html lang="en"><title>t</title><img src="a.png">`, "H37"},
+		{"image button without alt", `<html lang="en"><title>t</title><input type="image" src="go.png">`, "H36"},
+		{"empty link", `<html lang="en"><title>t</title><a href="/x"><img src="x.png" alt=""></a>`, "H91.A.NoContent"},
+		{"empty button", `<html lang="en"><title>t</title><button><span></span></button>`, "H91.Button.Name"},
+		{"first heading not h1", `<html lang="en"><title>t</title><h2>a</h2>`, "G141"},
+		{"skipped heading", `<html lang="en"><title>t</title><h1>a</h1><h3>b</h3>`, "G141"},
+		{"unlabeled input", `<html lang="en"><title>t</title><input name="q">`, "F68"},
+		{"unlabeled textarea", `<html lang="en"><title>t</title><label for="other">x</label><textarea id="t"></textarea>`, "F68"},
+		{"duplicate id", `<html lang="en"><title>t</title><p id="a">x</p><p id="a">y</p>`, "F77"},
+		{"table without headers", `<html lang="en"><title>t</title><table><tr><td>a</td><td>b</td></tr><tr><td>c</td><td>d</td></tr></table>`, "H43.HeadersRequired"},
+	}
+	for _, tt := range tests {
+		got := codes(t, tt.page)
+		if len(got) != 1 || got[0] != tt.want {
+			t.Errorf("%s: Check() = %v, want [%s]", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSelectorAndContext(t *testing.T) {
+	page := `<html lang="en"><title>t</title><body>
+<main id="content"><p>one</p><p>two <img src="a.png"></p></main>
+<div><img src="` + strings.Repeat("x", 300) + `.png"></div>
+</body></html>`
+	issues, err := Check(strings.NewReader(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(issues) != 2 {
+		t.Fatalf("Check() = %+v", issues)
+	}
+	if issues[0].Selector != "#content > p:nth-child(2) > img" || issues[0].Context != `<img src="a.png"/>` {
+		t.Errorf("first issue = %+v", issues[0])
+	}
+	if issues[1].Selector != "html > body > div > img" || len(issues[1].Context) != contextLength+3 {
+		t.Errorf("second issue = %+v", issues[1])
+	}
+}
@@ -0,0 +1,231 @@
+package a11y
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// rules are run in this order, and their issues come out in it
+var rules = []rule{
+	{"WCAG2AA.Principle3.Guideline3_1.3_1_1.H57.2", TypeError, missingLang},
+	{"WCAG2AA.Principle2.Guideline2_4.2_4_2.H25.1.NoTitleEl", TypeError, missingTitle},
+	{"WCAG2AA.Principle2.Guideline2_4.2_4_2.H25.1.EmptyTitle", TypeError, emptyTitle},
+	{"WCAG2AA.Principle1.Guideline1_1.1_1_1.H37", TypeError, imagesWithoutAlt},
+	{"WCAG2AA.Principle1.Guideline1_1.1_1_1.H36", TypeError, imageButtonsWithoutAlt},
+	{"WCAG2AA.Principle4.Guideline4_1.4_1_2.H91.A.NoContent", TypeError, emptyLinks},
+	{"WCAG2AA.Principle4.Guideline4_1.4_1_2.H91.Button.Name", TypeError, emptyButtons},
+	{"WCAG2AA.Principle1.Guideline1_3.1_3_1_A.G141", TypeError, skippedHeadings},
+	{"WCAG2AA.Principle1.Guideline1_3.1_3_1.F68", TypeError, unlabeledControls},
+	{"WCAG2AA.Principle4.Guideline4_1.4_1_1.F77", TypeError, duplicateIDs},
+	{"WCAG2AA.Principle1.Guideline1_3.1_3_1.H43.HeadersRequired", TypeError, tablesWithoutHeaders},
+}
+
+func missingLang(d *document) []finding {
+	for _, n := range d.byTag("html") {
+		if strings.TrimSpace(attr(n, "lang")) == "" && strings.TrimSpace(attr(n, "xml:lang")) == "" {
+			return []finding{{n, "The html element should have a lang or xml:lang attribute which describes the language of the document."}}
+		}
+	}
+	return nil
+}
+
+func missingTitle(d *document) []finding {
+	if len(d.byTag("title")) == 0 {
+		if heads := d.byTag("head"); len(heads) > 0 {
+			return []finding{{heads[0], "A title should be provided for the document, using a non-empty title element in the head section."}}
+		}
+	}
+	return nil
+}
+
+func emptyTitle(d *document) []finding {
+	var found []finding
+	for _, n := range d.byTag("title") {
+		if text(n) == "" {
+			found = append(found, finding{n, "The title element in the head section should be non-empty."})
+		}
+	}
+	return found
+}
+
+func imagesWithoutAlt(d *document) []finding {
+	var found []finding
+	for _, n := range d.byTag("img") {
+		// alt="" is fine, it marks the image as decorative
+		if !hasAttr(n, "alt") && !hidden(n) && attr(n, "role") != "presentation" && attr(n, "role") != "none" {
+			found = append(found, finding{n, "Img element missing an alt attribute. Use the alt attribute to specify a short text alternative."})
+		}
+	}
+	return found
+}
+
+func imageButtonsWithoutAlt(d *document) []finding {
+	var found []finding
+	for _, n := range d.byTag("input") {
+		if strings.EqualFold(attr(n, "type"), "image") && strings.TrimSpace(attr(n, "alt")) == "" && d.accessibleName(n) == "" {
+			found = append(found, finding{n, "Image submit button missing an alt attribute. Specify a text alternative that describes the button's function, using the alt attribute."})
+		}
+	}
+	return found
+}
+
+func emptyLinks(d *document) []finding {
+	var found []finding
+	for _, n := range d.byTag("a") {
+		if hasAttr(n, "href") && !hidden(n) && d.accessibleName(n) == "" {
+			found = append(found, finding{n, "Anchor element found with a valid href attribute, but no link content has been supplied."})
+		}
+	}
+	return found
+}
+
+func emptyButtons(d *document) []finding {
+	var found []finding
+	for _, n := range d.byTag("button", "input") {
+		if hidden(n) {
+			continue
+		}
+		name := d.accessibleName(n)
+		if n.Data == "input" {
+			switch strings.ToLower(attr(n, "type")) {
+			case "button":
+				name += strings.TrimSpace(attr(n, "value"))
+			default:
+				// submit and reset have a default name, other inputs aren't buttons
+				continue
+			}
+		}
+		if name == "" {
+			found = append(found, finding{n, "This " + n.Data + " element does not have a name available to an accessibility API. Valid names are: element content, aria-label, aria-labelledby or title."})
+		}
+	}
+	return found
+}
+
+// headingLevel returns 1 to 6 for h1 to h6, or 0
+func headingLevel(n *html.Node) int {
+	if len(n.Data) == 2 && n.Data[0] == 'h' && n.Data[1] >= '1' && n.Data[1] <= '6' {
+		return int(n.Data[1] - '0')
+	}
+	return 0
+}
+
+func skippedHeadings(d *document) []finding {
+	var found []finding
+	last := 0
+	for _, n := range d.elements {
+		level := headingLevel(n)
+		if level == 0 {
+			continue
+		}
+		switch {
+		case last == 0 && level > 1:
+			found = append(found, finding{n, fmt.Sprintf("The heading structure is not logically nested. This h%d element appears to be the primary document heading, so should be an h1 element.", level)})
+		case last > 0 && level > last+1:
+			found = append(found, finding{n, fmt.Sprintf("The heading structure is not logically nested. This h%d element should be an h%d to be properly nested.", level, last+1)})
+		}
+		last = level
+	}
+	return found
+}
+
+func unlabeledControls(d *document) []finding {
+	var found []finding
+	for _, n := range d.byTag("input", "select", "textarea") {
+		if n.Data == "input" {
+			switch strings.ToLower(attr(n, "type")) {
+			case "hidden", "submit", "reset", "button", "image":
+				continue
+			}
+		}
+		if hidden(n) || d.labelled(n) {
+			continue
+		}
+		found = append(found, finding{n, `This form field should be labelled in some way. Use the label element (either with a "for" attribute or wrapped around the form field), or "title", "aria-label" or "aria-labelledby" attributes as appropriate.`})
+	}
+	return found
+}
+
+// labelled reports if a form control has a label, from a label element or
+// an attribute
+func (d *document) labelled(n *html.Node) bool {
+	if id := attr(n, "id"); id != "" && d.labelFor[id] {
+		return true
+	}
+	for p := n.Parent; p != nil; p = p.Parent {
+		if p.Type == html.ElementNode && p.Data == "label" {
+			return true
+		}
+	}
+	for _, key := range []string{"title", "aria-label", "aria-labelledby"} {
+		if strings.TrimSpace(attr(n, key)) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func duplicateIDs(d *document) []finding {
+	var found []finding
+	for _, n := range d.elements {
+		id := attr(n, "id")
+		// Report each element after the first with the id
+		if id != "" && len(d.ids[id]) > 1 && d.ids[id][0] != n {
+			found = append(found, finding{n, fmt.Sprintf("Duplicate id attribute value %q found on the web page.", id)})
+		}
+	}
+	return found
+}
+
+func tablesWithoutHeaders(d *document) []finding {
+	var found []finding
+	for _, table := range d.byTag("table") {
+		switch attr(table, "role") {
+		case "presentation", "none":
+			continue
+		}
+		cells, headers := 0, 0
+		var count func(n *html.Node)
+		count = func(n *html.Node) {
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				if c.Type != html.ElementNode || c.Data == "table" {
+					continue // nested tables are checked on their own
+				}
+				switch c.Data {
+				case "td":
+					cells++
+				case "th":
+					headers++
+				}
+				count(c)
+			}
+		}
+		count(table)
+		// One row or column of cells is usually layout, not data
+		if headers == 0 && cells > 1 && rows(table) > 1 {
+			found = append(found, finding{table, "The relationship between td elements and their associated th elements is not defined. Use th elements for the header cells of this data table."})
+		}
+	}
+	return found
+}
+
+// rows counts the rows of a table, leaving out nested tables
+func rows(table *html.Node) int {
+	n := 0
+	var walk func(node *html.Node)
+	walk = func(node *html.Node) {
+		for c := node.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode || c.Data == "table" {
+				continue
+			}
+			if c.Data == "tr" {
+				n++
+			}
+			walk(c)
+		}
+	}
+	walk(table)
+	return n
+}
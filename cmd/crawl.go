@@ -11,6 +11,9 @@ import (
 	"github.com/spf13/cobra"
 )
 
+// replayFrom is set by --replay to reprocess an earlier crawl offline
+var replayFrom string
+
 var crawlCmd = &cobra.Command{
 	Use:   "crawl [config.site.json]",
 	Short: "Crawl a website and save HTML files",
@@ -31,6 +34,12 @@ var crawlCmd = &cobra.Command{
 		if err != nil {
 			log.Fatal("Error loading config:", err)
 		}
+		if replayFrom != "" {
+			cfg.ReplayFrom = replayFrom
+			if err := cfg.ValidateReplay(); err != nil {
+				log.Fatal("Error with --replay:", err)
+			}
+		}
 
 		// 2. Init logger
 		logDir := cfg.LogPath
@@ -52,7 +61,7 @@ var crawlCmd = &cobra.Command{
 			log.Fatal("Error with database configuration:", err)
 		}
 
-		store, err := storage.New(dbPath)
+		store, err := storage.NewStore(cfg.DBBackend, dbPath)
 		if err != nil {
 			appLogger.Error("Error opening database:", err)
 			os.Exit(1)
@@ -68,8 +77,9 @@ var crawlCmd = &cobra.Command{
 		appLogger.Info("Beginning crawl")
 		c.Crawl()
 
-		// 6. Tell me the crawl is done
-		appLogger.Info("Finished crawl")
+		// 6. Tell me the crawl is done, and if a budget cut it short
+		outcome, budget := c.Outcome()
+		appLogger.Info("Finished crawl:", outcome, budget)
 
 		// 7. Tidy up
 		store.Close()
@@ -79,5 +89,6 @@ var crawlCmd = &cobra.Command{
 }
 
 func init() {
+	crawlCmd.Flags().StringVar(&replayFrom, "replay", "", "replay an earlier crawl instead of the live site, from 'warc' or 'files'")
 	rootCmd.AddCommand(crawlCmd)
 }
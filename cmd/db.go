@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"boem-web-thing/config"
+	"boem-web-thing/storage"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "Look after the database schema",
+}
+
+var dbStatusCmd = &cobra.Command{
+	Use:   "status [config.json]",
+	Short: "Show the schema version and which migrations have been applied",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+
+		store := openForMigrations(args)
+		defer store.Close()
+
+		version, err := store.SchemaVersion()
+		if err != nil {
+			log.Fatal("Error reading schema version:", err)
+		}
+		status, err := store.MigrationStatus()
+		if err != nil {
+			log.Fatal("Error reading migrations:", err)
+		}
+
+		fmt.Printf("Schema version %d of %d\n\n", version, storage.LatestSchemaVersion())
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tAPPLIED\tNAME")
+		for _, m := range status {
+			applied := "pending"
+			if m.Applied {
+				applied = m.Applied_at.Format(time.DateTime)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", m.Version, applied, m.Name)
+		}
+		w.Flush()
+	},
+}
+
+var dbMigrateCmd = &cobra.Command{
+	Use:   "migrate [config.json]",
+	Short: "Back up the database and apply any pending migrations",
+	Long: `Back up the database and apply any pending migrations. Migrations also
+run on their own whenever the database is opened, this runs them on demand.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+
+		store := openForMigrations(args)
+		defer store.Close()
+
+		applied, backup, err := store.Migrate()
+		if backup != "" {
+			fmt.Println("Backed up the database to", backup)
+		}
+		for _, m := range applied {
+			fmt.Printf("Applied migration %d: %s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatal("Error migrating database:", err)
+		}
+		if len(applied) == 0 {
+			fmt.Println("Schema is up to date at version", storage.LatestSchemaVersion())
+		}
+	},
+}
+
+func init() {
+	dbCmd.AddCommand(dbStatusCmd)
+	dbCmd.AddCommand(dbMigrateCmd)
+	rootCmd.AddCommand(dbCmd)
+}
+
+// openForMigrations opens the configured database without migrating it
+func openForMigrations(args []string) *storage.Storage {
+
+	configPath := "config.json" // default
+
+	if len(args) == 1 {
+		configPath = args[0]
+	}
+
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		log.Fatal("Error loading config:", err)
+	}
+
+	if cfg.DBBackend != storage.BackendSQLite {
+		log.Fatal("Schema migrations only apply to the sqlite backend, this config uses ", cfg.DBBackend)
+	}
+
+	store, err := storage.Open(cfg.DBFilePath)
+	if err != nil {
+		log.Fatal("Error opening database:", err)
+	}
+	return store
+}
@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"boem-web-thing/config"
+	"boem-web-thing/diff"
+	"boem-web-thing/storage"
+	"io"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	diffFormat string // text, json or html
+	diffOutput string // file to write the report to, stdout when empty
+)
+
+var diffCmd = &cobra.Command{
+	Use:   "diff [from run id] [to run id] [config.json]",
+	Short: "Compare two crawl runs and list what changed between them",
+	Long: `Compare two crawl runs and list the pages added, removed and changed,
+status code changes, newly broken links and new accessibility issues.
+Run ids are listed by the runs command.`,
+	Args: cobra.RangeArgs(2, 3),
+	Run: func(cmd *cobra.Command, args []string) {
+
+		fromID, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			log.Fatal("Bad from run id:", err)
+		}
+		toID, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			log.Fatal("Bad to run id:", err)
+		}
+
+		configPath := "config.json" // default
+
+		if len(args) == 3 {
+			configPath = args[2]
+		}
+
+		cfg, err := config.LoadConfig(configPath)
+		if err != nil {
+			log.Fatal("Error loading config:", err)
+		}
+
+		store, err := storage.NewStore(cfg.DBBackend, cfg.DBFilePath)
+		if err != nil {
+			log.Fatal("Error opening database:", err)
+		}
+		defer store.Close()
+
+		from, err := loadRun(store, fromID)
+		if err != nil {
+			log.Fatal("Error reading run ", fromID, ": ", err)
+		}
+		to, err := loadRun(store, toID)
+		if err != nil {
+			log.Fatal("Error reading run ", toID, ": ", err)
+		}
+
+		var out io.Writer = os.Stdout
+		if diffOutput != "" {
+			f, err := os.Create(diffOutput)
+			if err != nil {
+				log.Fatal("Error creating output file:", err)
+			}
+			defer f.Close()
+			out = f
+		}
+
+		if err := diff.Write(out, diff.Compare(from, to), diffFormat); err != nil {
+			log.Fatal("Error writing diff:", err)
+		}
+	},
+}
+
+func init() {
+	diffCmd.Flags().StringVar(&diffFormat, "format", diff.FormatText, "output format, text, json or html")
+	diffCmd.Flags().StringVarP(&diffOutput, "output", "o", "", "write the report to this file instead of stdout")
+	rootCmd.AddCommand(diffCmd)
+}
+
+// loadRun reads a crawl run with the pages and links it saw
+func loadRun(store storage.Store, runID int64) (diff.Run, error) {
+	run, err := store.GetRun(runID)
+	if err != nil {
+		return diff.Run{}, err
+	}
+	pages, err := store.GetRunPages(runID)
+	if err != nil {
+		return diff.Run{}, err
+	}
+	links, err := store.GetRunLinks(runID)
+	if err != nil {
+		return diff.Run{}, err
+	}
+	return diff.Run{Run: run, Pages: pages, Links: links}, nil
+}
@@ -0,0 +1,176 @@
+package cmd
+
+import (
+	"boem-web-thing/config"
+	"boem-web-thing/export"
+	"boem-web-thing/storage"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	exportFormat   string   // csv, excel, jsonl or tsv
+	exportOutput   string   // file to write to, stdout when empty
+	exportColumns  []string // columns to write, every default column when empty
+	exportListCols bool     // list the columns instead of exporting
+
+	// Filters, see storage.PageQuery
+	exportHosts        []string
+	exportStatus       []int
+	exportContentTypes []string
+	exportPathPrefix   string
+	exportRun          int64
+	exportScanRun      int64
+	exportSince        string
+	exportUntil        string
+	exportScanState    string
+	exportScanStatus   []string
+	exportMinTotalMs   float64
+	exportLimit        int
+	exportOffset       int
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export [pages|links|redirects|issues] [config.json]",
+	Short: "Export pages, links, redirects or scan issues to CSV or JSON Lines",
+	Long: `Export a dataset from the database for use in a spreadsheet or other tools.
+
+Datasets:
+  pages      every fetched page with its status, type, sizes and timings
+  links      links between pages, from the latest run unless --run is given
+  redirects  pages whose URL redirected, with where they ended up
+  issues     accessibility issues from the latest scan unless --scan is given
+
+Formats are csv, excel (CSV with a UTF-8 byte order mark and CRLF line
+endings), jsonl (one JSON object per line) and tsv (tab separated text).
+Use --list-columns to see the columns of a dataset and --columns to pick them.`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+
+		dataset := args[0]
+		if exportListCols {
+			cols, err := export.Columns(dataset)
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Println(strings.Join(cols, "\n"))
+			return
+		}
+
+		configPath := "config.json" // default
+
+		if len(args) == 2 {
+			configPath = args[1]
+		}
+
+		cfg, err := config.LoadConfig(configPath)
+		if err != nil {
+			log.Fatal("Error loading config:", err)
+		}
+
+		q, err := exportQuery()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		store, err := storage.NewStore(cfg.DBBackend, cfg.DBFilePath)
+		if err != nil {
+			log.Fatal("Error opening database:", err)
+		}
+		defer store.Close()
+
+		table, err := export.Build(store, dataset, export.Filter{PageQuery: q, ScanRunID: exportScanRun}, exportColumns)
+		if err != nil {
+			log.Fatal("Error reading ", dataset, ": ", err)
+		}
+
+		var out io.Writer = os.Stdout
+		if exportOutput != "" {
+			f, err := os.Create(exportOutput)
+			if err != nil {
+				log.Fatal("Error creating output file:", err)
+			}
+			defer f.Close()
+			out = f
+		}
+
+		if err := export.Write(out, table, exportFormat); err != nil {
+			log.Fatal("Error writing export:", err)
+		}
+		if exportOutput != "" {
+			fmt.Printf("Wrote %d %s to %s\n", len(table.Rows), dataset, exportOutput)
+		}
+	},
+}
+
+func init() {
+	f := exportCmd.Flags()
+	f.StringVar(&exportFormat, "format", export.FormatCSV, "output format, csv, excel, jsonl or tsv")
+	f.StringVarP(&exportOutput, "output", "o", "", "write to this file instead of stdout")
+	f.StringSliceVar(&exportColumns, "columns", nil, "comma separated columns to write, in order")
+	f.BoolVar(&exportListCols, "list-columns", false, "list the columns of the dataset and exit")
+	f.StringSliceVar(&exportHosts, "host", nil, "only these hosts")
+	f.IntSliceVar(&exportStatus, "status", nil, "only these status codes")
+	f.StringSliceVar(&exportContentTypes, "content-type", nil, "only these content types, like text/html or image/*")
+	f.StringVar(&exportPathPrefix, "path-prefix", "", "only URL paths starting with this")
+	f.Int64Var(&exportRun, "run", 0, "only pages last fetched by this crawl run, or the links of this run")
+	f.Int64Var(&exportScanRun, "scan", 0, "issues found by this scan run, the latest by default")
+	f.StringVar(&exportSince, "since", "", "only pages fetched at or after this date or RFC 3339 time")
+	f.StringVar(&exportUntil, "until", "", "only pages fetched before this date or RFC 3339 time")
+	f.StringVar(&exportScanState, "scan-state", "", "scanned or unscanned")
+	f.StringSliceVar(&exportScanStatus, "scan-status", nil, "only pages whose last scan was ok, issues, failed, timed_out or unchanged")
+	f.Float64Var(&exportMinTotalMs, "min-total-ms", 0, "only pages that took at least this many milliseconds to fetch")
+	f.IntVar(&exportLimit, "limit", 0, "at most this many rows")
+	f.IntVar(&exportOffset, "offset", 0, "skip this many rows first")
+	rootCmd.AddCommand(exportCmd)
+}
+
+// exportQuery builds the page query from the filter flags
+func exportQuery() (storage.PageQuery, error) {
+	q := storage.PageQuery{
+		Hosts:        exportHosts,
+		StatusCodes:  exportStatus,
+		ContentTypes: exportContentTypes,
+		PathPrefix:   exportPathPrefix,
+		RunID:        exportRun,
+		MinTotalMs:   exportMinTotalMs,
+		ScanStatuses: exportScanStatus,
+		Limit:        exportLimit,
+		Offset:       exportOffset,
+	}
+	switch storage.ScanState(exportScanState) {
+	case storage.ScanStateAny, storage.ScanStateScanned, storage.ScanStateUnscanned:
+		q.ScanState = storage.ScanState(exportScanState)
+	default:
+		return q, fmt.Errorf("unknown --scan-state %q, use scanned or unscanned", exportScanState)
+	}
+	if exportLimit < 0 || exportOffset < 0 {
+		return q, fmt.Errorf("--limit and --offset can't be negative")
+	}
+	var err error
+	if q.FetchedAfter, err = parseExportTime(exportSince); err != nil {
+		return q, fmt.Errorf("bad --since: %w", err)
+	}
+	if q.FetchedBefore, err = parseExportTime(exportUntil); err != nil {
+		return q, fmt.Errorf("bad --until: %w", err)
+	}
+	return q, nil
+}
+
+// parseExportTime reads a date like 2024-05-01, in local time, or an RFC 3339
+// time. An empty string is the zero time, which doesn't filter.
+func parseExportTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, s, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
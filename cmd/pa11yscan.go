@@ -1,31 +1,48 @@
 package cmd
 
 import (
+	"boem-web-thing/config"
 	"boem-web-thing/scanner"
+	"context"
+	"errors"
 	"fmt"
+	"io/fs"
+	"log"
 
 	"github.com/spf13/cobra"
 )
 
+var (
+	pa11yConfigPath string // config.json to read pa11y_path, node_path and pa11y_config from
+	pa11yPath       string // pa11y program, overrides pa11y_path
+	nodePath        string // directory node is in, overrides node_path
+)
+
 var pa11yCmd = &cobra.Command{
 	Use:   "pa11y [path/to/html] [override pa11y config file]",
 	Short: "Run a pa11y scan (pa11y must be installed with NPM)",
-	Args:  cobra.MaximumNArgs(2),
+	Args:  cobra.RangeArgs(1, 2),
 	Run: func(cmd *cobra.Command, args []string) {
 
-		// url := args[0]
-		// fmt.Printf("Crawling %s...\n", url)
-
-		htmlpath := ""        // default
-		pa11yConfigFile := "" // no default commands
+		htmlpath := args[0]
 
-		if len(args) >= 1 {
-			htmlpath = args[0]
+		// Find pa11y and its config the same way sitescan does
+		cfg, err := config.LoadConfig(pa11yConfigPath)
+		if errors.Is(err, fs.ErrNotExist) {
+			cfg = &config.Config{Pa11yConfig: config.DefaultPa11yConfig}
+		} else if err != nil {
+			log.Fatal("Error loading config:", err)
+		}
+		if pa11yPath != "" {
+			cfg.Pa11yPath = pa11yPath
+		}
+		if nodePath != "" {
+			cfg.NodePath = nodePath
 		}
 		if len(args) == 2 {
-			pa11yConfigFile = args[1]
+			cfg.Pa11yConfig = args[1]
 		}
-		result, err := RunPa11y(htmlpath, pa11yConfigFile)
+		result, err := RunPa11y(htmlpath, cfg)
 		if err != nil {
 			fmt.Printf("ERROR %s...\n", err.Error())
 			//} else {
@@ -35,13 +52,24 @@ var pa11yCmd = &cobra.Command{
 }
 
 func init() {
+	pa11yCmd.Flags().StringVar(&pa11yConfigPath, "config", "config.json", "config file to take pa11y_path, node_path and pa11y_config from, the defaults are used when it doesn't exist")
+	pa11yCmd.Flags().StringVar(&pa11yPath, "pa11y-path", "", "pa11y program to run, instead of the config's pa11y_path")
+	pa11yCmd.Flags().StringVar(&nodePath, "node-path", "", "directory the node program is in, instead of the config's node_path")
 	rootCmd.AddCommand(pa11yCmd)
 }
 
-// RunPa11y runs pa11y CLI on a given HTML file and returns the output
-func RunPa11y(filePath string, pa11yConfigFile string) (string, error) {
+// RunPa11y runs pa11y CLI on a given HTML file with the pa11y settings in
+// the config and returns the output
+func RunPa11y(filePath string, cfg *config.Config) (string, error) {
 
-	results, err := scanner.ScanWithPa11y(filePath, pa11yConfigFile)
-	return results, err
+	pa11y, err := scanner.NewPa11y(cfg.Pa11yPath, cfg.NodePath, cfg.Pa11yConfig)
+	if err != nil {
+		return "", err
+	}
+	result, err := pa11y.Scan(context.Background(), filePath)
+	if err != nil {
+		return "", fmt.Errorf("scan %s: %w", result.Status, err)
+	}
+	return result.Report, nil
 
 }
@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"boem-web-thing/config"
+	"boem-web-thing/storage"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// runConfigID is set by --config to print the config a run was made with
+var runConfigID int64
+
+var runsCmd = &cobra.Command{
+	Use:   "runs [config.json]",
+	Short: "List the crawl runs recorded in the database",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+
+		configPath := "config.json" // default
+
+		if len(args) == 1 {
+			configPath = args[0]
+		}
+
+		cfg, err := config.LoadConfig(configPath)
+		if err != nil {
+			log.Fatal("Error loading config:", err)
+		}
+
+		store, err := storage.NewStore(cfg.DBBackend, cfg.DBFilePath)
+		if err != nil {
+			log.Fatal("Error opening database:", err)
+		}
+		defer store.Close()
+
+		if runConfigID != 0 {
+			run, err := store.GetRun(runConfigID)
+			if err != nil {
+				log.Fatal("Error reading crawl run:", err)
+			}
+			fmt.Println(run.Config)
+			return
+		}
+
+		runs, err := store.GetRuns()
+		if err != nil {
+			log.Fatal("Error reading crawl runs:", err)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tSTARTED\tFINISHED\tOUTCOME\tPAGES\tBYTES\tSTART URL")
+		for _, run := range runs {
+			finished := "-"
+			if run.Finished_at.Valid {
+				finished = run.Finished_at.Time.Format(time.DateTime)
+			}
+			outcome := run.Outcome
+			if run.Budget != "" {
+				outcome += " (" + run.Budget + ")"
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%d\t%d\t%s\n", run.Id, run.Started_at.Format(time.DateTime), finished, outcome, run.Pages_fetched, run.Bytes_fetched, run.Start_url)
+		}
+		w.Flush()
+	},
+}
+
+func init() {
+	runsCmd.Flags().Int64Var(&runConfigID, "config", 0, "print the config snapshot of the run with this id")
+	rootCmd.AddCommand(runsCmd)
+}
@@ -9,9 +9,17 @@ import (
 	"github.com/spf13/cobra"
 )
 
+// retryFailed is set by --retry-failed to scan only the pages whose last scan
+// failed or timed out
+var retryFailed bool
+
+// force is set by --force to scan pages that haven't changed since their last
+// scan as well
+var force bool
+
 var sitescanCmd = &cobra.Command{
 	Use:   "sitescan [config.json]",
-	Short: "Run a full site scan based on the site defined in the JSON configuration file(pa11y must be installed with NPM)",
+	Short: "Run a full site scan based on the site defined in the JSON configuration file, with the scanners it lists",
 	Args:  cobra.MaximumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 
@@ -31,6 +39,8 @@ var sitescanCmd = &cobra.Command{
 }
 
 func init() {
+	sitescanCmd.Flags().BoolVar(&retryFailed, "retry-failed", false, "only scan pages whose last scan failed or timed out")
+	sitescanCmd.Flags().BoolVar(&force, "force", false, "scan pages that haven't changed since their last scan too")
 	rootCmd.AddCommand(sitescanCmd)
 }
 
@@ -41,6 +51,9 @@ func runSiteScan(configPath string) (string, error) {
 	if err != nil {
 		log.Fatal("Error loading config:", err)
 	}
+	if force {
+		cfg.ScanUnchanged = true
+	}
 
 	// 2, 3 initial the logger and the storage
 	appLogger, store, err := cfg.InitializeApp()
@@ -54,7 +67,16 @@ func runSiteScan(configPath string) (string, error) {
 
 	// 5. Start crawling
 	appLogger.Info("Beginning scan")
-	s.ScanSite()
+	scan := s.ScanSite
+	if retryFailed {
+		scan = s.RetryFailed
+	}
+	if err := scan(); err != nil {
+		appLogger.Error("Scan failed:", err)
+		store.Close()
+		appLogger.Close()
+		return "", err
+	}
 
 	// 6. Tell me the crawl is done
 	appLogger.Info("Finished scan")
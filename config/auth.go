@@ -0,0 +1,146 @@
+package config
+
+import (
+	"net/url"
+	"os"
+	"strings"
+)
+
+// HostAuth is the credentials sent to a single host. Secrets can be given
+// directly or, better, named by an environment variable so they stay out of
+// the config file. Use either a username and password or a bearer token.
+type HostAuth struct {
+	Host           string `json:"host"`
+	Username       string `json:"username"`
+	UsernameEnv    string `json:"username_env"`
+	Password       string `json:"password"`
+	PasswordEnv    string `json:"password_env"`
+	BearerToken    string `json:"bearer_token"`
+	BearerTokenEnv string `json:"bearer_token_env"`
+}
+
+// LoginForm describes a form to submit before the crawl starts, so the cookie
+// jar holds a logged in session. The form is read from URL, its hidden fields
+// (like CSRF tokens) are kept, and Fields are filled in on top.
+type LoginForm struct {
+	URL         string            `json:"url"`
+	Action      string            `json:"action"`       // optional, defaults to the form's own action
+	Fields      map[string]string `json:"fields"`       // form field name to value
+	FieldEnvs   map[string]string `json:"field_envs"`   // form field name to environment variable
+	SuccessText string            `json:"success_text"` // optional text that only shows when logged in
+}
+
+// defaultLogoutPatterns keep an authenticated crawl from ending its own session
+var defaultLogoutPatterns = []string{"logout", "log-out", "log_out", "logoff", "log-off", "signout", "sign-out", "sign_out"}
+
+// Basic returns the username and password for basic auth, if set
+func (a HostAuth) Basic() (string, string, bool) {
+	user := fromEnv(a.Username, a.UsernameEnv)
+	pass := fromEnv(a.Password, a.PasswordEnv)
+	return user, pass, user != ""
+}
+
+// Bearer returns the bearer token, if set
+func (a HostAuth) Bearer() (string, bool) {
+	token := fromEnv(a.BearerToken, a.BearerTokenEnv)
+	return token, token != ""
+}
+
+// Values returns the fields to submit with environment variables filled in
+func (f *LoginForm) Values() map[string]string {
+	values := make(map[string]string, len(f.Fields)+len(f.FieldEnvs))
+	for name, value := range f.Fields {
+		values[name] = value
+	}
+	for name, env := range f.FieldEnvs {
+		values[name] = os.Getenv(env)
+	}
+	return values
+}
+
+// HasAuth reports if the crawl logs in or sends credentials anywhere
+func (c *Config) HasAuth() bool {
+	return len(c.Auth) > 0 || c.LoginForm != nil
+}
+
+// IsLogoutURL reports if following the URL could end an authenticated session
+func (c *Config) IsLogoutURL(rawURL string) bool {
+	lower := strings.ToLower(rawURL)
+	for _, pattern := range c.LogoutPatterns {
+		if pattern != "" && strings.Contains(lower, strings.ToLower(pattern)) {
+			return true
+		}
+	}
+	return false
+}
+
+// fromEnv prefers the environment variable when one is named and set
+func fromEnv(value, env string) string {
+	if env != "" {
+		if v, ok := os.LookupEnv(env); ok {
+			return v
+		}
+	}
+	return value
+}
+
+// redactedValue stands in for secrets in config snapshots
+const redactedValue = "[redacted]"
+
+// redacted returns a copy of the config with passwords, tokens, login form
+// values and credential headers replaced. Environment variable names are kept
+// as they are not secret themselves.
+func (c *Config) redacted() *Config {
+	cp := *c
+	cp.Auth = make([]HostAuth, len(c.Auth))
+	for i, a := range c.Auth {
+		a.Password = redact(a.Password)
+		a.BearerToken = redact(a.BearerToken)
+		cp.Auth[i] = a
+	}
+	if c.LoginForm != nil {
+		form := *c.LoginForm
+		form.Fields = make(map[string]string, len(c.LoginForm.Fields))
+		for name, value := range c.LoginForm.Fields {
+			form.Fields[name] = redact(value)
+		}
+		cp.LoginForm = &form
+	}
+	if c.HostHeaders != nil {
+		cp.HostHeaders = make(map[string]map[string]string, len(c.HostHeaders))
+		for host, headers := range c.HostHeaders {
+			cp.HostHeaders[host] = make(map[string]string, len(headers))
+			for name, value := range headers {
+				if isCredentialHeader(name) {
+					value = redact(value)
+				}
+				cp.HostHeaders[host][name] = value
+			}
+		}
+	}
+	if cp.ProxyURL != "" {
+		if u, err := url.Parse(cp.ProxyURL); err == nil && u.User != nil {
+			u.User = url.User(redactedValue)
+			cp.ProxyURL = u.String()
+		}
+	}
+	return &cp
+}
+
+func redact(value string) string {
+	if value == "" {
+		return ""
+	}
+	return redactedValue
+}
+
+// isCredentialHeader reports if a header is likely to carry a secret
+func isCredentialHeader(name string) bool {
+	lower := strings.ToLower(name)
+	for _, word := range []string{"authorization", "cookie", "token", "secret", "key", "password"} {
+		if strings.Contains(lower, word) {
+			return true
+		}
+	}
+	return false
+}
@@ -4,7 +4,9 @@ import (
 	"boem-web-thing/logger"
 	"boem-web-thing/storage"
 	"encoding/json"
+	"fmt"
 	"log"
+	"net/url"
 	"os"
 	"time"
 )
@@ -14,6 +16,7 @@ type Config struct {
 	StartURL      string   `json:"start_url"`
 	OutputDir     string   `json:"output_dir"`
 	DBFilePath    string   `json:"db_file_path"`
+	DBBackend     string   `json:"db_backend"`
 	LogPath       string   `json:"log_path"`
 	LogLevel      string   `json:"log_level"`
 	Concurrency   int      `json:"concurrency"`
@@ -23,8 +26,99 @@ type Config struct {
 	AllowedHosts  []string `json:"allowed_hosts"`
 	RateMs        int      `json:"rate_ms"`
 	HTTPTimeout   int      `json:"http_timeout_seconds"`
+
+	// Crawl budgets, zero means unlimited
+	MaxPages           int            `json:"max_pages"`
+	MaxBytes           int64          `json:"max_bytes"`
+	MaxDurationSeconds int            `json:"max_duration_seconds"`
+	MaxPagesPerHost    int            `json:"max_pages_per_host"`
+	MaxPagesPerPrefix  map[string]int `json:"max_pages_per_prefix"`
+
+	// Download policy, responses that fail it only have their metadata recorded
+	MaxBodyBytes      int64    `json:"max_body_bytes"`
+	StoreContentTypes []string `json:"store_content_types"`
+	SkipContentTypes  []string `json:"skip_content_types"`
+
+	// Output format, "files" for the flat files in OutputDir, "warc" for WARC
+	// archives in WARCDir, or "both"
+	OutputFormat     string `json:"output_format"`
+	WARCDir          string `json:"warc_dir"`
+	WARCMaxFileBytes int64  `json:"warc_max_file_bytes"`
+
+	// Replay a previous crawl instead of using the network, "warc" reads the
+	// files in WARCDir, "files" reads OutputDir using the pages in the database
+	ReplayFrom string `json:"replay_from"`
+
+	// Mirror mode downloads the images, stylesheets and scripts pages need and
+	// rewrites saved HTML and CSS to link to the local copies
+	Mirror               bool     `json:"mirror"`
+	MirrorRequisiteHosts []string `json:"mirror_requisite_hosts"`
+
+	// Authentication, see auth.go
+	Auth           []HostAuth `json:"auth"`
+	LoginForm      *LoginForm `json:"login_form"`
+	LogoutPatterns []string   `json:"logout_patterns"`
+
+	// Transport options. HostHeaders maps a host, or "*" for every host, to
+	// extra request headers. InsecureTLSHosts skips certificate checks for
+	// just those hosts and should only name staging sites.
+	HostHeaders      map[string]map[string]string `json:"host_headers"`
+	ProxyURL         string                       `json:"proxy_url"`
+	CABundle         string                       `json:"ca_bundle"`
+	InsecureTLSHosts []string                     `json:"insecure_tls_hosts"`
+
+	// Accessibility scans, ScanWorkers pages are scanned at once and each scan
+	// is stopped after ScanTimeoutSeconds. Scans that fail or time out are
+	// tried again up to ScanRetries times.
+	ScanWorkers        int `json:"scan_workers"`
+	ScanTimeoutSeconds int `json:"scan_timeout_seconds"`
+	ScanRetries        int `json:"scan_retries"`
+
+	// ScanFiles scans the saved files by path instead of serving them on
+	// 127.0.0.1, where root-relative assets, fonts and scripts load
+	ScanFiles bool `json:"scan_files"`
+
+	// ScanUnchanged scans every page. Otherwise pages are skipped when their
+	// content is the same as at their last successful scan with the same
+	// scanners and scanner settings.
+	ScanUnchanged bool `json:"scan_unchanged"`
+
+	// Scanners sitescan runs on every page, by the names they are registered
+	// under in the scanner package: pa11y, axe, validator or go
+	Scanners []string `json:"scanners"`
+
+	// Where to find pa11y and Node, see scanner.NewPa11y. Pa11yConfig is the
+	// pa11y config file scans use.
+	Pa11yPath   string `json:"pa11y_path"`
+	NodePath    string `json:"node_path"`
+	Pa11yConfig string `json:"pa11y_config"`
+
+	// Where to find axe and the HTML validator, see scanner.NewAxe and
+	// scanner.NewValidator
+	AxePath       string `json:"axe_path"`
+	ValidatorPath string `json:"validator_path"`
+
+	// Several sites in one crawl, see site.go. When empty, StartURL,
+	// AllowedHosts and RateMs make up a single site.
+	Sites []Site `json:"sites"`
 }
 
+// Output formats
+const (
+	OutputFiles = "files"
+	OutputWARC  = "warc"
+	OutputBoth  = "both"
+)
+
+// DefaultPa11yConfig is the pa11y config file used when pa11y_config isn't set
+const DefaultPa11yConfig = "pa11y-config.json"
+
+// Replay sources
+const (
+	ReplayWARC  = "warc"
+	ReplayFiles = "files"
+)
+
 // LoadConfig reads JSON from the given path and applies defaults where needed.
 func LoadConfig(path string) (*Config, error) {
 	f, err := os.Open(path)
@@ -45,6 +139,13 @@ func LoadConfig(path string) (*Config, error) {
 	if cfg.DBFilePath == "" {
 		cfg.DBFilePath = "./_db/webthing.db"
 	}
+	switch cfg.DBBackend {
+	case storage.BackendSQLite, storage.BackendBolt, storage.BackendMemory:
+	case "":
+		cfg.DBBackend = storage.BackendSQLite
+	default:
+		return nil, fmt.Errorf("unknown db_backend %q, use sqlite, bbolt or memory", cfg.DBBackend)
+	}
 	if cfg.LogPath == "" {
 		cfg.LogPath = "./_logs"
 	}
@@ -63,11 +164,68 @@ func LoadConfig(path string) (*Config, error) {
 	if cfg.HTTPTimeout <= 0 {
 		cfg.HTTPTimeout = int((30 * time.Second).Seconds())
 	}
+	if cfg.ScanWorkers <= 0 {
+		cfg.ScanWorkers = 4
+	}
+	if cfg.ScanTimeoutSeconds <= 0 {
+		cfg.ScanTimeoutSeconds = 120
+	}
+	if len(cfg.Scanners) == 0 {
+		cfg.Scanners = []string{"pa11y"}
+	}
+	if cfg.ScanRetries < 0 {
+		cfg.ScanRetries = 0
+	}
+	if cfg.Pa11yConfig == "" {
+		cfg.Pa11yConfig = DefaultPa11yConfig
+	}
+	if cfg.MaxPages < 0 {
+		cfg.MaxPages = 0
+	}
+	if cfg.MaxBytes < 0 {
+		cfg.MaxBytes = 0
+	}
+	if cfg.MaxDurationSeconds < 0 {
+		cfg.MaxDurationSeconds = 0
+	}
+	if cfg.MaxPagesPerHost < 0 {
+		cfg.MaxPagesPerHost = 0
+	}
+	if cfg.MaxBodyBytes < 0 {
+		cfg.MaxBodyBytes = 0
+	}
+	switch cfg.OutputFormat {
+	case OutputFiles, OutputWARC, OutputBoth:
+	case "":
+		cfg.OutputFormat = OutputFiles
+	default:
+		return nil, fmt.Errorf("unknown output_format %q, use files, warc or both", cfg.OutputFormat)
+	}
+	if err := cfg.normalizeSites(); err != nil {
+		return nil, err
+	}
+	if cfg.LogoutPatterns == nil {
+		cfg.LogoutPatterns = defaultLogoutPatterns
+	}
+	if err := cfg.ValidateReplay(); err != nil {
+		return nil, err
+	}
+	if cfg.ProxyURL != "" {
+		if _, err := url.Parse(cfg.ProxyURL); err != nil {
+			return nil, fmt.Errorf("bad proxy_url: %w", err)
+		}
+	}
+	if cfg.WARCDir == "" {
+		cfg.WARCDir = "./_warc"
+	}
+	if cfg.WARCMaxFileBytes <= 0 {
+		cfg.WARCMaxFileBytes = 1 << 30 // 1 GB
+	}
 
 	return &cfg, nil
 }
 
-func (c *Config) InitializeApp() (*logger.Logger, *storage.Storage, error) {
+func (c *Config) InitializeApp() (*logger.Logger, storage.Store, error) {
 
 	// 2. Init logger
 	logDir := c.LogPath
@@ -92,7 +250,7 @@ func (c *Config) InitializeApp() (*logger.Logger, *storage.Storage, error) {
 		return nil, nil, err
 	}
 
-	store, err := storage.New(dbPath)
+	store, err := storage.NewStore(c.DBBackend, dbPath)
 	if err != nil {
 		appLogger.Error("Error opening database:", err)
 		os.Exit(1)
@@ -102,3 +260,32 @@ func (c *Config) InitializeApp() (*logger.Logger, *storage.Storage, error) {
 
 	return appLogger, store, nil
 }
+
+// WritesFiles reports if pages should be kept as flat files in OutputDir
+func (c *Config) WritesFiles() bool {
+	return c.OutputFormat != OutputWARC
+}
+
+// WritesWARC reports if pages should be archived into WARC files
+func (c *Config) WritesWARC() bool {
+	return c.OutputFormat == OutputWARC || c.OutputFormat == OutputBoth
+}
+
+// ValidateReplay checks ReplayFrom is empty or a known replay source
+func (c *Config) ValidateReplay() error {
+	switch c.ReplayFrom {
+	case "", ReplayWARC, ReplayFiles:
+		return nil
+	}
+	return fmt.Errorf("unknown replay_from %q, use warc or files", c.ReplayFrom)
+}
+
+// Snapshot returns the config as JSON to record with a crawl run, so a run can
+// be compared with the settings it was made with. Secrets are left out.
+func (c *Config) Snapshot() (string, error) {
+	b, err := json.Marshal(c.redacted())
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
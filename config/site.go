@@ -0,0 +1,119 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Site is one website in a crawl, with its own seeds, scope, rate and robots
+// rules. A config can list several so one crawl covers a site and its
+// sub-sites. Configs that only set start_url and allowed_hosts become a
+// single site.
+type Site struct {
+	Name          string   `json:"name"`
+	StartURLs     []string `json:"start_urls"`
+	AllowedHosts  []string `json:"allowed_hosts"`  // defaults to the hosts of the start URLs
+	RateMs        *int     `json:"rate_ms"`        // defaults to the top level rate_ms, 0 doesn't pause
+	RespectRobots *bool    `json:"respect_robots"` // defaults to true
+
+	hostsDefaulted bool
+}
+
+// ObeysRobots reports if robots.txt rules apply to the site
+func (s *Site) ObeysRobots() bool {
+	return s.RespectRobots == nil || *s.RespectRobots
+}
+
+// HostsDefaulted reports if the site listed no allowed_hosts, so its scope
+// is the hosts of its start URLs
+func (s *Site) HostsDefaulted() bool {
+	return s.hostsDefaulted
+}
+
+// HasHost reports if the host is in the site's scope
+func (s *Site) HasHost(host string) bool {
+	for _, allowed := range s.AllowedHosts {
+		if strings.EqualFold(host, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// SiteFor returns the site whose scope includes the host, or nil if none do
+func (c *Config) SiteFor(host string) *Site {
+	for i := range c.Sites {
+		if c.Sites[i].HasHost(host) {
+			return &c.Sites[i]
+		}
+	}
+	return nil
+}
+
+// Seeds returns the start URLs of every site
+func (c *Config) Seeds() []string {
+	var seeds []string
+	for _, site := range c.Sites {
+		seeds = append(seeds, site.StartURLs...)
+	}
+	return seeds
+}
+
+// AllHosts returns the allowed hosts of every site
+func (c *Config) AllHosts() []string {
+	var hosts []string
+	seen := make(map[string]bool)
+	for _, site := range c.Sites {
+		for _, host := range site.AllowedHosts {
+			if !seen[strings.ToLower(host)] {
+				seen[strings.ToLower(host)] = true
+				hosts = append(hosts, host)
+			}
+		}
+	}
+	return hosts
+}
+
+// normalizeSites turns the single site settings into a site when no sites
+// are listed, and fills in the defaults for each site.
+func (c *Config) normalizeSites() error {
+	if len(c.Sites) == 0 {
+		if c.StartURL == "" {
+			return fmt.Errorf("no start_url or sites in the config")
+		}
+		c.Sites = []Site{{
+			StartURLs:    []string{c.StartURL},
+			AllowedHosts: c.AllowedHosts,
+		}}
+	}
+
+	for i := range c.Sites {
+		site := &c.Sites[i]
+		if len(site.StartURLs) == 0 {
+			return fmt.Errorf("site %d (%s) has no start_urls", i+1, site.Name)
+		}
+		site.hostsDefaulted = len(site.AllowedHosts) == 0
+		for _, start := range site.StartURLs {
+			parsed, err := url.Parse(start)
+			if err != nil || parsed.Host == "" {
+				return fmt.Errorf("bad start URL %q in site %d", start, i+1)
+			}
+			if site.hostsDefaulted && !site.HasHost(parsed.Host) {
+				site.AllowedHosts = append(site.AllowedHosts, parsed.Host)
+			}
+		}
+		if site.Name == "" {
+			site.Name = site.StartURLs[0]
+		}
+		if site.RateMs == nil || *site.RateMs < 0 {
+			rate := c.RateMs
+			site.RateMs = &rate
+		}
+	}
+
+	if c.StartURL == "" {
+		c.StartURL = c.Sites[0].StartURLs[0]
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package config
+
+import "testing"
+
+func TestNormalizeSitesFromSingleSite(t *testing.T) {
+	cfg := &Config{
+		StartURL:     "https://www.boem.gov/",
+		AllowedHosts: []string{"www.boem.gov"},
+		RateMs:       500,
+	}
+	if err := cfg.normalizeSites(); err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Sites) != 1 {
+		t.Fatalf("len(Sites) = %d; want 1", len(cfg.Sites))
+	}
+	site := cfg.Sites[0]
+	if site.StartURLs[0] != "https://www.boem.gov/" || *site.RateMs != 500 || !site.ObeysRobots() {
+		t.Errorf("site = %+v; want the single site settings with robots obeyed", site)
+	}
+}
+
+func TestNormalizeSitesDefaults(t *testing.T) {
+	noRobots := false
+	slow, noPause := 2000, 0
+	cfg := &Config{
+		RateMs: 200,
+		Sites: []Site{
+			{StartURLs: []string{"https://www.boem.gov/", "https://www.boem.gov/about/"}},
+			{StartURLs: []string{"https://data.boem.gov/"}, RateMs: &slow, RespectRobots: &noRobots},
+			{StartURLs: []string{"https://local.test/"}, AllowedHosts: []string{"local.test"}, RateMs: &noPause},
+		},
+	}
+	if err := cfg.normalizeSites(); err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.StartURL != "https://www.boem.gov/" {
+		t.Errorf("StartURL = %q; want the first seed", cfg.StartURL)
+	}
+	if got := cfg.Seeds(); len(got) != 4 {
+		t.Errorf("Seeds() = %v; want 4 seeds", got)
+	}
+	if hosts := cfg.Sites[0].AllowedHosts; len(hosts) != 1 || hosts[0] != "www.boem.gov" || !cfg.Sites[0].HostsDefaulted() {
+		t.Errorf("default AllowedHosts = %v; want [www.boem.gov]", hosts)
+	}
+	if cfg.Sites[2].HostsDefaulted() {
+		t.Error("HostsDefaulted() = true for a site that lists its allowed_hosts")
+	}
+
+	data := cfg.SiteFor("DATA.boem.gov")
+	if data == nil || *data.RateMs != 2000 || data.ObeysRobots() {
+		t.Errorf("SiteFor(data.boem.gov) = %+v; want its own rate and robots setting", data)
+	}
+	if *cfg.Sites[0].RateMs != 200 {
+		t.Errorf("site RateMs = %d; want the top level 200", *cfg.Sites[0].RateMs)
+	}
+	if *cfg.Sites[2].RateMs != 0 {
+		t.Errorf("site RateMs = %d; want its own 0, not the top level rate", *cfg.Sites[2].RateMs)
+	}
+	if cfg.SiteFor("www.example.com") != nil {
+		t.Error("SiteFor(www.example.com) found a site for a host outside every scope")
+	}
+}
+
+func TestNormalizeSitesErrors(t *testing.T) {
+	tests := map[string]*Config{
+		"nothing to crawl":   {},
+		"site with no seeds": {Sites: []Site{{Name: "empty"}}},
+		"bad start url":      {Sites: []Site{{StartURLs: []string{"not a url"}}}},
+	}
+	for name, cfg := range tests {
+		if err := cfg.normalizeSites(); err == nil {
+			t.Errorf("%s: normalizeSites() returned no error", name)
+		}
+	}
+}
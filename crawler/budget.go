@@ -0,0 +1,190 @@
+package crawler
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"boem-web-thing/config"
+)
+
+// Names of the budgets, these are what gets recorded against the crawl run
+const (
+	BudgetMaxPages        = "max_pages"
+	BudgetMaxBytes        = "max_bytes"
+	BudgetMaxDuration     = "max_duration"
+	BudgetMaxPagesPerHost = "max_pages_per_host"
+	BudgetMaxPagesPrefix  = "max_pages_per_prefix"
+)
+
+// Outcomes of a crawl run
+const (
+	OutcomeCompleted       = "completed"
+	OutcomeBudgetExhausted = "budget_exhausted"
+)
+
+// budget keeps the running totals for a crawl and decides if another page
+// is allowed to be fetched. A zero limit means that budget is unlimited.
+type budget struct {
+	mu          sync.Mutex
+	maxPages    int
+	maxBytes    int64
+	maxDuration time.Duration
+	perHost     int
+	perPrefix   map[string]int
+
+	started     time.Time
+	pages       int
+	bytes       int64
+	hostPages   map[string]int
+	prefixPages map[string]int
+	exhausted   string   // the first crawl wide budget that stopped a page from being fetched
+	capped      []string // the per host and per prefix caps that held pages back
+}
+
+func newBudget(cfg *config.Config) *budget {
+	return &budget{
+		maxPages:    cfg.MaxPages,
+		maxBytes:    cfg.MaxBytes,
+		maxDuration: time.Duration(cfg.MaxDurationSeconds) * time.Second,
+		perHost:     cfg.MaxPagesPerHost,
+		perPrefix:   cfg.MaxPagesPerPrefix,
+		started:     time.Now(),
+		hostPages:   make(map[string]int),
+		prefixPages: make(map[string]int),
+	}
+}
+
+// globalExceeded returns the name of the crawl wide budget that has been used
+// up, or an empty string if there is still room. Caller must hold the lock.
+func (b *budget) globalExceeded() string {
+	if b.maxPages > 0 && b.pages >= b.maxPages {
+		return BudgetMaxPages
+	}
+	if b.maxBytes > 0 && b.bytes >= b.maxBytes {
+		return BudgetMaxBytes
+	}
+	if b.maxDuration > 0 && time.Since(b.started) >= b.maxDuration {
+		return BudgetMaxDuration
+	}
+	return ""
+}
+
+// Done reports if a crawl wide budget has run out, so no more pages should
+// be queued at all. Only call it when there is a page waiting to be queued,
+// as a true result marks the crawl as budget exhausted.
+func (b *budget) Done() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	reason := b.globalExceeded()
+	if reason != "" && b.exhausted == "" {
+		b.exhausted = reason
+	}
+	return reason != ""
+}
+
+// Reserve claims a page from the budgets for the URL. When it returns false
+// the URL must not be fetched, and the reason names the budget that stopped it.
+func (b *budget) Reserve(rawURL string) (bool, string) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return false, ""
+	}
+	host := strings.ToLower(parsed.Host)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	reason := b.globalExceeded()
+	if reason == "" && b.perHost > 0 && b.hostPages[host] >= b.perHost {
+		reason = BudgetMaxPagesPerHost
+	}
+	prefixes := b.prefixesOf(parsed.Path)
+	for _, prefix := range prefixes {
+		if limit := b.perPrefix[prefix]; limit > 0 && b.prefixPages[prefix] >= limit && reason == "" {
+			reason = BudgetMaxPagesPrefix
+		}
+	}
+	switch {
+	case reason == "":
+	case reason == BudgetMaxPagesPerHost || reason == BudgetMaxPagesPrefix:
+		// A cap on part of the site doesn't cut the crawl short
+		if !slices.Contains(b.capped, reason) {
+			b.capped = append(b.capped, reason)
+		}
+		return false, reason
+	default:
+		if b.exhausted == "" {
+			b.exhausted = reason
+		}
+		return false, reason
+	}
+
+	b.pages++
+	b.hostPages[host]++
+	for _, prefix := range prefixes {
+		b.prefixPages[prefix]++
+	}
+	return true, ""
+}
+
+// Release gives back the page Reserve claimed for the URL, when it couldn't
+// be fetched after all, so failed fetches don't use up the budgets
+func (b *budget) Release(rawURL string) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return
+	}
+	host := strings.ToLower(parsed.Host)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pages--
+	b.hostPages[host]--
+	for _, prefix := range b.prefixesOf(parsed.Path) {
+		b.prefixPages[prefix]--
+	}
+}
+
+// prefixesOf returns the capped prefixes the path is under
+func (b *budget) prefixesOf(path string) []string {
+	var prefixes []string
+	for prefix := range b.perPrefix {
+		if strings.HasPrefix(path, prefix) {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	return prefixes
+}
+
+// AddBytes counts downloaded bytes against the max_bytes budget
+func (b *budget) AddBytes(n int64) {
+	b.mu.Lock()
+	b.bytes += n
+	b.mu.Unlock()
+}
+
+// Totals returns the pages and bytes fetched so far
+func (b *budget) Totals() (int, int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.pages, b.bytes
+}
+
+// Outcome is the final state of the crawl and the budgets that held it back,
+// if any: the crawl wide budget that ended it first, then any per host or per
+// prefix caps. Only a crawl wide budget makes the crawl budget exhausted.
+func (b *budget) Outcome() (string, string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	outcome := OutcomeCompleted
+	var budgets []string
+	if b.exhausted != "" {
+		outcome = OutcomeBudgetExhausted
+		budgets = append(budgets, b.exhausted)
+	}
+	budgets = append(budgets, b.capped...)
+	return outcome, strings.Join(budgets, ", ")
+}
@@ -0,0 +1,172 @@
+package crawler
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"boem-web-thing/config"
+	"boem-web-thing/logger"
+	"boem-web-thing/storage"
+)
+
+func TestBudgetMaxPages(t *testing.T) {
+	b := newBudget(&config.Config{MaxPages: 2})
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := b.Reserve("https://example.com/page"); !ok {
+			t.Fatalf("Reserve #%d refused, want allowed", i+1)
+		}
+	}
+	if ok, reason := b.Reserve("https://example.com/page"); ok || reason != BudgetMaxPages {
+		t.Errorf("Reserve over limit = %v, %q; want false, %q", ok, reason, BudgetMaxPages)
+	}
+
+	outcome, reason := b.Outcome()
+	if outcome != OutcomeBudgetExhausted || reason != BudgetMaxPages {
+		t.Errorf("Outcome() = %q, %q; want %q, %q", outcome, reason, OutcomeBudgetExhausted, BudgetMaxPages)
+	}
+}
+
+func TestBudgetMaxBytes(t *testing.T) {
+	b := newBudget(&config.Config{MaxBytes: 100})
+
+	if ok, _ := b.Reserve("https://example.com/"); !ok {
+		t.Fatal("first Reserve refused, want allowed")
+	}
+	b.AddBytes(150)
+	if !b.Done() {
+		t.Error("Done() = false after max_bytes was passed")
+	}
+	if _, reason := b.Outcome(); reason != BudgetMaxBytes {
+		t.Errorf("Outcome() reason = %q; want %q", reason, BudgetMaxBytes)
+	}
+}
+
+func TestBudgetPerHostAndPrefix(t *testing.T) {
+	b := newBudget(&config.Config{
+		MaxPagesPerHost:   2,
+		MaxPagesPerPrefix: map[string]int{"/news/": 1},
+	})
+
+	tests := []struct {
+		url    string
+		ok     bool
+		reason string
+	}{
+		{"https://a.example.com/news/1", true, ""},
+		{"https://a.example.com/news/2", false, BudgetMaxPagesPrefix},
+		{"https://a.example.com/about", true, ""},
+		{"https://a.example.com/contact", false, BudgetMaxPagesPerHost},
+		{"https://b.example.com/about", true, ""},
+	}
+
+	for _, tt := range tests {
+		ok, reason := b.Reserve(tt.url)
+		if ok != tt.ok || reason != tt.reason {
+			t.Errorf("Reserve(%q) = %v, %q; want %v, %q", tt.url, ok, reason, tt.ok, tt.reason)
+		}
+	}
+
+	// The caps are recorded, but the crawl still completed
+	want := BudgetMaxPagesPrefix + ", " + BudgetMaxPagesPerHost
+	if outcome, reason := b.Outcome(); outcome != OutcomeCompleted || reason != want {
+		t.Errorf("Outcome() = %q, %q; want %q, %q", outcome, reason, OutcomeCompleted, want)
+	}
+}
+
+func TestBudgetCapsAfterExhausted(t *testing.T) {
+	b := newBudget(&config.Config{MaxPages: 2, MaxPagesPerHost: 1})
+
+	b.Reserve("https://a.example.com/")
+	b.Reserve("https://a.example.com/about")
+	b.Reserve("https://b.example.com/")
+	b.Reserve("https://c.example.com/")
+
+	want := BudgetMaxPages + ", " + BudgetMaxPagesPerHost
+	if outcome, reason := b.Outcome(); outcome != OutcomeBudgetExhausted || reason != want {
+		t.Errorf("Outcome() = %q, %q; want %q, %q", outcome, reason, OutcomeBudgetExhausted, want)
+	}
+}
+
+func TestBudgetUnlimited(t *testing.T) {
+	b := newBudget(&config.Config{})
+
+	for i := 0; i < 1000; i++ {
+		if ok, _ := b.Reserve("https://example.com/"); !ok {
+			t.Fatalf("Reserve #%d refused with no budgets set", i+1)
+		}
+	}
+	if outcome, _ := b.Outcome(); outcome != OutcomeCompleted {
+		t.Errorf("Outcome() = %q; want %q", outcome, OutcomeCompleted)
+	}
+}
+
+func TestBudgetRelease(t *testing.T) {
+	b := newBudget(&config.Config{
+		MaxPages:          1,
+		MaxPagesPerHost:   1,
+		MaxPagesPerPrefix: map[string]int{"/news/": 1},
+	})
+
+	if ok, _ := b.Reserve("https://example.com/news/1"); !ok {
+		t.Fatal("first Reserve refused, want allowed")
+	}
+	b.Release("https://example.com/news/1")
+	if ok, reason := b.Reserve("https://example.com/news/2"); !ok {
+		t.Errorf("Reserve after Release refused by %s, want the page given back to every budget", reason)
+	}
+	if pages, _ := b.Totals(); pages != 1 {
+		t.Errorf("Totals() pages = %d; want 1", pages)
+	}
+}
+
+func TestBudgetFailedFetches(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<a href="/down/1">1</a> <a href="/down/2">2</a>`)
+	})
+	// Drop the connection, as a server that is down would
+	mux.HandleFunc("/down/", func(w http.ResponseWriter, r *http.Request) {
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	cfg := &config.Config{
+		OutputDir:    t.TempDir(),
+		HTTPTimeout:  5,
+		RateMs:       1,
+		Concurrency:  1,
+		OutputFormat: config.OutputFiles,
+		MaxPages:     3,
+		Sites:        []config.Site{{Name: "test", StartURLs: []string{server.URL + "/"}, AllowedHosts: []string{host}}},
+	}
+	log, err := logger.New(t.TempDir(), "info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	store := storage.NewMemory()
+
+	New(cfg, log, store).Crawl()
+
+	// The pages that failed don't count against max_pages
+	runs, err := store.GetRuns()
+	if err != nil || len(runs) != 1 {
+		t.Fatalf("GetRuns() = %v, %v", runs, err)
+	}
+	if run := runs[0]; run.Outcome != OutcomeCompleted || run.Pages_fetched != 1 {
+		t.Errorf("run outcome %q, %d pages fetched; want completed with 1 page", run.Outcome, run.Pages_fetched)
+	}
+	if pages, _ := store.GetRunPages(runs[0].Id); len(pages) != 1 {
+		t.Errorf("GetRunPages() = %+v; want just the home page", pages)
+	}
+}
@@ -1,9 +1,14 @@
 package crawler
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptrace"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -13,55 +18,166 @@ import (
 
 	"boem-web-thing/config"
 	"boem-web-thing/logger"
+	"boem-web-thing/replay"
 	"boem-web-thing/storage"
 	"boem-web-thing/util"
+	"boem-web-thing/warc"
 
 	"github.com/temoto/robotstxt"
 	"golang.org/x/net/html"
+	"golang.org/x/net/publicsuffix"
 )
 
 type Crawler struct {
 	cfg     *config.Config
 	log     *logger.Logger
-	store   *storage.Storage
+	store   storage.Store
 	client  *http.Client
+	hosts   *hostTransport
+	initErr error // a problem building the client, reported when crawling
 	visited map[string]bool
 	mu      sync.Mutex
 	wg      sync.WaitGroup
-	ticker  *time.Ticker // NEW
+	ticker  *time.Ticker                     // NEW
+	robots  map[string]*robotstxt.RobotsData // by host, nil when there are no rules
+	robotMu sync.Mutex
+	budget  *budget
+	runID   int64
+	warc    *warc.Writer
+	saved   []savedFile // files written this crawl, for mirror link rewriting
 }
 
-func New(cfg *config.Config, log *logger.Logger, store *storage.Storage) *Crawler {
-	return &Crawler{
+// fetchResult is what we learned from downloading a single URL
+type fetchResult struct {
+	status       int
+	contentType  string
+	filePath     string
+	declaredSize int64 // Content-Length, -1 when the server did not send one
+	size         int64 // bytes of the body that was kept, 0 when it wasn't
+	read         int64 // bytes of the body downloaded, counted against the byte budget even when not kept
+	bodyStored   bool
+	warcFile     string
+	warcOffset   int64
+	charset      string      // detected character set of text content
+	contentHash  string      // sha256 of the body as downloaded, empty if it was not kept
+	headers      http.Header // response headers as the server sent them
+	finalURL     string      // where redirects ended up, empty if there were none
+	redirectCode int         // status of the first redirect
+	redirectHops int
+	timing       pageTiming
+	links        []string
+	requisites   []string // images, stylesheets and scripts, only found in mirror mode
+}
+
+func New(cfg *config.Config, log *logger.Logger, store storage.Store) *Crawler {
+	// Keep cookies between requests, so a login lasts the whole crawl
+	jar, _ := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	base, initErr := newBaseTransport(cfg)
+	if initErr != nil {
+		base = http.DefaultTransport
+	}
+	hosts := newHostTransport(cfg, base)
+	c := &Crawler{
 		cfg:   cfg,
 		log:   log,
 		store: store,
 		client: &http.Client{
-			Timeout: time.Duration(cfg.HTTPTimeout) * time.Second,
+			Timeout:   time.Duration(cfg.HTTPTimeout) * time.Second,
+			Transport: hosts,
+			Jar:       jar,
 		},
+		hosts:   hosts,
+		initErr: initErr,
 		visited: make(map[string]bool),
+		robots:  make(map[string]*robotstxt.RobotsData),
 		ticker:  time.NewTicker(time.Duration(cfg.RateMs) * time.Millisecond),
+		budget:  newBudget(cfg),
+	}
+	c.client.CheckRedirect = c.checkRedirect
+	return c
+}
+
+// checkRedirect stops the client following a redirect to a logout URL, which
+// shouldVisit can't catch, as well as long redirect chains. The redirect
+// itself is returned as the response.
+func (c *Crawler) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	if c.cfg.HasAuth() && c.cfg.IsLogoutURL(req.URL.String()) {
+		c.log.Info("Not following redirect to logout URL", req.URL.String())
+		return http.ErrUseLastResponse
 	}
+	return nil
 }
 
-// Crawl starts crawling from the StartURL
+// Crawl starts crawling from the start URLs of every site
 func (c *Crawler) Crawl() {
-	startURL := c.cfg.StartURL
+	seeds := c.cfg.Seeds()
+	startURL := strings.Join(seeds, " ")
 	c.log.Debug("Starting site crawl at", startURL)
+	for _, site := range c.cfg.Sites {
+		if site.HostsDefaulted() {
+			c.log.Info("Site", site.Name, "has no allowed_hosts, keeping to its start URL hosts", strings.Join(site.AllowedHosts, " "))
+		}
+	}
+
+	if c.initErr != nil {
+		c.log.Error("Unable to set up the HTTP client, not crawling", c.initErr)
+		return
+	}
+
+	if c.cfg.ReplayFrom != "" {
+		if err := c.startReplay(); err != nil {
+			c.log.Error("Unable to replay the earlier crawl, not crawling", err)
+			return
+		}
+	}
+
+	// Replaying an archive into the same archive would just copy it
+	if c.cfg.WritesWARC() && c.cfg.ReplayFrom != config.ReplayWARC {
+		w, err := warc.NewWriter(c.cfg.WARCDir, "boem-web-thing", c.cfg.WARCMaxFileBytes, c.cfg.UserAgent)
+		if err != nil {
+			c.log.Error("Unable to open WARC output, not crawling", err)
+			return
+		}
+		c.warc = w
+		defer func() {
+			if err := c.warc.Close(); err != nil {
+				c.log.Error("Error closing WARC file", err)
+			}
+		}()
+	}
+
+	if c.cfg.LoginForm != nil && c.cfg.ReplayFrom == "" {
+		if err := c.login(); err != nil {
+			c.log.Error("Unable to log in, not crawling", err)
+			return
+		}
+	}
+
+	c.budget = newBudget(c.cfg)
+	snapshot, err := c.cfg.Snapshot()
+	if err != nil {
+		c.log.Error("Unable to snapshot the config for the crawl run", err)
+	}
+	runID, err := c.store.StartRun(startURL, snapshot)
+	if err != nil {
+		c.log.Error("Unable to record the crawl run", err)
+	}
+	c.runID = runID
 
 	urlCh := make(chan string, c.cfg.Concurrency*2)
 
-	// Download a copy of the robots.txt to refer to
+	// Download a copy of the robots.txt for each site to refer to
 	// Always download a new copy at the start of a job
-	robots_url := startURL + "robots.txt"
-	_, _, _, _, robots_err := c.fetchAndSave(robots_url)
-	if robots_err != nil {
-		c.log.Error("Error Downloading Robots.txt", robots_url, robots_err)
+	for _, seed := range seeds {
+		if parsed, err := url.Parse(seed); err == nil {
+			c.robotsFor(parsed)
+		}
 	}
 
-	c.wg.Add(1) // adding a wait here to track the first URL and wait until all the subsequent processes happen
-	// Seed the queue
-	urlCh <- startURL
+	c.wg.Add(len(seeds)) // adding a wait here to track the first URLs and wait until all the subsequent processes happen
 
 	// Start worker goroutines
 	// for i := 0; i < c.cfg.Concurrency; i++ {
@@ -69,9 +185,64 @@ func (c *Crawler) Crawl() {
 	go c.worker(urlCh) // start a single worker for now
 	// }
 
+	// Seed the queue
+	for _, seed := range seeds {
+		c.store.AddToFrontier(c.runID, seed)
+		urlCh <- seed
+	}
+
 	c.log.Debug("Waiting to finish crawl of", startURL)
 	c.wg.Wait() // Wait for all workers to finish processing
 	c.log.Debug("Finished crawl of site", startURL)
+
+	if c.cfg.Mirror {
+		c.convertLinks()
+	}
+
+	outcome, reason := c.budget.Outcome()
+	pages, bytes := c.budget.Totals()
+	if outcome == OutcomeBudgetExhausted {
+		c.log.Info("Crawl stopped early, budget reached:", reason)
+	} else if reason != "" {
+		c.log.Info("Crawl completed, some pages held back by:", reason)
+	}
+	// Page and link records are written in batches, so their errors show up here
+	if err := c.store.Flush(); err != nil {
+		c.log.Error("Some page or link records could not be saved", err)
+	}
+	if err := c.store.FinishRun(c.runID, outcome, reason, pages, bytes); err != nil {
+		c.log.Error("Unable to record the end of the crawl run", err)
+	}
+}
+
+// startReplay swaps the network for the earlier crawl named by ReplayFrom
+func (c *Crawler) startReplay() error {
+	var t *replay.Transport
+	switch c.cfg.ReplayFrom {
+	case config.ReplayWARC:
+		var err error
+		t, err = replay.FromWARC(c.cfg.WARCDir)
+		if err != nil {
+			return err
+		}
+	case config.ReplayFiles:
+		pages, err := c.store.GetPages()
+		if err != nil {
+			return err
+		}
+		t = replay.FromPages(pages)
+	default:
+		return fmt.Errorf("unknown replay source %q", c.cfg.ReplayFrom)
+	}
+	c.log.Info("Replaying", t.Len(), "URLs from", c.cfg.ReplayFrom)
+	c.hosts.base = t
+	return nil
+}
+
+// Outcome returns how the last crawl ended and which budgets, if any, held it
+// back, see budget.Outcome
+func (c *Crawler) Outcome() (string, string) {
+	return c.budget.Outcome()
 }
 
 // This worker is constantly looping and reading the urlCh channel
@@ -95,45 +266,103 @@ func (c *Crawler) processURL(u string, urlCh chan<- string) {
 
 	c.log.Debug("Start of processURL...", u)
 
-	if c.isAlreadyVisited(u) {
+	// Claim the URL, so a seed that is also linked from another site is only fetched once
+	if !c.claimVisit(u) {
 		c.log.Debug("Already visited", u)
 		return
 	}
 
+	if ok, reason := c.budget.Reserve(u); !ok {
+		c.log.Debug("Budget reached, skipping", reason, u)
+		return
+	}
+
 	// 1. Fetch
 	c.log.Debug("Sending to fetch and save", u)
-	// 1.1 Rate limiting
-	time.Sleep(time.Duration(c.cfg.RateMs) * time.Millisecond)
+	// 1.1 Rate limiting, each site sets its own, not needed when replaying
+	if c.cfg.ReplayFrom == "" {
+		time.Sleep(time.Duration(c.rateMs(u)) * time.Millisecond)
+	}
 	// 1.2 Gather the info from the URL
-	status, contentType, filePath, links, err := c.fetchAndSave(u)
+	res, err := c.fetchAndSave(u)
 	if err != nil {
 		c.log.Error("Error fetching", u, ":", err)
+		c.budget.Release(u)
 		return
 	}
+	c.budget.AddBytes(res.read)
+	c.store.MarkFetched(c.runID, u)
 
 	// 2. Save page record
 	c.log.Debug("Saving the fetched URL")
 	c.log.Info("Saving", u)
-	if err := c.store.SavePage(u, status, contentType, filePath); err != nil {
+	page := storage.Pages{
+		Url:           u,
+		Status_code:   res.status,
+		Content_type:  res.contentType,
+		File_path:     res.filePath,
+		Declared_size: res.declaredSize,
+		Actual_size:   res.size,
+		Body_stored:   res.bodyStored,
+		Warc_filename: res.warcFile,
+		Warc_offset:   res.warcOffset,
+		Charset:       res.charset,
+		Content_hash:  res.contentHash,
+		Run_id:        c.runID,
+		Headers:       headerJSON(res.headers),
+		Dns_ms:        ms(res.timing.dns),
+		Connect_ms:    ms(res.timing.connect),
+		Tls_ms:        ms(res.timing.tls),
+		Ttfb_ms:       ms(res.timing.ttfb),
+		Total_ms:      ms(res.timing.total),
+
+		Final_url:       res.finalURL,
+		Redirect_status: res.redirectCode,
+		Redirect_hops:   res.redirectHops,
+	}
+	if err := c.store.SavePage(page); err != nil {
 		c.log.Error("DB save error for", u, ":", err)
 	}
 
-	// 3. Add this URL to the list so I don't check it again
-	c.addToVisited(u)
-
 	c.log.Debug("Extracting links from the fetched page")
-	// 4. Save links and enqueue new ones
-	for _, link := range links {
-		c.store.SaveLink(u, link)
-		if c.shouldVisit(link) {
-			c.wg.Add(1) //add to the waitgroup to make sure this URL gets waited for to finish all the processing
-			urlCh <- link
+	// 3. Save links and enqueue new ones
+	for _, link := range res.links {
+		c.store.SaveLink(c.runID, u, link)
+		if c.shouldVisit(link, false) {
+			c.enqueue(link, urlCh)
+		}
+	}
+	for _, link := range res.requisites {
+		c.store.SaveLink(c.runID, u, link)
+		if c.shouldVisit(link, true) {
+			c.enqueue(link, urlCh)
 		}
 	}
 
 	c.log.Debug("End of processURL...")
 }
 
+// rateMs is how long to pause before fetching the URL, from its site
+func (c *Crawler) rateMs(rawURL string) int {
+	if parsed, err := url.Parse(rawURL); err == nil {
+		if site := c.cfg.SiteFor(parsed.Host); site != nil && site.RateMs != nil {
+			return *site.RateMs
+		}
+	}
+	return c.cfg.RateMs
+}
+
+// enqueue adds the URL to the run's frontier and queues it, unless a budget
+// has run out. Leaving it in the frontier shows what a budget cut off.
+func (c *Crawler) enqueue(link string, urlCh chan<- string) {
+	c.store.AddToFrontier(c.runID, link)
+	if c.budget.Done() {
+		return
+	}
+	c.wg.Add(1) //add to the waitgroup to make sure this URL gets waited for to finish all the processing
+	urlCh <- link
+}
+
 // Keep track of which URLs have been visited so we don't try to access them
 // more than necessary
 func (c *Crawler) addToVisited(u string) {
@@ -144,59 +373,209 @@ func (c *Crawler) addToVisited(u string) {
 }
 
 // retrieve the contents from the URL, if it is HTML then save a file, save it to the database
-func (c *Crawler) fetchAndSave(rawURL string) (status int, contentType string, filePath string, links []string, err error) {
+func (c *Crawler) fetchAndSave(rawURL string) (*fetchResult, error) {
 	c.log.Debug("Start of fetchAndSave", rawURL)
+	res := &fetchResult{}
+	t := newTiming()
 	// Make a HEAD request to check the content type
-	resp, err := c.client.Head(rawURL)
+	resp, err := c.doTimed(http.MethodHead, rawURL, t)
 	if err != nil {
-		return 0, "", "", nil, err
+		return nil, err
 	}
 	defer resp.Body.Close()
-	status = resp.StatusCode
-	contentType = resp.Header.Get("Content-Type")
+	res.status = resp.StatusCode
+	res.contentType = resp.Header.Get("Content-Type")
+	res.declaredSize = resp.ContentLength
+	res.headers = resp.Header.Clone()
+	res.finalURL, res.redirectCode, res.redirectHops = redirects(resp)
+	// Don't download bodies the policy says we won't keep
+	if keep, why := c.keepBody(rawURL, res.contentType, res.declaredSize); !keep {
+		c.log.Info("Recording metadata only for", rawURL, why)
+		res.timing = t.done()
+		return res, nil
+	}
 	// Make a GET request since the content is HTML
-	resp, err = c.client.Get(rawURL)
+	resp, err = c.doTimed(http.MethodGet, rawURL, t)
 	if err != nil {
-		return 0, "", "", nil, err
+		return nil, err
 	}
 	defer resp.Body.Close()
-	// Save only HTML and similar; still store others but don't parse links
-	filePath, err = util.URLToFilePath(c.cfg.OutputDir, rawURL)
-	if err := util.EnsureDir(filepath.Dir(filePath)); err != nil {
-		return status, contentType, "", nil, fmt.Errorf("failed to create dir: %w", err)
+	declaredEncoded := resp.ContentLength
+	// decodeBody drops the encoding headers, keep them as they were sent
+	res.headers = resp.Header.Clone()
+	res.finalURL, res.redirectCode, res.redirectHops = redirects(resp)
+	decoded, err := decodeBody(resp)
+	if err != nil {
+		return nil, err
+	}
+	defer decoded.Close()
+	if declaredEncoded >= 0 {
+		res.declaredSize = declaredEncoded
+		if keep, why := c.keepBody(rawURL, res.contentType, res.declaredSize); !keep {
+			c.log.Info("Recording metadata only for", rawURL, why)
+			res.timing = t.done()
+			return res, nil
+		}
 	}
+	// Save only HTML and similar; still store others but don't parse links.
+	// When only writing WARC files the body goes to a temporary file that is
+	// removed once it has been archived and its links read.
+	keepFile := c.cfg.WritesFiles() || isRobotsURL(rawURL)
+	var out *os.File
+	if keepFile {
+		filePath, err := util.URLToFilePath(c.cfg.OutputDir, rawURL)
+		if c.cfg.Mirror {
+			filePath, err = util.MirrorFilePath(c.cfg.OutputDir, rawURL, res.contentType)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := util.EnsureDir(filepath.Dir(filePath)); err != nil {
+			return nil, fmt.Errorf("failed to create dir: %w", err)
+		}
+		out, err = os.Create(filePath)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		out, err = os.CreateTemp("", "boem-web-thing-*")
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(out.Name())
+	}
+	filePath := out.Name()
 	// Write response to disk
-	out, err := os.Create(filePath)
-	if err != nil {
-		return status, contentType, "", nil, err
+	// Read one byte past the limit so we can tell the body was too big
+	var body io.Reader = decoded
+	maxBytes := c.cfg.MaxBodyBytes
+	if maxBytes > 0 {
+		body = io.LimitReader(decoded, maxBytes+1)
 	}
-	_, err = io.Copy(out, resp.Body)
+	hash := sha256.New()
+	res.read, err = io.Copy(io.MultiWriter(out, hash), body)
+	res.timing = t.done()
 	out.Close()
 	if err != nil {
-		return status, contentType, "", nil, err
+		os.Remove(filePath)
+		return nil, err
 	}
-	// Re-fetch content for parsing (only if HTML)
-	if strings.Contains(contentType, "text/html") && status == http.StatusOK {
-		// We re-read from file to avoid touching the live network twice
-		f, err := os.Open(filePath)
+	// The rest of the body isn't downloaded, so its real size isn't known
+	if maxBytes > 0 && res.read > maxBytes {
+		c.log.Info("Recording metadata only for", rawURL, "body is larger than max_body_bytes")
+		os.Remove(filePath)
+		return res, nil
+	}
+	res.size = res.read
+	if keepFile {
+		res.filePath = filePath
+	}
+	res.bodyStored = true
+	res.contentHash = hex.EncodeToString(hash.Sum(nil))
+	res.charset = detectCharset(res.contentType, filePath)
+	// Archive the request and response
+	if c.warc != nil {
+		loc, err := c.warc.WriteExchange(resp, filePath)
 		if err != nil {
-			return status, contentType, filePath, nil, err
+			return res, fmt.Errorf("failed to write WARC record: %w", err)
 		}
-		defer f.Close()
-		pageLinks, err := extractLinks(rawURL, f)
+		res.warcFile = loc.Filename
+		res.warcOffset = loc.Offset
+	}
+	// Re-fetch content for parsing (only if HTML)
+	if strings.Contains(res.contentType, "text/html") && res.status == http.StatusOK {
+		// We re-read from file to avoid touching the live network twice
+		pageLinks, err := extractLinksFromFile(rawURL, filePath, res.charset)
 		if err != nil {
 			c.log.Error("Link parse error for", rawURL, ":", err)
 		} else {
-			links = pageLinks
+			res.links = pageLinks
+		}
+	}
+	if c.cfg.Mirror && res.status == http.StatusOK {
+		res.requisites = c.findRequisites(rawURL, res.contentType, res.charset, filePath)
+		if keepFile {
+			c.addSaved(rawURL, res.contentType, filePath)
 		}
 	}
 	c.log.Debug("End of fetchAndSave", rawURL)
-	return status, contentType, filePath, links, nil
+	return res, nil
+}
+
+// redirects follows the chain of requests back from a response to find
+// where the URL ended up, the status of the first redirect and how many
+// redirects there were. A response that was not redirected returns zeros.
+func redirects(resp *http.Response) (string, int, int) {
+	hops := 0
+	firstStatus := 0
+	for req := resp.Request; req != nil && req.Response != nil; req = req.Response.Request {
+		hops++
+		firstStatus = req.Response.StatusCode
+	}
+	if hops == 0 {
+		return "", 0, 0
+	}
+	return resp.Request.URL.String(), firstStatus, hops
+}
+
+// extractLinksFromFile opens a saved page and pulls out its links, reading
+// it as UTF-8 whatever character set it was saved in.
+func extractLinksFromFile(baseURL string, filePath string, charsetName string) ([]string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return extractLinks(baseURL, util.UTF8Reader(f, charsetName))
+}
+
+// do sends a request with the headers every crawler request carries
+func (c *Crawler) do(method string, rawURL string) (*http.Response, error) {
+	return c.doTimed(method, rawURL, nil)
+}
+
+// doTimed is do with the request's phases recorded in t, if t is not nil
+func (c *Crawler) doTimed(method string, rawURL string, t *timing) (*http.Response, error) {
+	req, err := http.NewRequest(method, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if t != nil {
+		req = req.WithContext(httptrace.WithClientTrace(req.Context(), t.trace()))
+	}
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+	return c.client.Do(req)
+}
+
+// isRobotsURL reports if the URL points at a robots.txt file
+func isRobotsURL(rawURL string) bool {
+	parsed, err := url.Parse(rawURL)
+	return err == nil && strings.HasSuffix(parsed.Path, "/robots.txt")
+}
+
+// keepBody applies the download policy from the configuration. When it returns
+// false the body is not stored and the reason says why.
+func (c *Crawler) keepBody(rawURL string, contentType string, declaredSize int64) (bool, string) {
+	// The crawler needs robots.txt whatever the policy says
+	if isRobotsURL(rawURL) {
+		return true, ""
+	}
+	if c.cfg.MaxBodyBytes > 0 && declaredSize > c.cfg.MaxBodyBytes {
+		return false, fmt.Sprintf("declared size %d is larger than max_body_bytes", declaredSize)
+	}
+	if util.MatchContentType(contentType, c.cfg.SkipContentTypes) {
+		return false, "content type " + contentType + " is in skip_content_types"
+	}
+	if len(c.cfg.StoreContentTypes) > 0 && !util.MatchContentType(contentType, c.cfg.StoreContentTypes) {
+		return false, "content type " + contentType + " is not in store_content_types"
+	}
+	return true, ""
 }
 
 // Validate the string as a possible URL, see if it is safe, in scope
-// and formatted as a URL correctly.
-func (c *Crawler) shouldVisit(raw string) bool {
+// and formatted as a URL correctly. Requisites of a page may also come from
+// the mirror requisite hosts.
+func (c *Crawler) shouldVisit(raw string, requisite bool) bool {
 	c.log.Debug("start shouldVisit")
 	parsed, err := url.Parse(raw)
 	if err != nil {
@@ -205,24 +584,29 @@ func (c *Crawler) shouldVisit(raw string) bool {
 	if parsed.Scheme != "http" && parsed.Scheme != "https" {
 		return false
 	}
-	if len(c.cfg.AllowedHosts) > 0 {
-		hostAllowed := false
-		for _, allowed := range c.cfg.AllowedHosts {
+	// Never follow a link that could log the crawler out
+	if c.cfg.HasAuth() && c.cfg.IsLogoutURL(raw) {
+		c.log.Debug("Not following logout link", raw)
+		return false
+	}
+	hostAllowed := c.cfg.SiteFor(parsed.Host) != nil
+	if !hostAllowed && requisite {
+		for _, allowed := range c.cfg.MirrorRequisiteHosts {
 			if strings.EqualFold(parsed.Host, allowed) {
 				hostAllowed = true
 				break
 			}
 		}
-		if !hostAllowed {
-			return false
-		}
+	}
+	if !hostAllowed {
+		return false
 	}
 
 	if c.isAlreadyVisited(parsed.String()) {
 		return false
 	}
 
-	if !c.isRobotsTxtAllowed(parsed.Path) {
+	if !c.isRobotsTxtAllowed(parsed) {
 		c.log.Info("robots.txt blocks link path", parsed.Path)
 		c.addToVisited(raw)
 		return false
@@ -230,6 +614,17 @@ func (c *Crawler) shouldVisit(raw string) bool {
 	return true
 }
 
+// claimVisit marks the URL as visited, returning false if it already was
+func (c *Crawler) claimVisit(u string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.visited[u] {
+		return false
+	}
+	c.visited[u] = true
+	return true
+}
+
 // look in the slice of visited URLs to see if we can already visited
 // the one in question. This can save us a trip for pulling a web page
 // and processing related links on ones we do pull.
@@ -281,46 +676,3 @@ func extractLinks(baseURL string, r io.Reader) ([]string, error) {
 		}
 	}
 }
-
-// Download a copy of the
-func readRobotsTxt(full_robots_path string) (*robotstxt.RobotsData, error) {
-	robotsFile, err := os.ReadFile(full_robots_path)
-	if err != nil {
-		return nil, err
-	}
-
-	robotsData := string(robotsFile)
-	robots, err := robotstxt.FromString(robotsData)
-	if err != nil {
-		return nil, err
-	}
-
-	return robots, nil
-}
-
-// Validate that the crawler is allowed to access the content as specified by the
-// copy of the robots.txt that was downloaded at the beginning of the session
-func (c *Crawler) isRobotsTxtAllowed(path string) bool {
-	c.log.Debug("Starting isRobotsTxtAllowed")
-	userAgent := c.cfg.UserAgent
-
-	//if the start url ends in /
-	assumedRobotsLocation := util.StripHTMLFile(c.cfg.StartURL) + "robots.txt"
-	robotsFilePath, err := util.URLToFilePath(c.cfg.OutputDir, assumedRobotsLocation)
-	if err != nil {
-		c.log.Error("Unable to find robots.txt:", err)
-		return true // If we can't read it, assume allowed
-	}
-	robots, err := readRobotsTxt(robotsFilePath)
-	if err != nil {
-		c.log.Error("Error reading robots.txt:", err)
-		return true // If we can't read it, assume allowed
-	}
-
-	group := robots.FindGroup(userAgent)
-	testResult := group.Test(path)
-
-	c.log.Debug("Ending isRobotsTxtAllowed")
-	return testResult
-
-}
@@ -0,0 +1,163 @@
+package crawler
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"boem-web-thing/config"
+	"boem-web-thing/logger"
+	"boem-web-thing/storage"
+)
+
+func TestCrawlRecordsRunInStore(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, `<a href="/a">a</a> <a href="/b">b</a> <a href="/c">c</a>`)
+		case "/a", "/b", "/c":
+			fmt.Fprint(w, `<a href="/">home</a>`)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	cfg := &config.Config{
+		OutputDir:    t.TempDir(),
+		HTTPTimeout:  5,
+		RateMs:       1,
+		Concurrency:  2,
+		OutputFormat: config.OutputFiles,
+		MaxPages:     2,
+		Sites:        []config.Site{{Name: "test", StartURLs: []string{server.URL + "/"}, AllowedHosts: []string{host}}},
+	}
+	log, err := logger.New(t.TempDir(), "info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	store := storage.NewMemory()
+
+	New(cfg, log, store).Crawl()
+
+	runs, err := store.GetRuns()
+	if err != nil || len(runs) != 1 {
+		t.Fatalf("GetRuns() = %v, %v", runs, err)
+	}
+	run := runs[0]
+	if run.Outcome != OutcomeBudgetExhausted || run.Pages_fetched != 2 {
+		t.Errorf("run = %+v", run)
+	}
+
+	pages, _ := store.GetRunPages(run.Id)
+	if len(pages) != 2 || pages[0].Url != server.URL+"/" || pages[0].Content_hash == "" {
+		t.Errorf("GetRunPages() = %+v", pages)
+	}
+	links, _ := store.GetRunLinks(run.Id)
+	if len(links) < 3 {
+		t.Errorf("GetRunLinks() = %+v, want at least the 3 links on the home page", links)
+	}
+	// The pages the budget cut off are still in the frontier
+	pending, _ := store.GetFrontier(run.Id)
+	if len(pending) != 2 {
+		t.Errorf("GetFrontier() = %v, want the 2 pages left unfetched", pending)
+	}
+}
+
+func TestRedirects(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/old", http.RedirectHandler("/moved", http.StatusMovedPermanently))
+	mux.Handle("/moved", http.RedirectHandler("/new", http.StatusFound))
+	mux.HandleFunc("/new", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "here")
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	final, status, hops := redirects(resp)
+	if final != server.URL+"/new" || status != http.StatusMovedPermanently || hops != 2 {
+		t.Errorf("redirects() = %q, %d, %d, want the final URL, 301 and 2 hops", final, status, hops)
+	}
+
+	resp, err = http.Get(server.URL + "/new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if final, status, hops := redirects(resp); final != "" || status != 0 || hops != 0 {
+		t.Errorf("redirects() without a redirect = %q, %d, %d", final, status, hops)
+	}
+}
+
+func TestReplayThroughRedirect(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, `<a href="/docs">docs</a>`)
+		case "/docs/":
+			fmt.Fprint(w, `<a href="/guide">only linked from the docs</a>`)
+		case "/guide":
+			fmt.Fprint(w, `<p>found</p>`)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	mux.Handle("/docs", http.RedirectHandler("/docs/", http.StatusMovedPermanently))
+	server := httptest.NewServer(mux)
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	cfg := &config.Config{
+		OutputDir:    t.TempDir(),
+		WARCDir:      t.TempDir(),
+		HTTPTimeout:  5,
+		RateMs:       1,
+		Concurrency:  1,
+		OutputFormat: config.OutputBoth,
+		Sites:        []config.Site{{Name: "test", StartURLs: []string{server.URL + "/"}, AllowedHosts: []string{host}}},
+	}
+	log, err := logger.New(t.TempDir(), "info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	store := storage.NewMemory()
+
+	New(cfg, log, store).Crawl()
+	server.Close()
+
+	// Replaying needs nothing from the server, the redirect is in the archive
+	cfg.ReplayFrom = config.ReplayWARC
+	New(cfg, log, store).Crawl()
+
+	runs, err := store.GetRuns()
+	if err != nil || len(runs) != 2 {
+		t.Fatalf("GetRuns() = %v, %v", runs, err)
+	}
+	for _, run := range runs {
+		pages, _ := store.GetRunPages(run.Id)
+		var urls []string
+		for _, pg := range pages {
+			urls = append(urls, strings.TrimPrefix(pg.Url, server.URL))
+		}
+		sort.Strings(urls)
+		if want := []string{"/", "/docs", "/guide"}; !reflect.DeepEqual(urls, want) {
+			t.Errorf("run %d fetched %v; want %v", run.Id, urls, want)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package crawler
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+
+	"boem-web-thing/util"
+
+	"github.com/andybalholm/brotli"
+	"golang.org/x/net/html/charset"
+)
+
+// acceptEncoding is sent with every request. Setting it ourselves stops Go's
+// transport from handling gzip quietly, so decodeBody has to undo all of these.
+const acceptEncoding = "gzip, deflate, br"
+
+// decodeBody undoes the Content-Encoding of a response so the body on disk is
+// the content itself. The encoding headers are removed from the response to
+// match, as Go does when it decompresses a response for you.
+func decodeBody(resp *http.Response) (io.ReadCloser, error) {
+	header := resp.Header.Get("Content-Encoding")
+	if header == "" {
+		return resp.Body, nil
+	}
+
+	// Encodings are listed in the order they were applied, so undo them backwards
+	codings := strings.Split(header, ",")
+	body := io.ReadCloser(resp.Body)
+	for i := len(codings) - 1; i >= 0; i-- {
+		var err error
+		switch strings.ToLower(strings.TrimSpace(codings[i])) {
+		case "", "identity":
+			continue
+		case "gzip", "x-gzip":
+			body, err = gzip.NewReader(body)
+		case "deflate":
+			body, err = newDeflateReader(body)
+		case "br":
+			body = io.NopCloser(brotli.NewReader(body))
+		default:
+			return nil, fmt.Errorf("unsupported Content-Encoding %q", codings[i])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("decoding %s body: %w", codings[i], err)
+		}
+	}
+
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return body, nil
+}
+
+// newDeflateReader reads "deflate" bodies, which should be zlib wrapped but
+// some servers send raw deflate data instead.
+func newDeflateReader(r io.Reader) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(r)
+	head, err := buffered.Peek(2)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	// A zlib header has a deflate method in the low bits and is a multiple of 31
+	if len(head) == 2 && head[0]&0x0f == 8 && (uint16(head[0])<<8|uint16(head[1]))%31 == 0 {
+		return zlib.NewReader(buffered)
+	}
+	return flate.NewReader(buffered), nil
+}
+
+// detectCharset works out the character set of a saved text file from the
+// Content-Type header, a byte order mark, or for HTML a <meta charset> tag.
+// Non-text content has no character set and returns an empty string.
+func detectCharset(contentType string, filePath string) string {
+	mediaType := util.MediaType(contentType)
+	isHTML := mediaType == "text/html" || mediaType == "application/xhtml+xml"
+	if !isHTML && !strings.HasPrefix(mediaType, "text/") {
+		return ""
+	}
+
+	// The HTML spec only looks at the first 1024 bytes for a charset
+	head := make([]byte, 1024)
+	f, err := os.Open(filePath)
+	if err != nil {
+		return ""
+	}
+	n, _ := io.ReadFull(f, head)
+	f.Close()
+	head = head[:n]
+
+	if isHTML {
+		_, name, _ := charset.DetermineEncoding(head, contentType)
+		return name
+	}
+	if _, name := charset.Lookup(bomLabel(head)); name != "" {
+		return name
+	}
+	if _, params, err := mime.ParseMediaType(contentType); err == nil && params["charset"] != "" {
+		if _, name := charset.Lookup(params["charset"]); name != "" {
+			return name
+		}
+	}
+	return "utf-8"
+}
+
+// bomLabel names the encoding a byte order mark announces, if there is one
+func bomLabel(b []byte) string {
+	switch {
+	case bytes.HasPrefix(b, []byte{0xEF, 0xBB, 0xBF}):
+		return "utf-8"
+	case bytes.HasPrefix(b, []byte{0xFE, 0xFF}):
+		return "utf-16be"
+	case bytes.HasPrefix(b, []byte{0xFF, 0xFE}):
+		return "utf-16le"
+	}
+	return ""
+}
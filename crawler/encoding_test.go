@@ -0,0 +1,91 @@
+package crawler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestDecodeBody(t *testing.T) {
+	want := "<html><body>hello</body></html>"
+
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write([]byte(want))
+	gw.Close()
+
+	var br bytes.Buffer
+	bw := brotli.NewWriter(&br)
+	bw.Write([]byte(want))
+	bw.Close()
+
+	tests := map[string][]byte{
+		"":         []byte(want),
+		"identity": []byte(want),
+		"gzip":     gz.Bytes(),
+		"br":       br.Bytes(),
+	}
+
+	for encoding, body := range tests {
+		resp := &http.Response{
+			Header: http.Header{"Content-Encoding": {encoding}},
+			Body:   io.NopCloser(bytes.NewReader(body)),
+		}
+		decoded, err := decodeBody(resp)
+		if err != nil {
+			t.Errorf("decodeBody(%q) error: %v", encoding, err)
+			continue
+		}
+		got, err := io.ReadAll(decoded)
+		if err != nil {
+			t.Errorf("reading %q body: %v", encoding, err)
+		}
+		if string(got) != want {
+			t.Errorf("decodeBody(%q) = %q; want %q", encoding, got, want)
+		}
+		if resp.Header.Get("Content-Encoding") != "" && encoding != "" {
+			t.Errorf("decodeBody(%q) left the Content-Encoding header", encoding)
+		}
+	}
+}
+
+func TestDetectCharset(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, content []byte) string {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	legacy := write("legacy.html", []byte("<html><head><meta charset=\"windows-1252\"></head><body>caf\xe9</body></html>"))
+	bom := write("bom.html", []byte("\xEF\xBB\xBF<html><body>hi</body></html>"))
+	css := write("site.css", []byte("body { color: red }"))
+	img := write("logo.png", []byte("\x89PNG"))
+
+	tests := []struct {
+		contentType string
+		path        string
+		expected    string
+	}{
+		{"text/html", legacy, "windows-1252"},
+		{"text/html; charset=iso-8859-1", bom, "utf-8"},
+		{"text/html; charset=utf-8", legacy, "utf-8"},
+		{"text/css", css, "utf-8"},
+		{"image/png", img, ""},
+	}
+
+	for _, tt := range tests {
+		result := detectCharset(tt.contentType, tt.path)
+		if result != tt.expected {
+			t.Errorf("detectCharset(%q, %s) = %q; want %q", tt.contentType, filepath.Base(tt.path), result, tt.expected)
+		}
+	}
+}
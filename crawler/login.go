@@ -0,0 +1,197 @@
+package crawler
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// loginForm is a form read from a login page
+type loginForm struct {
+	action      string
+	method      string
+	values      url.Values
+	hasPassword bool
+}
+
+// login submits the configured login form so the cookie jar holds a session
+// for the rest of the crawl.
+func (c *Crawler) login() error {
+	lf := c.cfg.LoginForm
+	c.log.Info("Logging in at", lf.URL)
+
+	resp, err := c.do(http.MethodGet, lf.URL)
+	if err != nil {
+		return fmt.Errorf("fetching login page: %w", err)
+	}
+	body, err := decodeBody(resp)
+	if err != nil {
+		resp.Body.Close()
+		return err
+	}
+	form, err := findLoginForm(lf.URL, body)
+	body.Close()
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	action := form.action
+	if lf.Action != "" {
+		base, _ := url.Parse(lf.URL)
+		a, err := base.Parse(lf.Action)
+		if err != nil {
+			return fmt.Errorf("bad login_form action: %w", err)
+		}
+		action = a.String()
+	}
+	for name, value := range lf.Values() {
+		form.values.Set(name, value)
+	}
+
+	// Submit the form the way a browser would: a GET form's values replace
+	// the query of its action
+	var encoded io.Reader
+	if form.method == http.MethodPost {
+		encoded = strings.NewReader(form.values.Encode())
+	} else {
+		u, err := url.Parse(action)
+		if err != nil {
+			return fmt.Errorf("bad login form action: %w", err)
+		}
+		u.RawQuery = form.values.Encode()
+		action = u.String()
+	}
+	req, err := http.NewRequest(form.method, action, encoded)
+	if err != nil {
+		return err
+	}
+	if encoded != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+	req.Header.Set("Referer", lf.URL)
+	resp, err = c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("submitting login form: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("login form returned %s", resp.Status)
+	}
+
+	if lf.SuccessText != "" {
+		decoded, err := decodeBody(resp)
+		if err != nil {
+			return err
+		}
+		defer decoded.Close()
+		page, err := io.ReadAll(decoded)
+		if err != nil {
+			return err
+		}
+		if !strings.Contains(string(page), lf.SuccessText) {
+			return fmt.Errorf("login failed, %q not found after submitting the form", lf.SuccessText)
+		}
+	}
+
+	c.log.Info("Logged in")
+	return nil
+}
+
+// findLoginForm returns the first form with a password field, or the first
+// form on the page if none has one. The values of its inputs are kept so
+// hidden fields like CSRF tokens are sent back.
+func findLoginForm(pageURL string, r io.Reader) (*loginForm, error) {
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var forms []*loginForm
+	var current *loginForm
+	tokenizer := html.NewTokenizer(r)
+	for {
+		tt := tokenizer.Next()
+		if tt == html.ErrorToken {
+			if tokenizer.Err() != io.EOF {
+				return nil, tokenizer.Err()
+			}
+			break
+		}
+		if tt == html.EndTagToken {
+			if name, _ := tokenizer.TagName(); string(name) == "form" {
+				current = nil
+			}
+			continue
+		}
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			continue
+		}
+
+		t := tokenizer.Token()
+		switch t.Data {
+		case "form":
+			current = &loginForm{action: pageURL, method: http.MethodGet, values: url.Values{}}
+			for _, attr := range t.Attr {
+				switch attr.Key {
+				case "action":
+					if a, err := base.Parse(strings.TrimSpace(attr.Val)); err == nil && attr.Val != "" {
+						current.action = a.String()
+					}
+				case "method":
+					// Anything but post is submitted as a GET, as browsers do
+					if strings.EqualFold(strings.TrimSpace(attr.Val), "post") {
+						current.method = http.MethodPost
+					}
+				}
+			}
+			forms = append(forms, current)
+		case "input":
+			if current == nil {
+				continue
+			}
+			var name, value, inputType string
+			for _, attr := range t.Attr {
+				switch attr.Key {
+				case "name":
+					name = attr.Val
+				case "value":
+					value = attr.Val
+				case "type":
+					inputType = strings.ToLower(attr.Val)
+				}
+			}
+			if inputType == "password" {
+				current.hasPassword = true
+			}
+			if name == "" || ((inputType == "checkbox" || inputType == "radio") && !hasAttr(t, "checked")) {
+				continue
+			}
+			current.values.Set(name, value)
+		}
+	}
+
+	if len(forms) == 0 {
+		return nil, fmt.Errorf("no form found on the login page %s", pageURL)
+	}
+	for _, f := range forms {
+		if f.hasPassword {
+			return f, nil
+		}
+	}
+	return forms[0], nil
+}
+
+func hasAttr(t html.Token, key string) bool {
+	for _, attr := range t.Attr {
+		if attr.Key == key {
+			return true
+		}
+	}
+	return false
+}
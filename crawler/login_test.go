@@ -0,0 +1,139 @@
+package crawler
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"boem-web-thing/config"
+	"boem-web-thing/logger"
+)
+
+func TestLoginAndHostAuth(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			fmt.Fprint(w, `<form action="/session" method="post">
+<input type="hidden" name="csrf" value="token123">
+<input type="text" name="user"><input type="password" name="pass"></form>`)
+			return
+		}
+	})
+	mux.HandleFunc("/session", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("csrf") != "token123" || r.Form.Get("user") != "crawler" || r.Form.Get("pass") != "secret" {
+			http.Error(w, "bad login", http.StatusForbidden)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "ok", Path: "/"})
+		fmt.Fprint(w, "Welcome. Log out")
+	})
+	mux.HandleFunc("/private", func(w http.ResponseWriter, r *http.Request) {
+		user, pass, _ := r.BasicAuth()
+		if cookie, err := r.Cookie("session"); err != nil || cookie.Value != "ok" || user != "basic" || pass != "pw" {
+			http.Error(w, "no", http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, "private")
+	})
+	loggedOut := false
+	mux.Handle("/away", http.RedirectHandler("/user/logout", http.StatusFound))
+	mux.HandleFunc("/user/logout", func(w http.ResponseWriter, r *http.Request) {
+		loggedOut = true
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	t.Setenv("TEST_LOGIN_PASSWORD", "secret")
+	cfg := &config.Config{
+		HTTPTimeout: 5,
+		RateMs:      1,
+		Auth:        []config.HostAuth{{Host: host, Username: "basic", Password: "pw"}},
+		LoginForm: &config.LoginForm{
+			URL:         server.URL + "/login",
+			Fields:      map[string]string{"user": "crawler"},
+			FieldEnvs:   map[string]string{"pass": "TEST_LOGIN_PASSWORD"},
+			SuccessText: "Log out",
+		},
+		LogoutPatterns: []string{"logout"},
+	}
+	log, err := logger.New(t.TempDir(), "info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+
+	c := New(cfg, log, nil)
+	if err := c.login(); err != nil {
+		t.Fatal("login() error:", err)
+	}
+
+	resp, err := c.do(http.MethodGet, server.URL+"/private")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("GET /private after login = %d; want 200", resp.StatusCode)
+	}
+
+	if c.shouldVisit(server.URL+"/user/logout", false) {
+		t.Error("shouldVisit allowed a logout link on an authenticated crawl")
+	}
+
+	// A redirect to a logout URL isn't followed either
+	resp, err = c.do(http.MethodGet, server.URL+"/away")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if loggedOut || resp.StatusCode != http.StatusFound {
+		t.Errorf("GET /away = %d, logged out %t; want the 302 without following it", resp.StatusCode, loggedOut)
+	}
+}
+
+func TestLoginGetForm(t *testing.T) {
+	for name, form := range map[string]string{
+		"method get": `<form action="/session?stale=1" method="get">`,
+		"no method":  `<form action="/session?stale=1">`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, form+`<input type="text" name="user"><input type="password" name="pass"></form>`)
+			})
+			mux.HandleFunc("/session", func(w http.ResponseWriter, r *http.Request) {
+				q := r.URL.Query()
+				if r.Method != http.MethodGet || q.Get("user") != "crawler" || q.Get("pass") != "secret" || q.Has("stale") {
+					http.Error(w, "bad login", http.StatusForbidden)
+					return
+				}
+				fmt.Fprint(w, "Welcome. Log out")
+			})
+			server := httptest.NewServer(mux)
+			defer server.Close()
+
+			cfg := &config.Config{
+				HTTPTimeout: 5,
+				RateMs:      1,
+				LoginForm: &config.LoginForm{
+					URL:         server.URL + "/login",
+					Fields:      map[string]string{"user": "crawler", "pass": "secret"},
+					SuccessText: "Log out",
+				},
+			}
+			log, err := logger.New(t.TempDir(), "info")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer log.Close()
+
+			if err := New(cfg, log, nil).login(); err != nil {
+				t.Error("login() error:", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package crawler
+
+import (
+	"os"
+
+	"boem-web-thing/mirror"
+	"boem-web-thing/util"
+)
+
+// savedFile is a file written to the output directory during this crawl
+type savedFile struct {
+	url         string
+	contentType string
+	filePath    string
+}
+
+func (c *Crawler) addSaved(rawURL, contentType, filePath string) {
+	c.mu.Lock()
+	c.saved = append(c.saved, savedFile{url: rawURL, contentType: contentType, filePath: filePath})
+	c.mu.Unlock()
+}
+
+// findRequisites reads a saved HTML page or stylesheet for the files it needs
+// to display, so they can be downloaded for the mirror.
+func (c *Crawler) findRequisites(rawURL, contentType, charsetName, filePath string) []string {
+	switch util.MediaType(contentType) {
+	case "text/html", "application/xhtml+xml":
+		f, err := os.Open(filePath)
+		if err != nil {
+			c.log.Error("Unable to read page for requisites", rawURL, err)
+			return nil
+		}
+		defer f.Close()
+		requisites, err := mirror.ExtractRequisites(rawURL, util.UTF8Reader(f, charsetName))
+		if err != nil {
+			c.log.Error("Requisite parse error for", rawURL, ":", err)
+		}
+		return requisites
+	case "text/css":
+		css, err := os.ReadFile(filePath)
+		if err != nil {
+			c.log.Error("Unable to read stylesheet for requisites", rawURL, err)
+			return nil
+		}
+		return mirror.CSSURLs(rawURL, css)
+	}
+	return nil
+}
+
+// convertLinks rewrites the HTML and CSS saved during this crawl so their links
+// point at the local copies. This has to wait until the crawl is over, as the
+// local name of a link isn't known until it has been downloaded.
+func (c *Crawler) convertLinks() {
+	c.log.Info("Converting links for the offline mirror")
+
+	pages, err := c.store.GetPages()
+	if err != nil {
+		c.log.Error("Unable to load pages for link conversion", err)
+		return
+	}
+	local := make(map[string]string, len(pages))
+	for _, pg := range pages {
+		if pg.Body_stored && pg.File_path != "" {
+			local[pg.Url] = pg.File_path
+		}
+	}
+	lookup := func(absURL string) (string, bool) {
+		p, ok := local[absURL]
+		return p, ok
+	}
+
+	c.mu.Lock()
+	saved := c.saved
+	c.saved = nil
+	c.mu.Unlock()
+
+	for _, s := range saved {
+		var rewrite func([]byte) ([]byte, error)
+		switch util.MediaType(s.contentType) {
+		case "text/html", "application/xhtml+xml":
+			rewrite = func(src []byte) ([]byte, error) {
+				return mirror.RewriteHTML(src, s.url, s.filePath, lookup)
+			}
+		case "text/css":
+			rewrite = func(src []byte) ([]byte, error) {
+				return mirror.RewriteCSS(src, s.url, s.filePath, lookup), nil
+			}
+		default:
+			continue
+		}
+
+		src, err := os.ReadFile(s.filePath)
+		if err != nil {
+			c.log.Error("Unable to read", s.filePath, "for link conversion", err)
+			continue
+		}
+		converted, err := rewrite(src)
+		if err != nil {
+			c.log.Error("Link conversion failed for", s.url, ":", err)
+			continue
+		}
+		if err := os.WriteFile(s.filePath, converted, 0644); err != nil {
+			c.log.Error("Unable to write converted", s.filePath, err)
+		}
+	}
+}
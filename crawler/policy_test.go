@@ -0,0 +1,93 @@
+package crawler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"boem-web-thing/config"
+	"boem-web-thing/logger"
+)
+
+func TestBodyPolicy(t *testing.T) {
+	body := strings.Repeat("x", 100)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/declared":
+			w.Header().Set("Content-Type", "text/html")
+			w.Header().Set("Content-Length", "100")
+			w.Write([]byte(body))
+		case "/streamed":
+			// Flushing first sends the body chunked, without a Content-Length
+			w.Header().Set("Content-Type", "text/html")
+			w.(http.Flusher).Flush()
+			w.Write([]byte(body))
+		case "/video":
+			w.Header().Set("Content-Type", "video/mp4")
+			w.Write([]byte("frames"))
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<p>small</p>"))
+		}
+	}))
+	defer server.Close()
+
+	outputDir := t.TempDir()
+	cfg := &config.Config{
+		OutputDir:        outputDir,
+		HTTPTimeout:      5,
+		RateMs:           1,
+		OutputFormat:     config.OutputFiles,
+		MaxBodyBytes:     50,
+		SkipContentTypes: []string{"video/*"},
+	}
+	log, err := logger.New(t.TempDir(), "info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	c := New(cfg, log, nil)
+
+	tests := []struct {
+		path     string
+		stored   bool
+		declared int64
+		size     int64
+		read     int64
+	}{
+		// Skipped from the HEAD request's Content-Length without downloading
+		{"/declared", false, 100, 0, 0},
+		// Downloaded until it passed the limit, then dropped
+		{"/streamed", false, -1, 0, 51},
+		{"/video", false, 6, 0, 0},
+		{"/small", true, 12, 12, 12},
+	}
+	for _, tt := range tests {
+		res, err := c.fetchAndSave(server.URL + tt.path)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.path, err)
+		}
+		if res.bodyStored != tt.stored || res.declaredSize != tt.declared || res.size != tt.size || res.read != tt.read {
+			t.Errorf("%s: stored %t, declared %d, size %d, read %d; want %t, %d, %d, %d",
+				tt.path, res.bodyStored, res.declaredSize, res.size, res.read, tt.stored, tt.declared, tt.size, tt.read)
+		}
+		if tt.stored != (res.filePath != "") {
+			t.Errorf("%s: file path = %q", tt.path, res.filePath)
+		}
+	}
+
+	// Dropped bodies aren't left on disk, only the small page is
+	var saved []string
+	filepath.WalkDir(outputDir, func(path string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			saved = append(saved, path)
+		}
+		return nil
+	})
+	if len(saved) != 1 {
+		t.Errorf("saved files = %v, want just the small page", saved)
+	}
+}
@@ -0,0 +1,75 @@
+package crawler
+
+import (
+	"net/url"
+	"os"
+	"strings"
+
+	"boem-web-thing/util"
+
+	"github.com/temoto/robotstxt"
+)
+
+// robotsURLFor is where robots.txt is downloaded from for the URL's host. For
+// the host of a start URL it sits next to the start URL, so a site under test
+// in a sub folder can have its own. Other hosts use the one at their root.
+func (c *Crawler) robotsURLFor(u *url.URL) string {
+	for _, seed := range c.cfg.Seeds() {
+		if s, err := url.Parse(seed); err == nil && strings.EqualFold(s.Host, u.Host) {
+			return util.StripHTMLFile(seed) + "robots.txt"
+		}
+	}
+	return u.Scheme + "://" + u.Host + "/robots.txt"
+}
+
+// robotsFor returns the robots.txt rules for the URL's host, downloading them
+// the first time the host is seen. It returns nil if there are no rules.
+func (c *Crawler) robotsFor(u *url.URL) *robotstxt.RobotsData {
+	host := strings.ToLower(u.Host)
+
+	// Hold the lock while downloading so each robots.txt is only fetched once
+	c.robotMu.Lock()
+	defer c.robotMu.Unlock()
+	if robots, ok := c.robots[host]; ok {
+		return robots
+	}
+
+	robotsURL := c.robotsURLFor(u)
+	c.log.Debug("Downloading", robotsURL)
+	var robots *robotstxt.RobotsData
+	res, err := c.fetchAndSave(robotsURL)
+	if err != nil {
+		c.log.Error("Error Downloading Robots.txt", robotsURL, err)
+	} else {
+		var body []byte
+		if res.filePath != "" {
+			body, _ = os.ReadFile(res.filePath)
+		}
+		// A missing robots.txt allows everything, a server error blocks everything
+		robots, err = robotstxt.FromStatusAndBytes(res.status, body)
+		if err != nil {
+			c.log.Error("Error reading robots.txt:", robotsURL, err)
+			robots = nil
+		}
+	}
+	c.robots[host] = robots
+	return robots
+}
+
+// Validate that the crawler is allowed to access the content as specified by the
+// copy of the site's robots.txt downloaded during this session
+func (c *Crawler) isRobotsTxtAllowed(u *url.URL) bool {
+	c.log.Debug("Starting isRobotsTxtAllowed")
+
+	if site := c.cfg.SiteFor(u.Host); site != nil && !site.ObeysRobots() {
+		return true
+	}
+	robots := c.robotsFor(u)
+	if robots == nil {
+		return true // If we can't read it, assume allowed
+	}
+
+	testResult := robots.FindGroup(c.cfg.UserAgent).Test(u.Path)
+	c.log.Debug("Ending isRobotsTxtAllowed")
+	return testResult
+}
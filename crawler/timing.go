@@ -0,0 +1,120 @@
+package crawler
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// timing follows the requests made for one page with httptrace. A page takes
+// a HEAD and a GET, and usually only the first opens a connection, so the
+// connection phases add up across both while time to first byte is from the
+// last request made.
+type timing struct {
+	mu           sync.Mutex
+	began        time.Time
+	requestStart time.Time
+	dnsStart     time.Time
+	connectStart time.Time
+	tlsStart     time.Time
+	result       pageTiming
+}
+
+// pageTiming is where the time went fetching a page
+type pageTiming struct {
+	dns     time.Duration
+	connect time.Duration
+	tls     time.Duration
+	ttfb    time.Duration // from sending the request to the first byte of the response
+	total   time.Duration // from the first request to the end of the body
+}
+
+func newTiming() *timing {
+	return &timing{began: time.Now()}
+}
+
+// trace returns the hooks for one request and marks its start
+func (t *timing) trace() *httptrace.ClientTrace {
+	t.mu.Lock()
+	t.requestStart = time.Now()
+	t.mu.Unlock()
+
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			t.mu.Lock()
+			t.dnsStart = time.Now()
+			t.mu.Unlock()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.mu.Lock()
+			t.result.dns += since(t.dnsStart)
+			t.mu.Unlock()
+		},
+		ConnectStart: func(string, string) {
+			t.mu.Lock()
+			t.connectStart = time.Now()
+			t.mu.Unlock()
+		},
+		ConnectDone: func(_, _ string, err error) {
+			t.mu.Lock()
+			if err == nil {
+				t.result.connect += since(t.connectStart)
+			}
+			t.mu.Unlock()
+		},
+		TLSHandshakeStart: func() {
+			t.mu.Lock()
+			t.tlsStart = time.Now()
+			t.mu.Unlock()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			t.mu.Lock()
+			t.result.tls += since(t.tlsStart)
+			t.mu.Unlock()
+		},
+		GotFirstResponseByte: func() {
+			t.mu.Lock()
+			t.result.ttfb = since(t.requestStart)
+			t.mu.Unlock()
+		},
+	}
+}
+
+// done records the total time and returns the timings
+func (t *timing) done() pageTiming {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.result.total = time.Since(t.began)
+	return t.result
+}
+
+func since(start time.Time) time.Duration {
+	if start.IsZero() {
+		return 0
+	}
+	return time.Since(start)
+}
+
+// ms converts a duration to fractional milliseconds for storage
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// headerJSON encodes response headers for storage, empty if there are none.
+// Set-Cookie is left out, like credentials are left out of archived
+// requests, so sessions from authenticated crawls aren't kept.
+func headerJSON(h http.Header) string {
+	if len(h) == 0 {
+		return ""
+	}
+	h = h.Clone()
+	h.Del("Set-Cookie")
+	b, err := json.Marshal(h)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
@@ -0,0 +1,63 @@
+package crawler
+
+import (
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"boem-web-thing/config"
+	"boem-web-thing/logger"
+)
+
+func TestFetchRecordsHeadersAndTiming(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Content-Encoding", "gzip")
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "secret"})
+		if r.Method == http.MethodGet {
+			time.Sleep(20 * time.Millisecond)
+		}
+		gz := gzip.NewWriter(w)
+		gz.Write([]byte("<p>slow</p>"))
+		gz.Close()
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{OutputDir: t.TempDir(), HTTPTimeout: 5, RateMs: 1, OutputFormat: config.OutputFiles}
+	log, err := logger.New(t.TempDir(), "info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+
+	res, err := New(cfg, log, nil).fetchAndSave(server.URL + "/slow")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := res.headers.Get("Cache-Control"); got != "max-age=60" {
+		t.Errorf("Cache-Control = %q", got)
+	}
+	// Kept as sent even though the body is saved decoded
+	if got := res.headers.Get("Content-Encoding"); got != "gzip" {
+		t.Errorf("Content-Encoding = %q, want gzip", got)
+	}
+	if res.timing.connect <= 0 {
+		t.Errorf("connect time = %v, want the HEAD request's connection", res.timing.connect)
+	}
+	if res.timing.ttfb < 20*time.Millisecond {
+		t.Errorf("time to first byte = %v, want at least the GET's 20ms", res.timing.ttfb)
+	}
+	if res.timing.total < res.timing.ttfb {
+		t.Errorf("total %v is less than time to first byte %v", res.timing.total, res.timing.ttfb)
+	}
+	if got := headerJSON(res.headers); got == "" {
+		t.Error("headers did not encode")
+	} else if strings.Contains(got, "secret") || !strings.Contains(got, "max-age=60") {
+		t.Errorf("stored headers = %s, want them without Set-Cookie", got)
+	}
+}
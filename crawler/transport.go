@@ -0,0 +1,154 @@
+package crawler
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"boem-web-thing/config"
+)
+
+// hostTransport adds the User-Agent, the configured headers and credentials
+// for a host to each request going to it, then hands the request to the base
+// transport. Everything is matched on the host of every request, so nothing is
+// sent to another host after a redirect. An entry for a host name covers
+// every port on it, an entry for host:port only that port.
+type hostTransport struct {
+	base      http.RoundTripper
+	userAgent string
+	auth      map[string]config.HostAuth   // keyed by lower case host or host:port
+	headers   map[string]map[string]string // keyed by lower case host or host:port, "*" for all
+}
+
+func newHostTransport(cfg *config.Config, base http.RoundTripper) *hostTransport {
+	t := &hostTransport{
+		base:      base,
+		userAgent: cfg.UserAgent,
+		auth:      make(map[string]config.HostAuth),
+		headers:   make(map[string]map[string]string),
+	}
+	for _, a := range cfg.Auth {
+		t.auth[strings.ToLower(a.Host)] = a
+	}
+	for host, headers := range cfg.HostHeaders {
+		t.headers[strings.ToLower(host)] = headers
+	}
+	return t
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *hostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	keys := hostKeys(req.URL)
+
+	// A RoundTripper must not change the request it was given
+	req = req.Clone(req.Context())
+	if t.userAgent != "" && req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", t.userAgent)
+	}
+	for name, value := range t.headers["*"] {
+		req.Header.Set(name, value)
+	}
+	// The most specific entry is applied last, so its headers win
+	for i := len(keys) - 1; i >= 0; i-- {
+		for name, value := range t.headers[keys[i]] {
+			req.Header.Set(name, value)
+		}
+	}
+
+	var a config.HostAuth
+	ok := false
+	for _, key := range keys {
+		if a, ok = t.auth[key]; ok {
+			break
+		}
+	}
+	if !ok || req.Header.Get("Authorization") != "" {
+		return t.base.RoundTrip(req)
+	}
+	if token, ok := a.Bearer(); ok {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if user, pass, ok := a.Basic(); ok {
+		req.SetBasicAuth(user, pass)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// hostKeys are the entries a request's host matches, most specific first:
+// the host with its port, then the host name alone
+func hostKeys(u *url.URL) []string {
+	host := strings.ToLower(u.Host)
+	if name := strings.ToLower(u.Hostname()); name != host {
+		return []string{host, name}
+	}
+	return []string{host}
+}
+
+// newBaseTransport builds the network transport from the proxy and TLS options
+func newBaseTransport(cfg *config.Config) (http.RoundTripper, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+
+	if cfg.ProxyURL != "" {
+		proxy, err := url.Parse(cfg.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("bad proxy_url: %w", err)
+		}
+		t.Proxy = http.ProxyURL(proxy)
+	}
+
+	if cfg.CABundle != "" {
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		pem, err := os.ReadFile(cfg.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("reading ca_bundle: %w", err)
+		}
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in ca_bundle %s", cfg.CABundle)
+		}
+		t.TLSClientConfig = &tls.Config{RootCAs: roots}
+	}
+
+	if len(cfg.InsecureTLSHosts) == 0 {
+		return t, nil
+	}
+
+	// Hosts that opted out of certificate checks get their own transport, so
+	// connections to every other host are still verified
+	insecure := t.Clone()
+	if insecure.TLSClientConfig == nil {
+		insecure.TLSClientConfig = &tls.Config{}
+	}
+	insecure.TLSClientConfig.InsecureSkipVerify = true
+
+	hosts := make(map[string]bool)
+	for _, host := range cfg.InsecureTLSHosts {
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		hosts[strings.ToLower(host)] = true
+	}
+	return &tlsRouter{secure: t, insecure: insecure, insecureHosts: hosts}, nil
+}
+
+// tlsRouter sends requests for the insecure TLS hosts to a transport that
+// skips certificate checks, and everything else to the normal transport.
+type tlsRouter struct {
+	secure        http.RoundTripper
+	insecure      http.RoundTripper
+	insecureHosts map[string]bool
+}
+
+// RoundTrip implements http.RoundTripper
+func (r *tlsRouter) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme == "https" && r.insecureHosts[strings.ToLower(req.URL.Hostname())] {
+		return r.insecure.RoundTrip(req)
+	}
+	return r.secure.RoundTrip(req)
+}
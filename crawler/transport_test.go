@@ -0,0 +1,127 @@
+package crawler
+
+import (
+	"encoding/pem"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"boem-web-thing/config"
+)
+
+func TestBaseTransportTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caFile, caPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		cfg  config.Config
+		ok   bool
+	}{
+		{"default rejects self-signed", config.Config{}, false},
+		{"insecure host allowed", config.Config{InsecureTLSHosts: []string{"127.0.0.1"}}, true},
+		{"other insecure host still checked", config.Config{InsecureTLSHosts: []string{"staging.example.gov"}}, false},
+		{"ca bundle trusted", config.Config{CABundle: caFile}, true},
+	}
+
+	for _, tt := range tests {
+		transport, err := newBaseTransport(&tt.cfg)
+		if err != nil {
+			t.Errorf("%s: newBaseTransport error: %v", tt.name, err)
+			continue
+		}
+		resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: GET error = %v; want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestHostTransportHeaders(t *testing.T) {
+	var got http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	cfg := &config.Config{
+		UserAgent: "boem-web-thing/test",
+		HostHeaders: map[string]map[string]string{
+			"*":                 {"X-Everyone": "yes"},
+			host:                {"X-WAF-Allow": "crawler"},
+			"other.example.gov": {"X-Other": "no"},
+		},
+		Auth: []config.HostAuth{{Host: host, BearerToken: "abc"}},
+	}
+	client := &http.Client{Transport: newHostTransport(cfg, http.DefaultTransport)}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	expected := map[string]string{
+		"User-Agent":    "boem-web-thing/test",
+		"X-Everyone":    "yes",
+		"X-Waf-Allow":   "crawler",
+		"X-Other":       "",
+		"Authorization": "Bearer abc",
+	}
+	for name, want := range expected {
+		if got.Get(name) != want {
+			t.Errorf("header %s = %q; want %q", name, got.Get(name), want)
+		}
+	}
+}
+
+func TestHostTransportPorts(t *testing.T) {
+	var got http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+	name, _, _ := net.SplitHostPort(host)
+
+	cfg := &config.Config{
+		HostHeaders: map[string]map[string]string{
+			name:        {"X-Matched": "name", "X-Name": "yes"},
+			host:        {"X-Matched": "port"},
+			name + ":1": {"X-Other-Port": "yes"},
+		},
+		Auth: []config.HostAuth{{Host: name, BearerToken: "abc"}},
+	}
+	client := &http.Client{Transport: newHostTransport(cfg, http.DefaultTransport)}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// An entry without a port covers every port, one with a port wins over it
+	expected := map[string]string{
+		"X-Matched":     "port",
+		"X-Name":        "yes",
+		"X-Other-Port":  "",
+		"Authorization": "Bearer abc",
+	}
+	for header, want := range expected {
+		if got.Get(header) != want {
+			t.Errorf("header %s = %q; want %q", header, got.Get(header), want)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package diff
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"boem-web-thing/storage"
+)
+
+// Run is everything recorded about one crawl run that a diff looks at
+type Run struct {
+	Run   storage.CrawlRuns
+	Pages []storage.Pages
+	Links []storage.Links
+}
+
+// Report is what changed between two crawl runs
+type Report struct {
+	From          RunSummary     `json:"from"`
+	To            RunSummary     `json:"to"`
+	Added         []string       `json:"added"`
+	Removed       []string       `json:"removed"`
+	Changed       []string       `json:"changed"`
+	StatusChanges []StatusChange `json:"status_changes"`
+	BrokenLinks   []BrokenLink   `json:"broken_links"`
+	NewIssues     []Issue        `json:"new_issues"`
+}
+
+// RunSummary identifies a run in a report
+type RunSummary struct {
+	ID        int64     `json:"id"`
+	StartURL  string    `json:"start_url"`
+	StartedAt time.Time `json:"started_at"`
+	Pages     int       `json:"pages"`
+}
+
+// StatusChange is a page that answered with a different status code
+type StatusChange struct {
+	URL  string `json:"url"`
+	From int    `json:"from"`
+	To   int    `json:"to"`
+}
+
+// BrokenLink is a link to a page that now returns an error
+type BrokenLink struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Status int    `json:"status"`
+}
+
+// Issue is an accessibility issue found by a scan of a page
+type Issue struct {
+	URL      string `json:"url"`
+	Code     string `json:"code"`
+	Type     string `json:"type"`
+	Message  string `json:"message"`
+	Selector string `json:"selector"`
+}
+
+// Empty reports if nothing changed between the runs
+func (r *Report) Empty() bool {
+	return len(r.Added) == 0 && len(r.Removed) == 0 && len(r.Changed) == 0 &&
+		len(r.StatusChanges) == 0 && len(r.BrokenLinks) == 0 && len(r.NewIssues) == 0
+}
+
+// Compare works out what changed going from one run to another. Pages are
+// matched by URL and their content is compared by hash, so pages whose body
+// was not kept are never reported as changed.
+func Compare(from, to Run) *Report {
+	report := &Report{
+		From:          summarize(from),
+		To:            summarize(to),
+		Added:         []string{},
+		Removed:       []string{},
+		Changed:       []string{},
+		StatusChanges: []StatusChange{},
+		BrokenLinks:   []BrokenLink{},
+		NewIssues:     []Issue{},
+	}
+
+	before := byURL(from.Pages)
+	after := byURL(to.Pages)
+
+	for u, page := range after {
+		old, ok := before[u]
+		if !ok {
+			report.Added = append(report.Added, u)
+			continue
+		}
+		if old.Status_code != page.Status_code {
+			report.StatusChanges = append(report.StatusChanges, StatusChange{URL: u, From: old.Status_code, To: page.Status_code})
+		}
+		if old.Content_hash != "" && page.Content_hash != "" && old.Content_hash != page.Content_hash {
+			report.Changed = append(report.Changed, u)
+		}
+	}
+	for u := range before {
+		if _, ok := after[u]; !ok {
+			report.Removed = append(report.Removed, u)
+		}
+	}
+
+	// A link that was already broken is not news, even if its status changed
+	wasBroken := make(map[[2]string]bool)
+	for _, link := range brokenLinks(from, before) {
+		wasBroken[[2]string{link.From, link.To}] = true
+	}
+	for _, link := range brokenLinks(to, after) {
+		if !wasBroken[[2]string{link.From, link.To}] {
+			report.BrokenLinks = append(report.BrokenLinks, link)
+		}
+	}
+
+	for u, page := range after {
+		seen := make(map[Issue]bool)
+		if old, ok := before[u]; ok {
+			for _, issue := range ParseIssues(u, old.Scan_results) {
+				seen[issue] = true
+			}
+		}
+		for _, issue := range ParseIssues(u, page.Scan_results) {
+			if !seen[issue] {
+				seen[issue] = true
+				report.NewIssues = append(report.NewIssues, issue)
+			}
+		}
+	}
+
+	sort.Strings(report.Added)
+	sort.Strings(report.Removed)
+	sort.Strings(report.Changed)
+	sort.Slice(report.StatusChanges, func(i, j int) bool {
+		return report.StatusChanges[i].URL < report.StatusChanges[j].URL
+	})
+	sort.Slice(report.BrokenLinks, func(i, j int) bool {
+		a, b := report.BrokenLinks[i], report.BrokenLinks[j]
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		return a.To < b.To
+	})
+	sort.SliceStable(report.NewIssues, func(i, j int) bool {
+		return report.NewIssues[i].URL < report.NewIssues[j].URL
+	})
+	return report
+}
+
+// ParseIssues reads the issues out of a scan result saved by the pa11y json
+// reporter. Results in any other format have no issues that can be compared.
+func ParseIssues(pageURL string, scanResults string) []Issue {
+	if scanResults == "" {
+		return nil
+	}
+	var raw []struct {
+		Code     string `json:"code"`
+		Type     string `json:"type"`
+		Message  string `json:"message"`
+		Selector string `json:"selector"`
+	}
+	if err := json.Unmarshal([]byte(scanResults), &raw); err != nil {
+		return nil
+	}
+	issues := make([]Issue, 0, len(raw))
+	for _, r := range raw {
+		issues = append(issues, Issue{URL: pageURL, Code: r.Code, Type: r.Type, Message: r.Message, Selector: r.Selector})
+	}
+	return issues
+}
+
+func summarize(run Run) RunSummary {
+	return RunSummary{
+		ID:        run.Run.Id,
+		StartURL:  run.Run.Start_url,
+		StartedAt: run.Run.Started_at,
+		Pages:     len(run.Pages),
+	}
+}
+
+func byURL(pages []storage.Pages) map[string]storage.Pages {
+	m := make(map[string]storage.Pages, len(pages))
+	for _, pg := range pages {
+		m[pg.Url] = pg
+	}
+	return m
+}
+
+// brokenLinks are the links in a run to pages that returned an error, once
+// each. Links to pages the run never fetched can't be judged so are left out.
+func brokenLinks(run Run, pages map[string]storage.Pages) []BrokenLink {
+	var broken []BrokenLink
+	seen := make(map[BrokenLink]bool)
+	for _, link := range run.Links {
+		target, ok := pages[link.To_url]
+		if !ok || target.Status_code < 400 {
+			continue
+		}
+		bl := BrokenLink{From: link.From_url, To: link.To_url, Status: target.Status_code}
+		if !seen[bl] {
+			seen[bl] = true
+			broken = append(broken, bl)
+		}
+	}
+	return broken
+}
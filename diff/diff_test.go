@@ -0,0 +1,102 @@
+package diff
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"boem-web-thing/storage"
+)
+
+func TestCompare(t *testing.T) {
+	from := Run{
+		Run: storage.CrawlRuns{Id: 1},
+		Pages: []storage.Pages{
+			{Url: "https://example.com/", Status_code: 200, Content_hash: "aaa"},
+			{Url: "https://example.com/same", Status_code: 200, Content_hash: "bbb"},
+			{Url: "https://example.com/old", Status_code: 200, Content_hash: "ccc"},
+			{Url: "https://example.com/moved", Status_code: 200, Content_hash: "ddd"},
+			{Url: "https://example.com/already-broken", Status_code: 404},
+		},
+		Links: []storage.Links{
+			{From_url: "https://example.com/", To_url: "https://example.com/already-broken"},
+		},
+	}
+	to := Run{
+		Run: storage.CrawlRuns{Id: 2},
+		Pages: []storage.Pages{
+			{Url: "https://example.com/", Status_code: 200, Content_hash: "a2a", Scan_results: `[{"code":"H37","type":"error","message":"Img missing alt","selector":"img"}]`},
+			{Url: "https://example.com/same", Status_code: 200, Content_hash: "bbb"},
+			{Url: "https://example.com/new", Status_code: 200, Content_hash: "eee"},
+			{Url: "https://example.com/moved", Status_code: 404},
+			{Url: "https://example.com/already-broken", Status_code: 410},
+		},
+		Links: []storage.Links{
+			{From_url: "https://example.com/", To_url: "https://example.com/moved"},
+			{From_url: "https://example.com/", To_url: "https://example.com/moved"},
+			{From_url: "https://example.com/", To_url: "https://example.com/already-broken"},
+		},
+	}
+
+	r := Compare(from, to)
+
+	if want := []string{"https://example.com/new"}; !reflect.DeepEqual(r.Added, want) {
+		t.Errorf("Added = %v, want %v", r.Added, want)
+	}
+	if want := []string{"https://example.com/old"}; !reflect.DeepEqual(r.Removed, want) {
+		t.Errorf("Removed = %v, want %v", r.Removed, want)
+	}
+	// /moved has no body any more so can't be compared by hash
+	if want := []string{"https://example.com/"}; !reflect.DeepEqual(r.Changed, want) {
+		t.Errorf("Changed = %v, want %v", r.Changed, want)
+	}
+	wantStatus := []StatusChange{
+		{URL: "https://example.com/already-broken", From: 404, To: 410},
+		{URL: "https://example.com/moved", From: 200, To: 404},
+	}
+	if !reflect.DeepEqual(r.StatusChanges, wantStatus) {
+		t.Errorf("StatusChanges = %v, want %v", r.StatusChanges, wantStatus)
+	}
+	wantBroken := []BrokenLink{{From: "https://example.com/", To: "https://example.com/moved", Status: 404}}
+	if !reflect.DeepEqual(r.BrokenLinks, wantBroken) {
+		t.Errorf("BrokenLinks = %v, want %v", r.BrokenLinks, wantBroken)
+	}
+	if len(r.NewIssues) != 1 || r.NewIssues[0].Code != "H37" || r.NewIssues[0].URL != "https://example.com/" {
+		t.Errorf("NewIssues = %v", r.NewIssues)
+	}
+}
+
+func TestCompareKnownIssuesAreNotNew(t *testing.T) {
+	issues := `[{"code":"H37","type":"error","message":"Img missing alt","selector":"img"}]`
+	from := Run{Pages: []storage.Pages{{Url: "https://example.com/", Scan_results: issues}}}
+	to := Run{Pages: []storage.Pages{{Url: "https://example.com/", Scan_results: issues}}}
+	if r := Compare(from, to); !r.Empty() {
+		t.Errorf("expected no changes, got %+v", r)
+	}
+}
+
+func TestParseIssuesIgnoresOtherFormats(t *testing.T) {
+	if issues := ParseIssues("https://example.com/", "Welcome to Pa11y\n No issues found!"); len(issues) != 0 {
+		t.Errorf("ParseIssues = %v, want none", issues)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	r := Compare(Run{}, Run{Pages: []storage.Pages{{Url: "https://example.com/<new>"}}})
+	for _, format := range []string{FormatText, FormatJSON, FormatHTML} {
+		var buf bytes.Buffer
+		if err := Write(&buf, r, format); err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if !strings.Contains(buf.String(), "example.com") {
+			t.Errorf("%s output is missing the added page: %s", format, buf.String())
+		}
+		if format == FormatHTML && strings.Contains(buf.String(), "<new>") {
+			t.Error("HTML output does not escape URLs")
+		}
+	}
+	if err := Write(&bytes.Buffer{}, r, "xml"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
@@ -0,0 +1,146 @@
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"time"
+)
+
+// Output formats
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+	FormatHTML = "html"
+)
+
+// Write writes the report in the named format
+func Write(w io.Writer, r *Report, format string) error {
+	switch format {
+	case FormatText, "":
+		return WriteText(w, r)
+	case FormatJSON:
+		return WriteJSON(w, r)
+	case FormatHTML:
+		return WriteHTML(w, r)
+	}
+	return fmt.Errorf("unknown format %q, use text, json or html", format)
+}
+
+// WriteText writes the report for reading in a terminal
+func WriteText(w io.Writer, r *Report) error {
+	ew := &errWriter{w: w}
+	ew.printf("Comparing run %d (%s, %d pages) with run %d (%s, %d pages)\n",
+		r.From.ID, r.From.StartedAt.Format(time.DateTime), r.From.Pages,
+		r.To.ID, r.To.StartedAt.Format(time.DateTime), r.To.Pages)
+	if r.Empty() {
+		ew.printf("No changes\n")
+		return ew.err
+	}
+
+	section := func(title string, n int) bool {
+		if n == 0 {
+			return false
+		}
+		ew.printf("\n%s (%d)\n", title, n)
+		return true
+	}
+	if section("Pages added", len(r.Added)) {
+		for _, u := range r.Added {
+			ew.printf("  + %s\n", u)
+		}
+	}
+	if section("Pages removed", len(r.Removed)) {
+		for _, u := range r.Removed {
+			ew.printf("  - %s\n", u)
+		}
+	}
+	if section("Pages changed", len(r.Changed)) {
+		for _, u := range r.Changed {
+			ew.printf("  ~ %s\n", u)
+		}
+	}
+	if section("Status code changes", len(r.StatusChanges)) {
+		for _, c := range r.StatusChanges {
+			ew.printf("  %d -> %d  %s\n", c.From, c.To, c.URL)
+		}
+	}
+	if section("Newly broken links", len(r.BrokenLinks)) {
+		for _, l := range r.BrokenLinks {
+			ew.printf("  %s -> %s (%d)\n", l.From, l.To, l.Status)
+		}
+	}
+	if section("New accessibility issues", len(r.NewIssues)) {
+		for _, i := range r.NewIssues {
+			ew.printf("  %s\n    [%s] %s\n    %s\n    %s\n", i.URL, i.Type, i.Code, i.Message, i.Selector)
+		}
+	}
+	return ew.err
+}
+
+// WriteJSON writes the report as indented JSON
+func WriteJSON(w io.Writer, r *Report) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteHTML writes the report as a standalone HTML page
+func WriteHTML(w io.Writer, r *Report) error {
+	return htmlReport.Execute(w, r)
+}
+
+var htmlReport = template.Must(template.New("diff").Funcs(template.FuncMap{
+	"datetime": func(t time.Time) string { return t.Format(time.DateTime) },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Crawl diff: run {{.From.ID}} to run {{.To.ID}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; vertical-align: top; }
+th { background: #eee; }
+</style>
+</head>
+<body>
+<h1>Crawl diff: run {{.From.ID}} to run {{.To.ID}}</h1>
+<p>Run {{.From.ID}} started {{datetime .From.StartedAt}} with {{.From.Pages}} pages.
+Run {{.To.ID}} started {{datetime .To.StartedAt}} with {{.To.Pages}} pages.</p>
+{{if .Empty}}<p>No changes.</p>{{end}}
+{{with .Added}}<h2>Pages added ({{len .}})</h2>
+<ul>{{range .}}<li><a href="{{.}}">{{.}}</a></li>{{end}}</ul>{{end}}
+{{with .Removed}}<h2>Pages removed ({{len .}})</h2>
+<ul>{{range .}}<li><a href="{{.}}">{{.}}</a></li>{{end}}</ul>{{end}}
+{{with .Changed}}<h2>Pages changed ({{len .}})</h2>
+<ul>{{range .}}<li><a href="{{.}}">{{.}}</a></li>{{end}}</ul>{{end}}
+{{with .StatusChanges}}<h2>Status code changes ({{len .}})</h2>
+<table><tr><th scope="col">Page</th><th scope="col">Before</th><th scope="col">After</th></tr>
+{{range .}}<tr><td><a href="{{.URL}}">{{.URL}}</a></td><td>{{.From}}</td><td>{{.To}}</td></tr>
+{{end}}</table>{{end}}
+{{with .BrokenLinks}}<h2>Newly broken links ({{len .}})</h2>
+<table><tr><th scope="col">On page</th><th scope="col">Link</th><th scope="col">Status</th></tr>
+{{range .}}<tr><td><a href="{{.From}}">{{.From}}</a></td><td>{{.To}}</td><td>{{.Status}}</td></tr>
+{{end}}</table>{{end}}
+{{with .NewIssues}}<h2>New accessibility issues ({{len .}})</h2>
+<table><tr><th scope="col">Page</th><th scope="col">Type</th><th scope="col">Code</th><th scope="col">Message</th><th scope="col">Selector</th></tr>
+{{range .}}<tr><td><a href="{{.URL}}">{{.URL}}</a></td><td>{{.Type}}</td><td>{{.Code}}</td><td>{{.Message}}</td><td><code>{{.Selector}}</code></td></tr>
+{{end}}</table>{{end}}
+</body>
+</html>
+`))
+
+// errWriter keeps the first write error so the text report can ignore them until the end
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (e *errWriter) printf(format string, args ...any) {
+	if e.err != nil {
+		return
+	}
+	_, e.err = fmt.Fprintf(e.w, format, args...)
+}
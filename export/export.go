@@ -0,0 +1,302 @@
+// Package export writes the pages, links, redirects and scan issues in the
+// database to files analysts can open in a spreadsheet or load elsewhere.
+package export
+
+import (
+	"fmt"
+	"strings"
+
+	"boem-web-thing/storage"
+)
+
+// Datasets that can be exported
+const (
+	DatasetPages     = "pages"
+	DatasetLinks     = "links"
+	DatasetRedirects = "redirects"
+	DatasetIssues    = "issues"
+)
+
+// Table is an exported dataset, one row of values per record in column order
+type Table struct {
+	Columns []string
+	Rows    [][]any
+}
+
+// column is one field a dataset can export. Optional columns are large, like
+// the raw scan results, and are only written when asked for by name.
+type column[T any] struct {
+	name     string
+	value    func(T) any
+	optional bool
+}
+
+var pageColumns = []column[storage.Pages]{
+	{name: "id", value: func(pg storage.Pages) any { return pg.Id }},
+	{name: "url", value: func(pg storage.Pages) any { return pg.Url }},
+	{name: "host", value: func(pg storage.Pages) any { return pg.Host }},
+	{name: "status_code", value: func(pg storage.Pages) any { return pg.Status_code }},
+	{name: "content_type", value: func(pg storage.Pages) any { return pg.Content_type }},
+	{name: "charset", value: func(pg storage.Pages) any { return pg.Charset }},
+	{name: "fetched_at", value: func(pg storage.Pages) any { return pg.Fetched_at }},
+	{name: "run_id", value: func(pg storage.Pages) any { return pg.Run_id }},
+	{name: "file_path", value: func(pg storage.Pages) any { return pg.File_path }},
+	{name: "declared_size", value: func(pg storage.Pages) any { return pg.Declared_size }},
+	{name: "actual_size", value: func(pg storage.Pages) any { return pg.Actual_size }},
+	{name: "body_stored", value: func(pg storage.Pages) any { return pg.Body_stored }},
+	{name: "content_hash", value: func(pg storage.Pages) any { return pg.Content_hash }},
+	{name: "warc_filename", value: func(pg storage.Pages) any { return pg.Warc_filename }},
+	{name: "warc_offset", value: func(pg storage.Pages) any { return pg.Warc_offset }},
+	{name: "dns_ms", value: func(pg storage.Pages) any { return pg.Dns_ms }},
+	{name: "connect_ms", value: func(pg storage.Pages) any { return pg.Connect_ms }},
+	{name: "tls_ms", value: func(pg storage.Pages) any { return pg.Tls_ms }},
+	{name: "ttfb_ms", value: func(pg storage.Pages) any { return pg.Ttfb_ms }},
+	{name: "total_ms", value: func(pg storage.Pages) any { return pg.Total_ms }},
+	{name: "final_url", value: func(pg storage.Pages) any { return pg.Final_url }},
+	{name: "redirect_status", value: func(pg storage.Pages) any { return pg.Redirect_status }},
+	{name: "redirect_hops", value: func(pg storage.Pages) any { return pg.Redirect_hops }},
+	{name: "scanned", value: func(pg storage.Pages) any { return pg.Scan_results != "" }},
+	{name: "scan_status", value: func(pg storage.Pages) any { return pg.Scan_status }},
+	{name: "headers", value: func(pg storage.Pages) any { return pg.Headers }, optional: true},
+	{name: "scan_results", value: func(pg storage.Pages) any { return pg.Scan_results }, optional: true},
+}
+
+var redirectColumns = []column[storage.Pages]{
+	{name: "url", value: func(pg storage.Pages) any { return pg.Url }},
+	{name: "redirect_status", value: func(pg storage.Pages) any { return pg.Redirect_status }},
+	{name: "final_url", value: func(pg storage.Pages) any { return pg.Final_url }},
+	{name: "final_status", value: func(pg storage.Pages) any { return pg.Status_code }},
+	{name: "redirect_hops", value: func(pg storage.Pages) any { return pg.Redirect_hops }},
+	{name: "run_id", value: func(pg storage.Pages) any { return pg.Run_id }},
+	{name: "fetched_at", value: func(pg storage.Pages) any { return pg.Fetched_at }},
+}
+
+var linkColumns = []column[storage.Links]{
+	{name: "run_id", value: func(l storage.Links) any { return l.Run_id }},
+	{name: "from_url", value: func(l storage.Links) any { return l.From_url }},
+	{name: "to_url", value: func(l storage.Links) any { return l.To_url }},
+}
+
+var issueColumns = []column[storage.Issues]{
+	{name: "scan_run_id", value: func(i storage.Issues) any { return i.Scan_run_id }},
+	{name: "url", value: func(i storage.Issues) any { return i.Page_url }},
+	{name: "host", value: func(i storage.Issues) any { return i.Host }},
+	{name: "code", value: func(i storage.Issues) any { return i.Code }},
+	{name: "type", value: func(i storage.Issues) any { return i.Type }},
+	{name: "message", value: func(i storage.Issues) any { return i.Message }},
+	{name: "selector", value: func(i storage.Issues) any { return i.Selector }},
+	{name: "context", value: func(i storage.Issues) any { return i.Context }},
+	{name: "runner", value: func(i storage.Issues) any { return i.Runner }},
+}
+
+// Filter selects the records to export
+type Filter struct {
+	storage.PageQuery
+	ScanRunID int64 // issues found by this scan run, the latest when zero
+}
+
+// Columns returns the columns a dataset can export, in their default order
+func Columns(dataset string) ([]string, error) {
+	switch dataset {
+	case DatasetPages:
+		return names(pageColumns), nil
+	case DatasetLinks:
+		return names(linkColumns), nil
+	case DatasetRedirects:
+		return names(redirectColumns), nil
+	case DatasetIssues:
+		return names(issueColumns), nil
+	}
+	return nil, fmt.Errorf("unknown dataset %q, use pages, links, redirects or issues", dataset)
+}
+
+// Build reads a dataset from the store. The page query filters the pages that
+// pages, redirects and issues are read from. Issues are read for the filter's
+// scan run and links for its crawl run, or the latest run when it has none,
+// and only the host filter and paging apply to links. Columns picks the
+// columns to write by name; when it is empty every column except the
+// optional ones is written.
+func Build(store storage.Store, dataset string, f Filter, columns []string) (*Table, error) {
+	q := f.PageQuery
+	switch dataset {
+	case DatasetPages:
+		pages, err := store.QueryPages(q)
+		if err != nil {
+			return nil, err
+		}
+		return build(pageColumns, pages, columns)
+
+	case DatasetRedirects:
+		// Filter before paging so the limit counts redirects, not pages
+		limit, offset := q.Limit, q.Offset
+		q.Limit, q.Offset = 0, 0
+		pages, err := store.QueryPages(q)
+		if err != nil {
+			return nil, err
+		}
+		var redirected []storage.Pages
+		for _, pg := range pages {
+			if pg.Final_url != "" {
+				redirected = append(redirected, pg)
+			}
+		}
+		return build(redirectColumns, page(redirected, limit, offset), columns)
+
+	case DatasetIssues:
+		scanRunID := f.ScanRunID
+		if scanRunID == 0 {
+			runs, err := store.GetScanRuns()
+			if err != nil {
+				return nil, err
+			}
+			if len(runs) == 0 {
+				return build(issueColumns, nil, columns)
+			}
+			scanRunID = runs[0].Id
+		}
+		iq := storage.IssueQuery{ScanRunID: scanRunID, Hosts: q.Hosts}
+		if !filtersPages(q) {
+			iq.Limit, iq.Offset = q.Limit, q.Offset
+			issues, err := store.QueryIssues(iq)
+			if err != nil {
+				return nil, err
+			}
+			return build(issueColumns, issues, columns)
+		}
+
+		// Other page filters pick the pages first, then their issues
+		q.Limit, q.Offset = 0, 0
+		pages, err := store.QueryPages(q)
+		if err != nil {
+			return nil, err
+		}
+		wanted := make(map[string]bool, len(pages))
+		for _, pg := range pages {
+			wanted[pg.Url] = true
+		}
+		issues, err := store.QueryIssues(iq)
+		if err != nil {
+			return nil, err
+		}
+		var kept []storage.Issues
+		for _, issue := range issues {
+			if wanted[issue.Page_url] {
+				kept = append(kept, issue)
+			}
+		}
+		return build(issueColumns, page(kept, f.Limit, f.Offset), columns)
+
+	case DatasetLinks:
+		runID := q.RunID
+		if runID == 0 {
+			runs, err := store.GetRuns()
+			if err != nil {
+				return nil, err
+			}
+			if len(runs) == 0 {
+				return build(linkColumns, nil, columns)
+			}
+			runID = runs[0].Id
+		}
+		links, err := store.GetRunLinks(runID)
+		if err != nil {
+			return nil, err
+		}
+		if len(q.Hosts) > 0 {
+			var kept []storage.Links
+			for _, l := range links {
+				if containsFold(q.Hosts, storage.HostOf(l.From_url)) {
+					kept = append(kept, l)
+				}
+			}
+			links = kept
+		}
+		return build(linkColumns, page(links, q.Limit, q.Offset), columns)
+	}
+	_, err := Columns(dataset)
+	return nil, err
+}
+
+// filtersPages reports if the query filters on more than the host, which the
+// issues store can filter on by itself
+func filtersPages(q storage.PageQuery) bool {
+	return len(q.StatusCodes) > 0 || len(q.ContentTypes) > 0 || q.PathPrefix != "" ||
+		!q.FetchedAfter.IsZero() || !q.FetchedBefore.IsZero() || q.RunID != 0 || q.MinTotalMs > 0 ||
+		q.ScanState != storage.ScanStateAny || len(q.ScanStatuses) > 0
+}
+
+// build picks the named columns and fills in a row for each record
+func build[T any](all []column[T], records []T, columns []string) (*Table, error) {
+	picked, err := pick(all, columns)
+	if err != nil {
+		return nil, err
+	}
+	t := &Table{Columns: names(picked), Rows: make([][]any, 0, len(records))}
+	for _, rec := range records {
+		row := make([]any, len(picked))
+		for i, col := range picked {
+			row[i] = col.value(rec)
+		}
+		t.Rows = append(t.Rows, row)
+	}
+	return t, nil
+}
+
+func pick[T any](all []column[T], columns []string) ([]column[T], error) {
+	if len(columns) == 0 {
+		var picked []column[T]
+		for _, col := range all {
+			if !col.optional {
+				picked = append(picked, col)
+			}
+		}
+		return picked, nil
+	}
+	picked := make([]column[T], 0, len(columns))
+	for _, name := range columns {
+		found := false
+		for _, col := range all {
+			if col.name == strings.TrimSpace(name) {
+				picked = append(picked, col)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown column %q, choose from %s", name, strings.Join(names(all), ", "))
+		}
+	}
+	return picked, nil
+}
+
+func names[T any](cols []column[T]) []string {
+	out := make([]string, len(cols))
+	for i, col := range cols {
+		out[i] = col.name
+	}
+	return out
+}
+
+// page applies a limit and offset to records that were filtered in Go
+func page[T any](records []T, limit, offset int) []T {
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= len(records) {
+		return nil
+	}
+	records = records[offset:]
+	if limit > 0 && limit < len(records) {
+		records = records[:limit]
+	}
+	return records
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,140 @@
+package export
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"boem-web-thing/storage"
+)
+
+func testStore(t *testing.T) storage.Store {
+	s := storage.NewMemory()
+	runID, err := s.StartRun("https://example.com/", "{}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetched := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	pages := []storage.Pages{
+		{Url: "https://example.com/", Status_code: 200, Content_type: "text/html", File_path: "a.html", Fetched_at: fetched, Run_id: runID, Total_ms: 12.5},
+		{Url: "https://example.com/old", Status_code: 200, Content_type: "text/html", File_path: "b.html", Fetched_at: fetched, Run_id: runID,
+			Final_url: "https://example.com/new", Redirect_status: 301, Redirect_hops: 1},
+		{Url: "https://other.example/", Status_code: 404, Content_type: "text/html", File_path: "c.html", Fetched_at: fetched, Run_id: runID},
+	}
+	for _, pg := range pages {
+		if err := s.SavePage(pg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	scanRunID, err := s.StartScanRun()
+	if err != nil {
+		t.Fatal(err)
+	}
+	issues := []storage.Issues{
+		{Code: "WCAG2AA.H37", Type: "error", Message: "=Img missing alt", Selector: "img", Runner: "htmlcs"},
+		{Code: "WCAG2AA.G18", Type: "warning", Message: `Contrast, "low"`, Selector: "p", Runner: "htmlcs"},
+	}
+	if err := s.SaveIssues(scanRunID, "https://example.com/", issues); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveIssues(scanRunID, "https://other.example/", issues[:1]); err != nil {
+		t.Fatal(err)
+	}
+	for _, to := range []string{"https://example.com/old", "https://other.example/"} {
+		if err := s.SaveLink(runID, "https://example.com/", to); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+func TestBuild(t *testing.T) {
+	s := testStore(t)
+
+	pages, err := Build(s, DatasetPages, Filter{PageQuery: storage.PageQuery{Hosts: []string{"example.com"}}}, []string{"url", "status_code", "total_ms"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages.Rows) != 2 || strings.Join(pages.Columns, ",") != "url,status_code,total_ms" || pages.Rows[0][2] != 12.5 {
+		t.Errorf("pages = %+v", pages)
+	}
+
+	redirects, err := Build(s, DatasetRedirects, Filter{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(redirects.Rows) != 1 || redirects.Rows[0][2] != "https://example.com/new" {
+		t.Errorf("redirects = %+v", redirects)
+	}
+
+	issues, err := Build(s, DatasetIssues, Filter{PageQuery: storage.PageQuery{Limit: 1, Offset: 1}}, []string{"code", "type"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(issues.Rows) != 1 || issues.Rows[0][0] != "WCAG2AA.G18" {
+		t.Errorf("issues = %+v, want the second issue", issues)
+	}
+	// Page filters other than the host pick the pages whose issues are kept
+	issues, err = Build(s, DatasetIssues, Filter{PageQuery: storage.PageQuery{StatusCodes: []int{404}}}, []string{"url", "code"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(issues.Rows) != 1 || issues.Rows[0][0] != "https://other.example/" {
+		t.Errorf("issues of 404 pages = %+v", issues)
+	}
+
+	// Links default to the latest run
+	links, err := Build(s, DatasetLinks, Filter{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(links.Rows) != 2 {
+		t.Errorf("links = %+v", links)
+	}
+
+	if _, err := Build(s, DatasetPages, Filter{}, []string{"nope"}); err == nil {
+		t.Error("Build() with an unknown column should fail")
+	}
+	if _, err := Build(s, "sitemaps", Filter{}, nil); err == nil {
+		t.Error("Build() with an unknown dataset should fail")
+	}
+}
+
+func TestWrite(t *testing.T) {
+	table := &Table{
+		Columns: []string{"url", "message", "ms", "when"},
+		Rows: [][]any{
+			{"https://example.com/", "=HYPERLINK(\"x\")", 1.5, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)},
+			{"https://example.com/a", "tab\there", -2.0, time.Time{}},
+		},
+	}
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{FormatCSV, "url,message,ms,when\n" +
+			"https://example.com/,\"=HYPERLINK(\"\"x\"\")\",1.5,2024-05-01T12:00:00Z\n" +
+			"https://example.com/a,tab\there,-2,\n"},
+		{FormatExcel, "\ufeffurl,message,ms,when\r\n" +
+			"https://example.com/,\"'=HYPERLINK(\"\"x\"\")\",1.5,2024-05-01T12:00:00Z\r\n" +
+			"https://example.com/a,tab\there,-2,\r\n"},
+		{FormatJSONL, `{"url":"https://example.com/","message":"=HYPERLINK(\"x\")","ms":1.5,"when":"2024-05-01T12:00:00Z"}` + "\n" +
+			`{"url":"https://example.com/a","message":"tab\there","ms":-2,"when":null}` + "\n"},
+		{FormatTSV, "url\tmessage\tms\twhen\n" +
+			"https://example.com/\t=HYPERLINK(\"x\")\t1.5\t2024-05-01T12:00:00Z\n" +
+			"https://example.com/a\ttab here\t-2\t\n"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := Write(&buf, table, tt.format); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != tt.want {
+			t.Errorf("Write(%s) =\n%q\nwant\n%q", tt.format, buf.String(), tt.want)
+		}
+	}
+	if err := Write(&bytes.Buffer{}, table, "parquet"); err == nil {
+		t.Error("Write() with an unknown format should fail")
+	}
+}
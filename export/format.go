@@ -0,0 +1,161 @@
+package export
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Output formats
+const (
+	FormatCSV   = "csv"
+	FormatExcel = "excel" // CSV that Excel opens as UTF-8 without mangling
+	FormatJSONL = "jsonl" // one JSON object per line
+	FormatTSV   = "tsv"   // tab separated plain text
+)
+
+// Write writes the table in the named format
+func Write(w io.Writer, t *Table, format string) error {
+	switch format {
+	case FormatCSV, "":
+		return writeCSV(w, t, false)
+	case FormatExcel:
+		return writeCSV(w, t, true)
+	case FormatJSONL:
+		return writeJSONL(w, t)
+	case FormatTSV:
+		return writeTSV(w, t)
+	}
+	return fmt.Errorf("unknown format %q, use csv, excel, jsonl or tsv", format)
+}
+
+// writeCSV writes RFC 4180 CSV. For Excel it starts with a byte order mark so
+// the file is read as UTF-8, ends lines with CRLF, and quotes cells that
+// Excel would otherwise run as formulas.
+func writeCSV(w io.Writer, t *Table, excel bool) error {
+	if excel {
+		if _, err := io.WriteString(w, "\ufeff"); err != nil {
+			return err
+		}
+	}
+	cw := csv.NewWriter(w)
+	cw.UseCRLF = excel
+	if err := cw.Write(t.Columns); err != nil {
+		return err
+	}
+	record := make([]string, len(t.Columns))
+	for _, row := range t.Rows {
+		for i, v := range row {
+			record[i] = formatValue(v)
+			if excel {
+				record[i] = defuseFormula(record[i])
+			}
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeJSONL writes each row as a JSON object with the keys in column order
+func writeJSONL(w io.Writer, t *Table) error {
+	bw := bufio.NewWriter(w)
+	keys := make([][]byte, len(t.Columns))
+	for i, name := range t.Columns {
+		key, err := json.Marshal(name)
+		if err != nil {
+			return err
+		}
+		keys[i] = key
+	}
+	for _, row := range t.Rows {
+		bw.WriteByte('{')
+		for i, v := range row {
+			if i > 0 {
+				bw.WriteByte(',')
+			}
+			val, err := json.Marshal(jsonValue(v))
+			if err != nil {
+				return err
+			}
+			bw.Write(keys[i])
+			bw.WriteByte(':')
+			bw.Write(val)
+		}
+		bw.WriteString("}\n")
+	}
+	return bw.Flush()
+}
+
+// writeTSV writes tab separated values with no quoting, so tabs and line
+// breaks inside values become spaces
+func writeTSV(w io.Writer, t *Table) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString(strings.Join(t.Columns, "\t") + "\n")
+	clean := strings.NewReplacer("\t", " ", "\r\n", " ", "\n", " ", "\r", " ")
+	for _, row := range t.Rows {
+		for i, v := range row {
+			if i > 0 {
+				bw.WriteByte('\t')
+			}
+			bw.WriteString(clean.Replace(formatValue(v)))
+		}
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
+
+// formatValue turns a value into text for the delimited formats
+func formatValue(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case time.Time:
+		if v.IsZero() {
+			return ""
+		}
+		return v.UTC().Format(time.RFC3339)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case nil:
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+// jsonValue leaves values as their JSON types, except zero times become null
+func jsonValue(v any) any {
+	if t, ok := v.(time.Time); ok {
+		if t.IsZero() {
+			return nil
+		}
+		return t.UTC().Format(time.RFC3339)
+	}
+	return v
+}
+
+// defuseFormula stops a spreadsheet reading text like a link or message from
+// a page as a formula, by starting it with a quote
+func defuseFormula(s string) string {
+	if s == "" {
+		return s
+	}
+	switch s[0] {
+	case '=', '+', '-', '@', '\t', '\r':
+		// Negative numbers are safe
+		if _, err := strconv.ParseFloat(s, 64); err == nil {
+			return s
+		}
+		return "'" + s
+	}
+	return s
+}
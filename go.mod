@@ -3,10 +3,12 @@ module boem-web-thing
 go 1.24.6
 
 require (
+	github.com/andybalholm/brotli v1.1.1
 	github.com/spf13/cobra v1.9.1
 	github.com/temoto/robotstxt v1.1.2
 	go.etcd.io/bbolt v1.3.10
 	golang.org/x/net v0.0.0-20210916014120-12bc252f5db8
+	golang.org/x/text v0.27.0
 	modernc.org/sqlite v1.38.2
 )
 
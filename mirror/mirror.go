@@ -0,0 +1,333 @@
+package mirror
+
+import (
+	"bytes"
+	"io"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// requisiteAttrs are the attributes on each tag that load something the page
+// needs to display, as opposed to a link to another page.
+var requisiteAttrs = map[string][]string{
+	"img":    {"src", "srcset"},
+	"source": {"src", "srcset"},
+	"script": {"src"},
+	"link":   {"href"},
+	"video":  {"src", "poster"},
+	"audio":  {"src"},
+	"track":  {"src"},
+	"embed":  {"src"},
+	"object": {"data"},
+	"iframe": {"src"},
+	"frame":  {"src"},
+	"input":  {"src"},
+}
+
+// linkAttrs are the attributes that link to other pages
+var linkAttrs = map[string][]string{
+	"a":    {"href"},
+	"area": {"href"},
+}
+
+// linkRels are the <link rel> values worth downloading for an offline copy
+var linkRels = []string{"stylesheet", "icon", "shortcut", "apple-touch-icon", "preload", "manifest"}
+
+var (
+	cssURLPattern    = regexp.MustCompile(`url\(\s*(?:'([^']*)'|"([^"]*)"|([^'")\s]*))\s*\)`)
+	cssImportPattern = regexp.MustCompile(`@import\s+(?:'([^']*)'|"([^"]*)")`)
+)
+
+// ExtractRequisites returns the absolute URLs of the images, stylesheets,
+// scripts and other files an HTML page needs to display.
+func ExtractRequisites(baseURL string, r io.Reader) ([]string, error) {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var found []string
+	inStyle := false
+	tokenizer := html.NewTokenizer(r)
+	for {
+		tt := tokenizer.Next()
+		switch tt {
+		case html.ErrorToken:
+			if tokenizer.Err() == io.EOF {
+				return found, nil
+			}
+			return found, tokenizer.Err()
+		case html.StartTagToken, html.SelfClosingTagToken:
+			t := tokenizer.Token()
+			inStyle = t.Data == "style" && tt == html.StartTagToken
+			if t.Data == "link" && !isRequisiteLink(t) {
+				continue
+			}
+			for _, attr := range t.Attr {
+				if attr.Key == "style" {
+					found = append(found, CSSURLs(baseURL, []byte(attr.Val))...)
+					continue
+				}
+				if !hasAttr(requisiteAttrs[t.Data], attr.Key) {
+					continue
+				}
+				for _, ref := range attrURLs(attr) {
+					if parsed, err := base.Parse(ref); err == nil {
+						found = append(found, withoutFragment(parsed))
+					}
+				}
+			}
+		case html.EndTagToken:
+			inStyle = false
+		case html.TextToken:
+			if inStyle {
+				found = append(found, CSSURLs(baseURL, tokenizer.Text())...)
+			}
+		}
+	}
+}
+
+// CSSURLs returns the absolute URLs referenced by url() and @import in a stylesheet
+func CSSURLs(baseURL string, css []byte) []string {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return nil
+	}
+	var found []string
+	for _, pattern := range []*regexp.Regexp{cssURLPattern, cssImportPattern} {
+		for _, m := range pattern.FindAllSubmatch(css, -1) {
+			ref := strings.TrimSpace(string(firstGroup(m)))
+			if ref == "" || strings.HasPrefix(ref, "data:") || strings.HasPrefix(ref, "#") {
+				continue
+			}
+			if parsed, err := base.Parse(ref); err == nil {
+				found = append(found, withoutFragment(parsed))
+			}
+		}
+	}
+	return found
+}
+
+// LocalFunc maps an absolute URL to the file it was saved to, if it was saved
+type LocalFunc func(absURL string) (string, bool)
+
+// RewriteHTML rewrites the links and requisites in a saved page to point at the
+// local copies. pagePath is where the page itself is saved. Links to anything
+// that was not saved are made absolute so they still reach the live site.
+func RewriteHTML(src []byte, pageURL string, pagePath string, local LocalFunc) ([]byte, error) {
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	inStyle := false
+	tokenizer := html.NewTokenizer(bytes.NewReader(src))
+	for {
+		tt := tokenizer.Next()
+		switch tt {
+		case html.ErrorToken:
+			if tokenizer.Err() == io.EOF {
+				return out.Bytes(), nil
+			}
+			return nil, tokenizer.Err()
+		case html.StartTagToken, html.SelfClosingTagToken:
+			raw := append([]byte(nil), tokenizer.Raw()...)
+			t := tokenizer.Token()
+			inStyle = t.Data == "style" && tt == html.StartTagToken
+			changed := false
+			for i, attr := range t.Attr {
+				var value string
+				switch {
+				case attr.Key == "style":
+					value = string(RewriteCSS([]byte(attr.Val), pageURL, pagePath, local))
+				case hasAttr(requisiteAttrs[t.Data], attr.Key) || hasAttr(linkAttrs[t.Data], attr.Key):
+					value = rewriteAttr(attr, base, pagePath, local)
+				default:
+					continue
+				}
+				if value != attr.Val {
+					t.Attr[i].Val = value
+					changed = true
+				}
+			}
+			if changed {
+				out.WriteString(t.String())
+			} else {
+				out.Write(raw)
+			}
+		case html.TextToken:
+			if inStyle {
+				out.Write(RewriteCSS(tokenizer.Raw(), pageURL, pagePath, local))
+			} else {
+				out.Write(tokenizer.Raw())
+			}
+		default:
+			if tt == html.EndTagToken {
+				inStyle = false
+			}
+			out.Write(tokenizer.Raw())
+		}
+	}
+}
+
+// RewriteCSS rewrites url() and @import references in a stylesheet saved at
+// cssPath to point at the local copies.
+func RewriteCSS(css []byte, cssURL string, cssPath string, local LocalFunc) []byte {
+	base, err := url.Parse(cssURL)
+	if err != nil {
+		return css
+	}
+	replace := func(quote string, ref string) string {
+		return quote + rewriteRef(ref, base, cssPath, local) + quote
+	}
+	css = cssURLPattern.ReplaceAllFunc(css, func(m []byte) []byte {
+		groups := cssURLPattern.FindSubmatch(m)
+		ref, quote := refAndQuote(groups)
+		if skipRef(ref) {
+			return m
+		}
+		return []byte("url(" + replace(quote, ref) + ")")
+	})
+	css = cssImportPattern.ReplaceAllFunc(css, func(m []byte) []byte {
+		groups := cssImportPattern.FindSubmatch(m)
+		ref, quote := refAndQuote(groups)
+		if skipRef(ref) {
+			return m
+		}
+		return []byte("@import " + replace(quote, ref))
+	})
+	return css
+}
+
+// RelativePath is the link from a file saved at fromPath to one saved at toPath
+func RelativePath(fromPath, toPath string) string {
+	rel, err := filepath.Rel(filepath.Dir(fromPath), toPath)
+	if err != nil {
+		return filepath.ToSlash(toPath)
+	}
+	return (&url.URL{Path: filepath.ToSlash(rel)}).String()
+}
+
+func rewriteAttr(attr html.Attribute, base *url.URL, pagePath string, local LocalFunc) string {
+	if attr.Key != "srcset" {
+		return rewriteRef(attr.Val, base, pagePath, local)
+	}
+	// srcset is a list of "url descriptor" pairs
+	candidates := strings.Split(attr.Val, ",")
+	for i, candidate := range candidates {
+		fields := strings.Fields(candidate)
+		if len(fields) == 0 {
+			continue
+		}
+		fields[0] = rewriteRef(fields[0], base, pagePath, local)
+		candidates[i] = strings.Join(fields, " ")
+	}
+	return strings.Join(candidates, ", ")
+}
+
+// rewriteRef maps one reference to the local copy, or to an absolute URL if
+// there is no local copy. Fragments are kept.
+func rewriteRef(ref string, base *url.URL, fromPath string, local LocalFunc) string {
+	ref = strings.TrimSpace(ref)
+	if skipRef(ref) {
+		return ref
+	}
+	parsed, err := base.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return ref
+	}
+	fragment := parsed.Fragment
+	target := withoutFragment(parsed)
+
+	localPath, ok := local(target)
+	if !ok {
+		return parsed.String()
+	}
+	rel := RelativePath(fromPath, localPath)
+	if fragment != "" {
+		rel += "#" + fragment
+	}
+	return rel
+}
+
+func attrURLs(attr html.Attribute) []string {
+	value := strings.TrimSpace(attr.Val)
+	if value == "" {
+		return nil
+	}
+	if attr.Key != "srcset" {
+		return []string{value}
+	}
+	var refs []string
+	for _, candidate := range strings.Split(value, ",") {
+		if fields := strings.Fields(candidate); len(fields) > 0 {
+			refs = append(refs, fields[0])
+		}
+	}
+	return refs
+}
+
+func isRequisiteLink(t html.Token) bool {
+	for _, attr := range t.Attr {
+		if attr.Key != "rel" {
+			continue
+		}
+		for _, rel := range strings.Fields(strings.ToLower(attr.Val)) {
+			if hasAttr(linkRels, rel) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func skipRef(ref string) bool {
+	ref = strings.TrimSpace(ref)
+	return ref == "" || strings.HasPrefix(ref, "#") || strings.HasPrefix(ref, "data:") ||
+		strings.HasPrefix(ref, "javascript:") || strings.HasPrefix(ref, "mailto:")
+}
+
+func refAndQuote(groups [][]byte) (string, string) {
+	switch {
+	case len(groups) > 1 && groups[1] != nil:
+		return string(groups[1]), "'"
+	case len(groups) > 2 && groups[2] != nil:
+		return string(groups[2]), `"`
+	case len(groups) > 3 && groups[3] != nil:
+		return string(groups[3]), ""
+	}
+	return "", ""
+}
+
+func firstGroup(groups [][]byte) []byte {
+	for _, g := range groups[1:] {
+		if g != nil {
+			return g
+		}
+	}
+	return nil
+}
+
+func withoutFragment(u *url.URL) string {
+	c := *u
+	c.Fragment = ""
+	c.RawFragment = ""
+	return c.String()
+}
+
+func hasAttr(list []string, name string) bool {
+	for _, item := range list {
+		if item == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+package mirror
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestExtractRequisites(t *testing.T) {
+	page := `<html><head>
+<link rel="stylesheet" href="/css/site.css">
+<link rel="alternate" href="/feed.xml">
+<style>body { background: url('img/bg.png'); }</style>
+<script src="app.js"></script>
+</head><body>
+<a href="/other.html">other</a>
+<img src="logo.png" srcset="logo-2x.png 2x, logo-3x.png 3x">
+<div style="background-image: url(/img/hero.jpg)"></div>
+</body></html>`
+
+	found, err := ExtractRequisites("https://example.com/dir/page.html", strings.NewReader(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(found)
+	expected := []string{
+		"https://example.com/css/site.css",
+		"https://example.com/dir/app.js",
+		"https://example.com/dir/img/bg.png",
+		"https://example.com/dir/logo-2x.png",
+		"https://example.com/dir/logo-3x.png",
+		"https://example.com/dir/logo.png",
+		"https://example.com/img/hero.jpg",
+	}
+	if strings.Join(found, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("ExtractRequisites() =\n%s\nwant\n%s", strings.Join(found, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+func TestRewriteHTML(t *testing.T) {
+	local := map[string]string{
+		"https://example.com/":             "out/example.com/index.html",
+		"https://example.com/about":        "out/example.com/about.html",
+		"https://example.com/css/site.css": "out/example.com/css/site.css",
+		"https://example.com/img/logo.png": "out/example.com/img/logo.png",
+		"https://example.com/news/?page=2": "out/example.com/news/index_abc.html",
+	}
+	lookup := func(u string) (string, bool) {
+		p, ok := local[u]
+		return p, ok
+	}
+
+	page := `<link rel="stylesheet" href="/css/site.css"><a href="/about#team">About</a>` +
+		`<a href="/news/?page=2">More</a><a href="/missing">Gone</a><img src="https://example.com/img/logo.png">`
+
+	out, err := RewriteHTML([]byte(page), "https://example.com/", "out/example.com/index.html", lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`href="css/site.css"`,
+		`href="about.html#team"`,
+		`href="news/index_abc.html"`,
+		`href="https://example.com/missing"`,
+		`src="img/logo.png"`,
+	} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("rewritten page does not contain %s:\n%s", want, out)
+		}
+	}
+}
+
+func TestRewriteCSS(t *testing.T) {
+	lookup := func(u string) (string, bool) {
+		if u == "https://example.com/img/bg.png" {
+			return "out/example.com/img/bg.png", true
+		}
+		return "", false
+	}
+
+	css := `body { background: url("../img/bg.png") } @import 'print.css'; .x { background: url(data:image/png;base64,AAAA) }`
+	out := string(RewriteCSS([]byte(css), "https://example.com/css/site.css", "out/example.com/css/site.css", lookup))
+
+	for _, want := range []string{`url("../img/bg.png")`, `@import 'https://example.com/css/print.css'`, `url(data:image/png;base64,AAAA)`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("rewritten CSS does not contain %s:\n%s", want, out)
+		}
+	}
+}
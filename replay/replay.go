@@ -0,0 +1,139 @@
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"boem-web-thing/storage"
+	"boem-web-thing/warc"
+)
+
+// Transport is an http.RoundTripper that answers requests from an earlier
+// crawl instead of the network, so a crawl can be reprocessed offline. URLs
+// that were not captured fail with an error, the same as an unreachable host.
+type Transport struct {
+	records map[string]warc.Location // from WARC files
+	pages   map[string]storage.Pages // from the output directory and database
+	warcDir string
+}
+
+// FromWARC indexes every response record in the *.warc.gz files in dir. When a
+// URL was captured more than once the one in the latest file wins. Redirects
+// are archived as responses of their own, so a URL that was redirected
+// replays through the same hops to the page it led to.
+func FromWARC(dir string) (*Transport, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.warc.gz"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no WARC files found in %s", dir)
+	}
+	sort.Strings(files) // file names start with the date they were written
+
+	t := &Transport{records: make(map[string]warc.Location), warcDir: dir}
+	for _, path := range files {
+		name := filepath.Base(path)
+		err := warc.Scan(path, func(rec *warc.Record) error {
+			if rec.Type() == "response" {
+				t.records[rec.TargetURI()] = warc.Location{Filename: name, Offset: rec.Offset}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("indexing %s: %w", name, err)
+		}
+	}
+	return t, nil
+}
+
+// FromPages answers requests from the files saved in the output directory,
+// using the page records in the database to map URLs to files.
+func FromPages(pages []storage.Pages) *Transport {
+	t := &Transport{pages: make(map[string]storage.Pages)}
+	for _, pg := range pages {
+		t.pages[pg.Url] = pg
+	}
+	return t
+}
+
+// Len is the number of URLs that can be replayed
+func (t *Transport) Len() int {
+	return len(t.records) + len(t.pages)
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := req.URL.String()
+
+	var resp *http.Response
+	var err error
+	if loc, ok := t.records[key]; ok {
+		resp, err = t.fromRecord(req, loc)
+	} else if pg, ok := t.pages[key]; ok {
+		resp, err = t.fromPage(req, pg)
+	} else {
+		return nil, fmt.Errorf("%s is not in the replay archive", key)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Method == http.MethodHead {
+		resp.Body.Close()
+		resp.Body = http.NoBody
+	}
+	return resp, nil
+}
+
+func (t *Transport) fromRecord(req *http.Request, loc warc.Location) (*http.Response, error) {
+	rec, err := warc.ReadRecord(filepath.Join(t.warcDir, loc.Filename), loc.Offset)
+	if err != nil {
+		return nil, err
+	}
+	return http.ReadResponse(bufio.NewReader(bytes.NewReader(rec.Block)), req)
+}
+
+func (t *Transport) fromPage(req *http.Request, pg storage.Pages) (*http.Response, error) {
+	// Read the whole file now, the crawler may write over it while saving
+	var body []byte
+	if pg.File_path != "" {
+		var err error
+		body, err = os.ReadFile(pg.File_path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	size := int64(len(body))
+	if !pg.Body_stored {
+		size = pg.Declared_size
+	}
+
+	header := http.Header{}
+	if pg.Content_type != "" {
+		header.Set("Content-Type", pg.Content_type)
+	}
+	if size >= 0 {
+		header.Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", pg.Status_code, http.StatusText(pg.Status_code)),
+		StatusCode:    pg.Status_code,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: size,
+		Request:       req,
+	}, nil
+}
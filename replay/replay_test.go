@@ -0,0 +1,48 @@
+package replay
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"boem-web-thing/storage"
+)
+
+func TestFromPages(t *testing.T) {
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "index.html")
+	if err := os.WriteFile(filePath, []byte("<a href=\"/next\">next</a>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{Transport: FromPages([]storage.Pages{
+		{Url: "https://example.com/", Status_code: 200, Content_type: "text/html", File_path: filePath, Body_stored: true},
+	})}
+
+	resp, err := client.Get("https://example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != 200 || resp.Header.Get("Content-Type") != "text/html" {
+		t.Errorf("got %d %q; want 200 %q", resp.StatusCode, resp.Header.Get("Content-Type"), "text/html")
+	}
+	if string(body) != "<a href=\"/next\">next</a>" {
+		t.Errorf("body = %q", body)
+	}
+
+	resp, err = client.Head("https://example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.ContentLength != int64(len(body)) {
+		t.Errorf("HEAD ContentLength = %d; want %d", resp.ContentLength, len(body))
+	}
+
+	if _, err := client.Get("https://example.com/missing"); err == nil {
+		t.Error("Get of a URL that was never captured did not fail")
+	}
+}
@@ -0,0 +1,112 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Server serves an earlier crawl over HTTP on 127.0.0.1, so tools that load
+// pages in a browser get root-relative assets, fonts and scripts the way the
+// live site served them. Each origin gets a port of its own, as a
+// root-relative URL only finds the right site's files when the site is at
+// the root.
+type Server struct {
+	transport http.RoundTripper
+	bases     map[string]string // origin, like https://example.com, to its local base URL
+	servers   []*http.Server
+}
+
+// Serve starts serving the origins of pageURLs from t, until Close
+func Serve(t http.RoundTripper, pageURLs []string) (*Server, error) {
+	s := &Server{transport: t, bases: make(map[string]string)}
+	for _, pageURL := range pageURLs {
+		origin, ok := originOf(pageURL)
+		if !ok {
+			continue
+		}
+		if _, ok := s.bases[origin]; ok {
+			continue
+		}
+
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("serving %s: %w", origin, err)
+		}
+		srv := &http.Server{Handler: s.handler(origin)}
+		s.servers = append(s.servers, srv)
+		s.bases[origin] = "http://" + ln.Addr().String()
+		go srv.Serve(ln)
+	}
+	return s, nil
+}
+
+// URL returns the local URL pageURL is served at, or false when its origin
+// isn't served
+func (s *Server) URL(pageURL string) (string, bool) {
+	origin, ok := originOf(pageURL)
+	if !ok {
+		return "", false
+	}
+	base, ok := s.bases[origin]
+	if !ok {
+		return "", false
+	}
+	return base + strings.TrimPrefix(pageURL, origin), true
+}
+
+// Close stops serving
+func (s *Server) Close() error {
+	var errs []error
+	for _, srv := range s.servers {
+		errs = append(errs, srv.Shutdown(context.Background()))
+	}
+	return errors.Join(errs...)
+}
+
+// handler answers requests for one origin from the transport, the same way
+// the crawl saw them. URLs that weren't captured are not found.
+func (s *Server) handler(origin string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, err := http.NewRequestWithContext(r.Context(), r.Method, origin+r.URL.RequestURI(), nil)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp, err := s.transport.RoundTrip(req)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer resp.Body.Close()
+
+		// The length is left to net/http, as pages saved without their body
+		// declare the size they had on the live site
+		for key, values := range resp.Header {
+			if key != "Content-Length" {
+				w.Header()[key] = values
+			}
+		}
+		status := resp.StatusCode
+		if status < 100 {
+			status = http.StatusOK
+		}
+		w.WriteHeader(status)
+		io.Copy(w, resp.Body)
+	})
+}
+
+// originOf returns the scheme and host of an absolute http(s) URL
+func originOf(rawURL string) (string, bool) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Host == "" || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return "", false
+	}
+	return parsed.Scheme + "://" + parsed.Host, true
+}
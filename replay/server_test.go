@@ -0,0 +1,67 @@
+package replay
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"boem-web-thing/storage"
+)
+
+func TestServe(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{"index.html": `<link href="/style.css">`, "style.css": "body{}", "other.html": "other"}
+	for name, body := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pages := []storage.Pages{
+		{Url: "https://example.com/news/?page=2", Status_code: 200, Content_type: "text/html", File_path: filepath.Join(dir, "index.html"), Body_stored: true},
+		{Url: "https://example.com/style.css", Status_code: 200, Content_type: "text/css", File_path: filepath.Join(dir, "style.css"), Body_stored: true},
+		{Url: "https://other.example.com/", Status_code: 200, Content_type: "text/html", File_path: filepath.Join(dir, "other.html"), Body_stored: true},
+	}
+	s, err := Serve(FromPages(pages), []string{pages[0].Url, pages[2].Url, "mailto:someone@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	get := func(rawURL string) (*http.Response, string) {
+		t.Helper()
+		resp, err := http.Get(rawURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp, string(body)
+	}
+
+	pageURL, ok := s.URL(pages[0].Url)
+	if !ok {
+		t.Fatal("URL() of a served page = false")
+	}
+	if resp, body := get(pageURL); resp.StatusCode != 200 || body != files["index.html"] {
+		t.Errorf("GET %s = %d %q", pageURL, resp.StatusCode, body)
+	}
+
+	// Root-relative URLs resolve against the page's own site
+	base, _ := s.URL("https://example.com/")
+	if resp, body := get(base + "style.css"); resp.Header.Get("Content-Type") != "text/css" || body != "body{}" {
+		t.Errorf("GET /style.css = %q %q", resp.Header.Get("Content-Type"), body)
+	}
+	if resp, _ := get(base + "missing.js"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET of a URL that was never captured = %d", resp.StatusCode)
+	}
+
+	otherURL, _ := s.URL(pages[2].Url)
+	if _, body := get(otherURL); body != "other" {
+		t.Errorf("other site = %q", body)
+	}
+	if _, ok := s.URL("https://unserved.example.com/"); ok {
+		t.Error("URL() of a site that isn't served = true")
+	}
+}
@@ -0,0 +1,134 @@
+package scanner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"boem-web-thing/config"
+	"boem-web-thing/storage"
+)
+
+func init() {
+	Register("axe", func(cfg *config.Config) (Engine, error) {
+		return NewAxe(cfg.AxePath, cfg.NodePath)
+	})
+}
+
+// Axe runs the axe-core command line tool, npm's @axe-core/cli
+type Axe struct {
+	tool
+}
+
+// NewAxe finds axe and checks that it runs. axePath is the axe program to
+// use; when empty axe is looked for in ./node_modules/.bin, nodeDir and PATH.
+// nodeDir is the directory node is in, as for NewPa11y.
+func NewAxe(axePath string, nodeDir string) (*Axe, error) {
+	a := &Axe{tool: newTool("axe", nodeDir)}
+	if err := a.checkNode(nodeDir); err != nil {
+		return nil, err
+	}
+
+	if axePath != "" {
+		found, err := exec.LookPath(axePath)
+		if err != nil {
+			return nil, fmt.Errorf("axe_path %q: %w", axePath, err)
+		}
+		a.command = []string{found}
+	} else if found, err := find("axe", nodeDir); err == nil {
+		a.command = []string{found}
+	} else {
+		return nil, fmt.Errorf("axe not found, run npm install @axe-core/cli here or set axe_path")
+	}
+	var err error
+	if a.version, err = a.run(preflightTimeout, append(a.command, "--version")...); err != nil {
+		return nil, fmt.Errorf("axe (%s) doesn't run: %w", strings.Join(a.command, " "), err)
+	}
+	return a, nil
+}
+
+// settings is axe's version, which changes what it finds
+func (a *Axe) settings() string {
+	return a.version
+}
+
+// ScanPage runs axe on a stored page. axe only takes URLs, so a saved file
+// is passed as a file:// URL.
+func (a *Axe) ScanPage(ctx context.Context, pg storage.Pages, location string) (Result, error) {
+	if !strings.HasPrefix(location, "http://") && !strings.HasPrefix(location, "https://") {
+		abs, err := filepath.Abs(location)
+		if err != nil {
+			return Result{Status: storage.ScanFailed}, err
+		}
+		fileURL := url.URL{Scheme: "file", Path: filepath.ToSlash(abs)}
+		location = fileURL.String()
+	}
+
+	out, err := a.exec(ctx, location, "--stdout")
+	if err != nil {
+		return failed(err), fmt.Errorf("axe on %s: %w", pg.File_path, err)
+	}
+	if out.exitCode != 0 {
+		return Result{Status: failureStatus(out.stderr)}, fmt.Errorf("axe exited with %d: %s", out.exitCode, describeFailure(out.stderr))
+	}
+	issues, err := parseAxe(out.stdout)
+	if err != nil {
+		return Result{Status: storage.ScanFailed}, err
+	}
+	return issuesResult(issues), nil
+}
+
+// axeResult is the part of axe's report for one page that is read
+type axeResult struct {
+	Violations []axeRule `json:"violations"`
+	Incomplete []axeRule `json:"incomplete"`
+}
+
+type axeRule struct {
+	ID      string `json:"id"`
+	Help    string `json:"help"`
+	HelpURL string `json:"helpUrl"`
+	Nodes   []struct {
+		HTML   string   `json:"html"`
+		Target []string `json:"target"`
+	} `json:"nodes"`
+}
+
+// parseAxe reads the issues out of axe's --stdout report. Like pa11y's axe
+// runner, violations are errors and the checks axe couldn't finish are
+// warnings for someone to look at, one issue per element.
+func parseAxe(output string) ([]storage.Issues, error) {
+	start := strings.Index(output, "[")
+	if start < 0 {
+		return nil, fmt.Errorf("no json report in axe output")
+	}
+	var results []axeResult
+	if err := json.NewDecoder(strings.NewReader(output[start:])).Decode(&results); err != nil {
+		return nil, fmt.Errorf("reading axe report: %w", err)
+	}
+
+	issues := make([]storage.Issues, 0)
+	add := func(rules []axeRule, typ string) {
+		for _, rule := range rules {
+			for _, node := range rule.Nodes {
+				issues = append(issues, storage.Issues{
+					Code:     rule.ID,
+					Type:     typ,
+					Message:  fmt.Sprintf("%s (%s)", rule.Help, rule.HelpURL),
+					Selector: strings.Join(node.Target, ", "),
+					Context:  node.HTML,
+					Runner:   "axe",
+				})
+			}
+		}
+	}
+	for _, result := range results {
+		add(result.Violations, "error")
+		add(result.Incomplete, "warning")
+	}
+	return issues, nil
+}
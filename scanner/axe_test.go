@@ -0,0 +1,29 @@
+package scanner
+
+import "testing"
+
+func TestParseAxe(t *testing.T) {
+	output := `Running axe-core 4.10.0 in chrome-headless
+[{"url":"file:///site/index.html","violations":[{"id":"image-alt","impact":"critical","help":"Images must have alternative text","helpUrl":"https://dequeuniversity.com/rules/axe/4.10/image-alt","nodes":[{"html":"<img src=\"a.png\">","target":["main","img"]}]}],"incomplete":[{"id":"color-contrast","help":"Elements must meet minimum color contrast ratio thresholds","helpUrl":"u","nodes":[{"html":"<p>","target":["p"]},{"html":"<span>","target":["span"]}]}]}]
+`
+	issues, err := parseAxe(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(issues) != 3 {
+		t.Fatalf("parseAxe() = %d issues, want one per element", len(issues))
+	}
+	first := issues[0]
+	if first.Code != "image-alt" || first.Type != "error" || first.Selector != "main, img" ||
+		first.Context != `<img src="a.png">` || first.Runner != "axe" ||
+		first.Message != "Images must have alternative text (https://dequeuniversity.com/rules/axe/4.10/image-alt)" {
+		t.Errorf("violation = %+v", first)
+	}
+	if issues[2].Type != "warning" || issues[2].Code != "color-contrast" {
+		t.Errorf("incomplete check = %+v, want a warning", issues[2])
+	}
+
+	if _, err := parseAxe("Error: chromedriver not found"); err == nil {
+		t.Error("parseAxe() without a report should fail")
+	}
+}
@@ -0,0 +1,85 @@
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"boem-web-thing/config"
+	"boem-web-thing/storage"
+)
+
+// Engine is one way of checking a stored page, like pa11y or the built in
+// Go checks. location is where to load the page from: the URL the scan's
+// local server serves it at, or its saved file when scanning files. As with
+// Pa11y.Scan, finding issues is not an error: the error is only set when the
+// scan failed or timed out, which Status says.
+type Engine interface {
+	ScanPage(ctx context.Context, pg storage.Pages, location string) (Result, error)
+}
+
+// configured is implemented by engines whose findings depend on more than
+// which engine they are, like the tool's version or its config file.
+// Pages are scanned again when their settings change.
+type configured interface {
+	settings() string
+}
+
+// Factory makes an engine from the config, checking that it can run
+type Factory func(cfg *config.Config) (Engine, error)
+
+// engines are the registered factories by the name the scanners config uses
+var engines = map[string]Factory{}
+
+// Register makes an engine available to the scanners config under name. It
+// is called from init, so registering a name twice panics.
+func Register(name string, factory Factory) {
+	if _, ok := engines[name]; ok {
+		panic("scanner: engine " + name + " registered twice")
+	}
+	engines[name] = factory
+}
+
+// Engines lists the registered engine names in order
+func Engines() []string {
+	names := make([]string, 0, len(engines))
+	for name := range engines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// namedEngine is an engine made for a scan and the name it was configured by
+type namedEngine struct {
+	name string
+	Engine
+}
+
+// newEngines makes the engines in the scanners config, in the order they
+// are listed
+func newEngines(cfg *config.Config) ([]namedEngine, error) {
+	var made []namedEngine
+	for _, name := range cfg.Scanners {
+		factory, ok := engines[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown scanner %q, use one of %s", name, strings.Join(Engines(), ", "))
+		}
+		engine, err := factory(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("scanner %s: %w", name, err)
+		}
+		made = append(made, namedEngine{name, engine})
+	}
+	if len(made) == 0 {
+		return nil, fmt.Errorf("no scanners configured, use one or more of %s", strings.Join(Engines(), ", "))
+	}
+	return made, nil
+}
+
+// savedFile is where a stored page was saved, relative to the working
+// directory, e.g. "./_output/doiboem.lndo.site/crawltest/index.html"
+func savedFile(pg storage.Pages) string {
+	return "./" + pg.File_path
+}
@@ -0,0 +1,27 @@
+package scanner
+
+import (
+	"strings"
+	"testing"
+
+	"boem-web-thing/config"
+)
+
+func TestEngines(t *testing.T) {
+	if got := strings.Join(Engines(), ","); got != "axe,go,pa11y,validator" {
+		t.Errorf("Engines() = %s", got)
+	}
+}
+
+func TestNewEngines(t *testing.T) {
+	made, err := newEngines(&config.Config{Scanners: []string{"go"}})
+	if err != nil || len(made) != 1 || made[0].name != "go" {
+		t.Errorf("newEngines(go) = %+v, %v", made, err)
+	}
+	if _, err := newEngines(&config.Config{Scanners: []string{"go", "lighthouse"}}); err == nil || !strings.Contains(err.Error(), `"lighthouse"`) {
+		t.Errorf("newEngines() with an unknown scanner = %v", err)
+	}
+	if _, err := newEngines(&config.Config{}); err == nil {
+		t.Error("newEngines() with no scanners should fail")
+	}
+}
@@ -0,0 +1,62 @@
+package scanner
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+
+	"boem-web-thing/a11y"
+	"boem-web-thing/config"
+	"boem-web-thing/storage"
+	"boem-web-thing/util"
+)
+
+func init() {
+	Register("go", func(cfg *config.Config) (Engine, error) {
+		return goChecks{}, nil
+	})
+}
+
+// goChecks runs the a11y package's checks on the saved HTML, in process
+type goChecks struct{}
+
+// ScanPage checks the saved file, which has the same markup wherever the
+// page is served from. The file is as the server sent it, so it is read in
+// the page's charset.
+func (goChecks) ScanPage(ctx context.Context, pg storage.Pages, location string) (Result, error) {
+	f, err := os.Open(savedFile(pg))
+	if err != nil {
+		return Result{Status: storage.ScanFailed}, err
+	}
+	defer f.Close()
+
+	issues, err := a11y.Check(util.UTF8Reader(f, pg.Charset))
+	if err != nil {
+		return Result{Status: storage.ScanFailed}, err
+	}
+	return issuesResult(issues), nil
+}
+
+// issuesResult is the result of a scan that found issues, with a report in
+// pa11y's json format so every engine's scan results read the same way
+func issuesResult(issues []storage.Issues) Result {
+	raw := make([]pa11yIssue, 0, len(issues))
+	for _, issue := range issues {
+		raw = append(raw, pa11yIssue{
+			Code:     issue.Code,
+			Type:     issue.Type,
+			Message:  issue.Message,
+			Context:  issue.Context,
+			Selector: issue.Selector,
+			Runner:   issue.Runner,
+		})
+	}
+	// Plain structs of strings always marshal
+	report, _ := json.Marshal(raw)
+
+	status := storage.ScanOK
+	if len(issues) > 0 {
+		status = storage.ScanIssues
+	}
+	return Result{Report: string(report), Issues: issues, Status: status}
+}
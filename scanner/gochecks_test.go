@@ -0,0 +1,38 @@
+package scanner
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"boem-web-thing/storage"
+)
+
+func TestGoChecksCharset(t *testing.T) {
+	t.Chdir(t.TempDir())
+	// Saved as the server sent it, in windows-1252
+	page := "<html lang=\"en\"><head><meta charset=\"windows-1252\"><title>t</title></head>" +
+		"<body><img src=\"caf\xe9.png\" title=\"Caf\xe9 menu\"></body></html>"
+	if err := os.WriteFile("legacy.html", []byte(page), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	pg := storage.Pages{Url: "https://example.com/legacy.html", File_path: "legacy.html", Charset: "windows-1252"}
+	result, err := goChecks{}.ScanPage(context.Background(), pg, savedFile(pg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Issues) == 0 {
+		t.Fatal("no issues found for an image without alt text")
+	}
+	for _, issue := range result.Issues {
+		if strings.ContainsRune(issue.Context, utf8.RuneError) || strings.ContainsRune(issue.Message, utf8.RuneError) {
+			t.Errorf("issue %s was read as UTF-8: %q %q", issue.Code, issue.Message, issue.Context)
+		}
+	}
+	if !strings.Contains(result.Issues[0].Context, "café.png") {
+		t.Errorf("context = %q; want the image's file name transcoded", result.Issues[0].Context)
+	}
+}
@@ -0,0 +1,215 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"boem-web-thing/config"
+	"boem-web-thing/storage"
+)
+
+func init() {
+	Register("pa11y", func(cfg *config.Config) (Engine, error) {
+		return NewPa11y(cfg.Pa11yPath, cfg.NodePath, cfg.Pa11yConfig)
+	})
+}
+
+// Pa11y runs the pa11y command line tool
+type Pa11y struct {
+	tool
+	config string // pa11y config file, none when empty
+}
+
+// NewPa11y finds pa11y and checks that it runs. pa11yPath is the pa11y
+// program to use; when empty pa11y is looked for in ./node_modules/.bin,
+// nodeDir and PATH, and then run through npx without letting it install
+// anything. nodeDir is the directory node is in, which is put first on the
+// PATH pa11y runs with; when empty node must already be on PATH.
+func NewPa11y(pa11yPath string, nodeDir string, configFile string) (*Pa11y, error) {
+	p := &Pa11y{tool: newTool("pa11y", nodeDir), config: configFile}
+	if err := p.checkNode(nodeDir); err != nil {
+		return nil, err
+	}
+
+	if pa11yPath != "" {
+		found, err := exec.LookPath(pa11yPath)
+		if err != nil {
+			return nil, fmt.Errorf("pa11y_path %q: %w", pa11yPath, err)
+		}
+		p.command = []string{found}
+	} else if found, err := find("pa11y", nodeDir); err == nil {
+		p.command = []string{found}
+	} else if npx, err := find("npx", nodeDir); err == nil {
+		p.command = []string{npx, "--no-install", "pa11y"}
+	} else {
+		return nil, fmt.Errorf("pa11y not found, run npm install here or set pa11y_path")
+	}
+	var err error
+	if p.version, err = p.run(preflightTimeout, append(p.command, "--version")...); err != nil {
+		return nil, fmt.Errorf("pa11y (%s) doesn't run, run npm install here or set pa11y_path: %w", strings.Join(p.command, " "), err)
+	}
+
+	if configFile != "" {
+		if _, err := os.Stat(configFile); err != nil {
+			return nil, fmt.Errorf("pa11y config: %w", err)
+		}
+	}
+	return p, nil
+}
+
+// Result is how a scan of a page went
+type Result struct {
+	Report string // the JSON report, saved as the page's scan result
+	Issues []storage.Issues
+	Status string // storage.ScanOK, ScanIssues, ScanFailed or ScanTimedOut
+}
+
+// pa11y's exit codes
+const (
+	pa11yExitOK     = 0 // no issues over the threshold
+	pa11yExitFailed = 1 // pa11y itself failed
+	pa11yExitIssues = 2 // issues over the threshold were found
+)
+
+// Scan runs pa11y on a saved page, given by its file path or URL. Finding
+// issues is not an error, the error is only set when the scan failed or timed
+// out, which Status says. The scan is killed if ctx ends first.
+func (p *Pa11y) Scan(ctx context.Context, location string) (Result, error) {
+	args := []string{location}
+	if p.config != "" {
+		args = append(args, "--config", p.config)
+	}
+	// Issues are read from the json report, whatever reporter the config sets
+	args = append(args, "--reporter", "json")
+	out, err := p.exec(ctx, args...)
+	if err != nil {
+		return failed(err), fmt.Errorf("pa11y on %s: %w", location, err)
+	}
+	return classify(out.exitCode, out.stdout, out.stderr)
+}
+
+// settings are pa11y's version and config, which change what it finds
+func (p *Pa11y) settings() string {
+	config, _ := os.ReadFile(p.config)
+	return p.version + "\n" + string(config)
+}
+
+// ScanPage runs pa11y on a stored page
+func (p *Pa11y) ScanPage(ctx context.Context, pg storage.Pages, location string) (Result, error) {
+	return p.Scan(ctx, location)
+}
+
+// classify works out how a scan went from pa11y's exit code, report and
+// stderr. Exit codes 0 and 2 both come with a report, anything else means
+// pa11y didn't finish.
+func classify(exitCode int, stdout string, stderr string) (Result, error) {
+	if exitCode != pa11yExitOK && exitCode != pa11yExitIssues {
+		return Result{Status: failureStatus(stderr)}, fmt.Errorf("pa11y exited with %d: %s", exitCode, describeFailure(stderr))
+	}
+	report, issues, err := ParsePa11y(stdout)
+	if err != nil {
+		return Result{Status: storage.ScanFailed}, err
+	}
+	status := storage.ScanOK
+	if len(issues) > 0 || exitCode == pa11yExitIssues {
+		status = storage.ScanIssues
+	}
+	return Result{Report: report, Issues: issues, Status: status}, nil
+}
+
+// failureStatus tells pa11y giving up on a page that loads too slowly apart
+// from other failures
+func failureStatus(stderr string) string {
+	if strings.Contains(stderr, "TimeoutError") || strings.Contains(stderr, "timed out") {
+		return storage.ScanTimedOut
+	}
+	return storage.ScanFailed
+}
+
+// knownFailures explain the failures that come up when setting pa11y up
+var knownFailures = []struct {
+	match  string
+	reason string
+}{
+	{"Failed to launch the browser process", "no headless browser could start, install one with npx puppeteer browsers install chrome"},
+	{"Could not find Chrome", "no headless browser installed, install one with npx puppeteer browsers install chrome"},
+	{"Could not find expected browser", "no headless browser installed, install one with npx puppeteer browsers install chrome"},
+	{"ERR_FILE_NOT_FOUND", "the saved page could not be opened"},
+}
+
+// describeFailure turns pa11y's stderr into a short reason for the failure
+func describeFailure(stderr string) string {
+	for _, known := range knownFailures {
+		if strings.Contains(stderr, known.match) {
+			return known.reason
+		}
+	}
+	// The error is usually last, after npm notices, apart from any stack
+	// frames and the Node version Node prints after an uncaught error
+	lines := strings.Split(strings.TrimSpace(stderr), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		line := strings.TrimSpace(lines[i])
+		if line != "" && !strings.HasPrefix(line, "at ") && !strings.HasPrefix(line, "Node.js v") {
+			return line
+		}
+	}
+	return "no error output"
+}
+
+// pa11yIssue is one issue as written by pa11y's json reporter
+type pa11yIssue struct {
+	Code     string `json:"code"`
+	Type     string `json:"type"`
+	Message  string `json:"message"`
+	Context  string `json:"context"`
+	Selector string `json:"selector"`
+	Runner   string `json:"runner"`
+}
+
+// ParsePa11y reads the issues out of pa11y json reporter output. npx and npm
+// can print notices around the report, so the report is the first line that
+// starts a JSON array. It returns the report on its own, to save as the
+// page's scan result, and the issues in it.
+func ParsePa11y(output string) (string, []storage.Issues, error) {
+	rest := ""
+	for offset := 0; offset < len(output); {
+		line, _, _ := strings.Cut(output[offset:], "\n")
+		if strings.HasPrefix(strings.TrimSpace(line), "[") {
+			rest = strings.TrimSpace(output[offset:])
+			break
+		}
+		offset += len(line) + 1
+	}
+	if rest == "" {
+		return "", nil, fmt.Errorf("no json report in pa11y output")
+	}
+
+	dec := json.NewDecoder(strings.NewReader(rest))
+	var raw []pa11yIssue
+	if err := dec.Decode(&raw); err != nil {
+		return "", nil, fmt.Errorf("reading pa11y report: %w", err)
+	}
+	report := rest[:dec.InputOffset()]
+
+	issues := make([]storage.Issues, 0, len(raw))
+	for _, r := range raw {
+		issues = append(issues, storage.Issues{
+			Code:     r.Code,
+			Type:     r.Type,
+			Message:  r.Message,
+			Selector: r.Selector,
+			Context:  r.Context,
+			Runner:   r.Runner,
+		})
+	}
+
+	// The report was decoded, so it compacts without error
+	var compact bytes.Buffer
+	json.Compact(&compact, []byte(report))
+	return compact.String(), issues, nil
+}
@@ -0,0 +1,156 @@
+package scanner
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"boem-web-thing/storage"
+)
+
+func TestParsePa11y(t *testing.T) {
+	output := `npm warn exec The following package was not found and will be installed: pa11y@8.0.0
+[
+  {"code":"WCAG2AA.Principle1.Guideline1_1.1_1_1.H37","type":"error","typeCode":1,
+   "message":"Img element missing an alt attribute.","context":"<img src=\"a.png\">",
+   "selector":"html > body > img","runner":"htmlcs","runnerExtras":{}}
+]
+npm notice New major version of npm available!
+`
+	report, issues, err := ParsePa11y(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(issues) != 1 {
+		t.Fatalf("ParsePa11y() issues = %+v", issues)
+	}
+	got := issues[0]
+	if got.Code != "WCAG2AA.Principle1.Guideline1_1.1_1_1.H37" || got.Type != "error" || got.Selector != "html > body > img" ||
+		got.Context != `<img src="a.png">` || got.Runner != "htmlcs" || got.Message == "" {
+		t.Errorf("ParsePa11y() issue = %+v", got)
+	}
+	if report[0] != '[' || report[len(report)-1] != ']' {
+		t.Errorf("ParsePa11y() report = %q, want only the JSON", report)
+	}
+
+	if _, issues, err := ParsePa11y("[]\n"); err != nil || len(issues) != 0 {
+		t.Errorf("ParsePa11y() of an empty report = %v, %v", issues, err)
+	}
+	if _, _, err := ParsePa11y("Welcome to Pa11y\n > Running Pa11y on URL file:///a.html\n"); err == nil {
+		t.Error("ParsePa11y() of cli reporter output should fail")
+	}
+}
+
+// fakePa11y writes a script that answers --version and otherwise prints its
+// arguments as a pa11y report, exiting 2 like pa11y does when it finds issues
+func fakePa11y(t *testing.T) string {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell script")
+	}
+	if _, err := exec.LookPath("node"); err != nil {
+		t.Skip("needs node on PATH")
+	}
+	path := filepath.Join(t.TempDir(), "pa11y")
+	script := `#!/bin/sh
+if [ "$1" = "--version" ]; then echo 9.0.0; exit 0; fi
+echo "npm warn noise" >&2
+printf '[{"code":"args","type":"error","message":"%s|%s|%s"}]\n' "$1" "$2" "$3"
+exit 2
+`
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPa11yScan(t *testing.T) {
+	bin := fakePa11y(t)
+	configFile := filepath.Join(t.TempDir(), "pa11y config.json")
+	if err := os.WriteFile(configFile, []byte(`{"reporter": "json"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := NewPa11y(bin, "", configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Paths with spaces reach pa11y as single arguments, and exit code 2 is
+	// issues being found rather than a failure
+	result, err := p.Scan(context.Background(), "./_output/my site/index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "./_output/my site/index.html|--config|" + configFile; result.Status != storage.ScanIssues ||
+		len(result.Issues) != 1 || result.Issues[0].Message != want {
+		t.Errorf("Scan() = %+v, want pa11y run with %q", result, want)
+	}
+
+	// Without a config file pa11y is still asked for a json report
+	p, err = NewPa11y(bin, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err = p.Scan(context.Background(), "page.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "page.html|--reporter|json"; len(result.Issues) != 1 || result.Issues[0].Message != want {
+		t.Errorf("Scan() without a config = %+v, want pa11y run with %q", result, want)
+	}
+
+	if _, err := NewPa11y(filepath.Join(t.TempDir(), "missing"), "", ""); err == nil || !strings.Contains(err.Error(), "pa11y_path") {
+		t.Errorf("NewPa11y() with a missing pa11y_path = %v", err)
+	}
+	if _, err := NewPa11y(bin, "", filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("NewPa11y() with a missing config file should fail")
+	}
+}
+
+func TestClassify(t *testing.T) {
+	report := `[{"code":"WCAG2AA.H37","type":"error","message":"m"}]`
+	tests := []struct {
+		name     string
+		exitCode int
+		stdout   string
+		stderr   string
+		status   string
+		failure  string
+	}{
+		{"clean", 0, "[]", "", storage.ScanOK, ""},
+		{"issues", 2, report, "npm warn noise", storage.ScanIssues, ""},
+		{"issues under the threshold", 0, report, "", storage.ScanIssues, ""},
+		{"no browser", 1, "", "Error: Failed to launch the browser process!\n    at onClose (/x.js:1:1)\n\nNode.js v22.18.0",
+			storage.ScanFailed, "npx puppeteer browsers install chrome"},
+		{"page timeout", 1, "", "TimeoutError: Navigation timeout of 30000 ms exceeded\n    at x (/y.js:2:2)", storage.ScanTimedOut, "TimeoutError"},
+		{"crash", 1, "", "Error: something broke\n    at x (/y.js:2:2)\n", storage.ScanFailed, "Error: something broke"},
+		{"killed", -1, "", "", storage.ScanFailed, "no error output"},
+		{"not json", 0, "Welcome to Pa11y", "", storage.ScanFailed, "no json report"},
+	}
+	for _, tt := range tests {
+		result, err := classify(tt.exitCode, tt.stdout, tt.stderr)
+		if result.Status != tt.status {
+			t.Errorf("%s: status = %q, want %q", tt.name, result.Status, tt.status)
+		}
+		if tt.failure == "" && err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		}
+		if tt.failure != "" && (err == nil || !strings.Contains(err.Error(), tt.failure)) {
+			t.Errorf("%s: error = %v, want it to mention %q", tt.name, err, tt.failure)
+		}
+	}
+}
+
+func TestPrependPath(t *testing.T) {
+	sep := string(os.PathListSeparator)
+	env := prependPath([]string{"HOME=/root", "PATH=/usr/bin"}, "/opt/node/bin")
+	if env[1] != "PATH=/opt/node/bin"+sep+"/usr/bin" {
+		t.Errorf("prependPath() = %v", env)
+	}
+	if env := prependPath([]string{"HOME=/root"}, "/opt/node/bin"); env[1] != "PATH=/opt/node/bin" {
+		t.Errorf("prependPath() without a PATH = %v", env)
+	}
+}
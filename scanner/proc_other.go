@@ -0,0 +1,9 @@
+//go:build !unix
+
+package scanner
+
+import "os/exec"
+
+// killGroup leaves the default of killing just the command where process
+// groups aren't available
+func killGroup(cmd *exec.Cmd) {}
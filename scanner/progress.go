@@ -0,0 +1,59 @@
+package scanner
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"boem-web-thing/storage"
+)
+
+// progress counts finished scans for the workers and prints a line for each,
+// with an estimate of the time left
+type progress struct {
+	mu      sync.Mutex
+	out     io.Writer
+	total   int
+	scanned int
+	failed  int
+	issues  int
+	started time.Time
+}
+
+func newProgress(out io.Writer, total int) *progress {
+	return &progress{out: out, total: total, started: time.Now()}
+}
+
+// done records the scan of one page
+func (p *progress) done(pageURL string, status string, issues int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch status {
+	case storage.ScanOK, storage.ScanIssues:
+		p.scanned++
+		p.issues += issues
+		status = fmt.Sprintf("%d issues", issues)
+	default:
+		p.failed++
+		status = strings.ReplaceAll(status, "_", " ")
+	}
+
+	finished := p.scanned + p.failed
+	left := ""
+	if finished < p.total {
+		perPage := time.Since(p.started) / time.Duration(finished)
+		left = fmt.Sprintf(", about %s left", (perPage * time.Duration(p.total-finished)).Round(time.Second))
+	}
+	fmt.Fprintf(p.out, "[%d/%d %d%%%s] %s: %s\n", finished, p.total, finished*100/p.total, left, pageURL, status)
+}
+
+// totals returns how many pages were scanned, how many failed and how many
+// issues were found
+func (p *progress) totals() (int, int, int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.scanned, p.failed, p.issues
+}
@@ -0,0 +1,35 @@
+package scanner
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"boem-web-thing/storage"
+)
+
+func TestProgress(t *testing.T) {
+	var out bytes.Buffer
+	p := newProgress(&out, 4)
+	p.done("https://example.com/a", storage.ScanIssues, 3)
+	p.done("https://example.com/b", storage.ScanTimedOut, 0)
+	p.done("https://example.com/c", storage.ScanIssues, 2)
+	p.done("https://example.com/d", storage.ScanOK, 0)
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("progress printed %q", out.String())
+	}
+	if !strings.HasPrefix(lines[0], "[1/4 25%, about ") || !strings.HasSuffix(lines[0], "https://example.com/a: 3 issues") {
+		t.Errorf("first line = %q", lines[0])
+	}
+	if !strings.HasSuffix(lines[1], "https://example.com/b: timed out") {
+		t.Errorf("failed line = %q", lines[1])
+	}
+	if lines[3] != "[4/4 100%] https://example.com/d: 0 issues" {
+		t.Errorf("last line = %q", lines[3])
+	}
+	if scanned, failed, issues := p.totals(); scanned != 3 || failed != 1 || issues != 5 {
+		t.Errorf("totals() = %d, %d, %d", scanned, failed, issues)
+	}
+}
@@ -3,23 +3,28 @@ package scanner
 import (
 	"boem-web-thing/config"
 	"boem-web-thing/logger"
+	"boem-web-thing/replay"
 	"boem-web-thing/storage"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"os"
-	"os/exec"
-	"path/filepath"
-	"strings"
 	"sync"
+	"time"
 )
 
 type Scanner struct {
-	cfg   *config.Config
-	log   *logger.Logger
-	store *storage.Storage
-	wg    sync.WaitGroup
+	cfg        *config.Config
+	log        *logger.Logger
+	store      storage.Store
+	engines    []namedEngine
+	configHash string         // of the engines and their settings, see configHash
+	server     *replay.Server // serves the pages being scanned, nil when scanning files
+	wg         sync.WaitGroup
 }
 
-func New(cfg *config.Config, log *logger.Logger, store *storage.Storage) *Scanner {
+func New(cfg *config.Config, log *logger.Logger, store storage.Store) *Scanner {
 	return &Scanner{
 		cfg:   cfg,
 		log:   log,
@@ -27,89 +32,287 @@ func New(cfg *config.Config, log *logger.Logger, store *storage.Storage) *Scanne
 	}
 }
 
-func (s *Scanner) ScanSite() {
+// scannable are the content types of the pages engines check. Assets saved
+// for mirroring are only served, not scanned.
+var scannable = []string{"text/html", "application/xhtml+xml"}
 
-	pages, err := s.store.GetPagesByAllowedHosts(s.cfg.AllowedHosts)
-	if err != nil {
-		s.log.Error(err)
-	}
+// ScanSite scans the saved pages of every site in the config and records
+// the issues found as one scan run. Pages are handed to ScanWorkers workers,
+// which s.wg waits for. Each page is checked by every engine in the scanners
+// config, and it fails before scanning anything if one of them can't run.
+// Unless ScanFiles is set, pages are scanned through a local server so their
+// root-relative assets load, and unless ScanUnchanged is set, pages that
+// haven't changed since they were last scanned the same way are skipped.
+func (s *Scanner) ScanSite() error {
+	return s.scan(storage.PageQuery{Hosts: s.cfg.AllHosts(), ContentTypes: scannable})
+}
 
-	//For each URL, match it to the path on the disk
-	for _, pg := range pages {
+// RetryFailed scans only the pages whose last scan failed or timed out, as a
+// new scan run, leaving pages that were scanned alone
+func (s *Scanner) RetryFailed() error {
+	return s.scan(storage.PageQuery{
+		Hosts:        s.cfg.AllHosts(),
+		ContentTypes: scannable,
+		ScanStatuses: []string{storage.ScanFailed, storage.ScanTimedOut},
+	})
+}
 
-		//Pull the URLs from storge, add "./" so we are looking relatively
-		filePath := "./" + pg.File_path //e.g. "./_output/doiboem.lndo.site/crawltest/index.html"
+// scan scans the pages that match the query as one scan run. Pages recorded
+// without their body, like skipped or oversized ones, have nothing to scan
+// and are left out.
+func (s *Scanner) scan(q storage.PageQuery) error {
 
-		pa11yConfigFile := "-c pa11y-config.json"
+	engines, err := newEngines(s.cfg)
+	if err != nil {
+		return err
+	}
+	s.engines = engines
+	s.configHash = configHash(engines, s.cfg.ScanFiles)
 
-		//if the file exists on the disk, scan it
-		_, err := os.Stat(filePath)
-		if err != nil {
-			s.log.Error("Cannot find filePath", filePath)
+	pages, err := s.store.QueryPages(q)
+	if err != nil {
+		return fmt.Errorf("reading pages to scan: %w", err)
+	}
+	pages = withBodies(pages)
+	var unchanged []storage.PageScans
+	if !s.cfg.ScanUnchanged {
+		if pages, unchanged, err = s.changed(pages); err != nil {
+			return err
 		}
+		if len(unchanged) > 0 {
+			s.log.Info(fmt.Sprintf("Skipping %d pages that haven't changed since their last scan, use --force to scan them", len(unchanged)))
+		}
+	}
 
-		//Store the result of the scan next to the page
-		scanResult, err := ScanWithPa11y(filePath, pa11yConfigFile)
+	s.server = nil
+	if !s.cfg.ScanFiles {
+		server, err := s.serve(pages)
 		if err != nil {
-			s.log.Error()
+			return err
 		}
-		s.store.SaveScan(pg.File_path, scanResult)
+		defer server.Close()
+		s.server = server
+	}
+
+	scanRunID, err := s.store.StartScanRun()
+	if err != nil {
+		return fmt.Errorf("starting scan run: %w", err)
+	}
+	carried := 0
+	for _, last := range unchanged {
+		carried += s.carryOver(scanRunID, last)
 	}
+	progress := newProgress(os.Stdout, len(pages))
 
+	jobs := make(chan storage.Pages)
+	for i := 0; i < s.cfg.ScanWorkers; i++ {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			for pg := range jobs {
+				ps := s.scanPage(scanRunID, pg)
+				if ps.Error != "" {
+					s.log.Error("Error scanning", pg.Url, ":", ps.Error)
+				}
+				progress.done(pg.Url, ps.Status, ps.Issues)
+			}
+		}()
+	}
+	for _, pg := range pages {
+		jobs <- pg
+	}
+	close(jobs)
+	s.wg.Wait()
+
+	if err := s.store.Flush(); err != nil {
+		s.log.Error("Error saving scan results:", err)
+	}
+	scanned, failed, found := progress.totals()
+	if err := s.store.FinishScanRun(scanRunID, scanned, found+carried); err != nil {
+		s.log.Error("Error finishing scan run:", err)
+	}
+	s.log.Info(fmt.Sprintf("Scan run %d found %d issues on %d pages, %d failed, and kept %d issues on %d unchanged pages",
+		scanRunID, found, scanned, failed, carried, len(unchanged)))
+	return nil
 }
 
-// Run the filepath through the pa11y scanner and output the result as a JSON string
-func ScanWithPa11y(filePath string, pa11yConfigFile string) (string, error) {
+// scanPage scans one saved page with each configured engine. If any of them
+// fails the page's scan failed, otherwise the combined result is stored next
+// to the page and its issues on their own. How the scan went is recorded
+// whatever happened.
+func (s *Scanner) scanPage(scanRunID int64, pg storage.Pages) storage.PageScans {
+	ps := storage.PageScans{Scan_run_id: scanRunID, Page_url: pg.Url, Content_hash: pg.Content_hash, Config_hash: s.configHash}
 
-	// Step 1: Check if npx is available
-	npxPath, err := exec.LookPath("npx")
-	if err != nil {
+	//if the file exists on the disk, scan it
+	if _, err := os.Stat(savedFile(pg)); err != nil {
+		ps.Status = storage.ScanFailed
+		ps.Error = fmt.Sprintf("cannot find %s: %v", savedFile(pg), err)
+		s.savePageScan(ps)
+		return ps
+	}
 
-		// Try common fallback paths for nvm in WSL2
-		homeDir, _ := os.UserHomeDir()
-		candidates := []string{
-			filepath.Join(homeDir, ".nvm/versions/node/v22.18.0/bin/npx"), // adjust version as needed
-			filepath.Join(homeDir, ".nvm/versions/node/current/bin/npx"),
-			filepath.Join(homeDir, ".nvm/versions/node/bin/npx"),
+	var results []Result
+	for _, engine := range s.engines {
+		result, err := s.scanWith(engine, pg, s.location(pg))
+		if err != nil {
+			ps.Status = result.Status
+			ps.Error = fmt.Sprintf("%s: %v", engine.name, err)
+			s.savePageScan(ps)
+			return ps
 		}
+		results = append(results, result)
+	}
+	result := merge(results)
 
-		for _, path := range candidates {
-			if _, statErr := os.Stat(path); statErr == nil {
-				npxPath = path
-				break
-			}
-		}
+	ps.Status = result.Status
+	s.store.SaveScan(pg.File_path, result.Report)
+	if err := s.store.SaveIssues(scanRunID, pg.Url, result.Issues); err != nil {
+		s.log.Error("Error saving issues for", pg.Url, ":", err)
+	}
+	ps.Issues = len(result.Issues)
+	s.savePageScan(ps)
+	return ps
+}
 
-		if npxPath == "" {
-			return "", fmt.Errorf("npx not found in PATH or fallback locations. Make sure Node.js and npx are installed via nvm")
+// scanWith scans a page with one engine, retrying up to ScanRetries times
+func (s *Scanner) scanWith(engine namedEngine, pg storage.Pages, location string) (Result, error) {
+	var result Result
+	var err error
+	for attempt := 0; attempt <= s.cfg.ScanRetries; attempt++ {
+		if attempt > 0 {
+			s.log.Info(fmt.Sprintf("Retrying %s scan of %s (%s): %v", engine.name, pg.Url, result.Status, err))
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(s.cfg.ScanTimeoutSeconds)*time.Second)
+		result, err = engine.ScanPage(ctx, pg, location)
+		cancel()
+		if err == nil {
+			break
 		}
+	}
+	return result, err
+}
 
+// withBodies leaves out the pages that were recorded without saving a body
+func withBodies(pages []storage.Pages) []storage.Pages {
+	kept := pages[:0]
+	for _, pg := range pages {
+		if pg.File_path != "" && pg.Body_stored {
+			kept = append(kept, pg)
+		}
 	}
+	return kept
+}
 
-	if pa11yConfigFile == "" {
-		pa11yConfigFile = "-c pa11y-config.json"
+// changed leaves out the pages whose last scan succeeded on the same content
+// with the same config hash, returning those pages' last scans. Pages
+// without a content hash are always scanned.
+func (s *Scanner) changed(pages []storage.Pages) ([]storage.Pages, []storage.PageScans, error) {
+	lastScans, err := s.store.GetLastPageScans()
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading last scans: %w", err)
+	}
+	last := make(map[string]storage.PageScans, len(lastScans))
+	for _, ps := range lastScans {
+		last[ps.Page_url] = ps
 	}
 
-	// Step 2: Build the command
-	fmt.Println("Running pa11y on:", filePath)
-	// Use bash -c to allow shell features like sourcing nvm if needed
-	command := fmt.Sprintf("npx pa11y %s %s", filePath, pa11yConfigFile)
+	var changed []storage.Pages
+	var unchanged []storage.PageScans
+	for _, pg := range pages {
+		ps, ok := last[pg.Url]
+		succeeded := ps.Status == storage.ScanOK || ps.Status == storage.ScanIssues || ps.Status == storage.ScanUnchanged
+		if ok && pg.Content_hash != "" && succeeded && ps.Content_hash == pg.Content_hash && ps.Config_hash == s.configHash {
+			unchanged = append(unchanged, ps)
+		} else {
+			changed = append(changed, pg)
+		}
+	}
+	return changed, unchanged, nil
+}
 
-	// Optional: Source nvm if needed (you can make this conditional or configurable)
-	shellCommand := fmt.Sprintf("source ~/.nvm/nvm.sh && %s", command)
+// carryOver records an unchanged page in a new scan run with the issues of
+// its last scan, so every run has the findings for the whole site. It returns
+// how many issues were carried over.
+func (s *Scanner) carryOver(scanRunID int64, last storage.PageScans) int {
+	issues, err := s.store.QueryIssues(storage.IssueQuery{ScanRunID: last.Scan_run_id, PageURLs: []string{last.Page_url}})
+	if err != nil {
+		s.log.Error("Error reading last issues for", last.Page_url, ":", err)
+		return 0
+	}
+	if err := s.store.SaveIssues(scanRunID, last.Page_url, issues); err != nil {
+		s.log.Error("Error saving issues for", last.Page_url, ":", err)
+		return 0
+	}
+	s.savePageScan(storage.PageScans{
+		Scan_run_id:  scanRunID,
+		Page_url:     last.Page_url,
+		Status:       storage.ScanUnchanged,
+		Issues:       len(issues),
+		Content_hash: last.Content_hash,
+		Config_hash:  last.Config_hash,
+	})
+	return len(issues)
+}
 
-	cmd := exec.Command("bash", "-c", shellCommand)
+// configHash identifies how pages are scanned: by which engines, with what
+// settings, and whether from files or the local server
+func configHash(engines []namedEngine, scanFiles bool) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "files=%t\n", scanFiles)
+	for _, engine := range engines {
+		fmt.Fprintf(h, "%s\n", engine.name)
+		if c, ok := engine.Engine.(configured); ok {
+			fmt.Fprintf(h, "%s\n", c.settings())
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
 
-	// Step 3: Inherit environment
-	cmd.Env = append(os.Environ(), "PATH="+os.Getenv("PATH"))
+// serve starts a local server for the sites of the pages being scanned. It
+// serves every stored page of the configured sites, not just those being
+// scanned, as their assets are needed too.
+func (s *Scanner) serve(pages []storage.Pages) (*replay.Server, error) {
+	all, err := s.store.QueryPages(storage.PageQuery{Hosts: s.cfg.AllHosts()})
+	if err != nil {
+		return nil, fmt.Errorf("reading pages to serve: %w", err)
+	}
+	pageURLs := make([]string, 0, len(pages))
+	for _, pg := range pages {
+		pageURLs = append(pageURLs, pg.Url)
+	}
+	server, err := replay.Serve(replay.FromPages(all), pageURLs)
+	if err != nil {
+		return nil, fmt.Errorf("starting the local server: %w", err)
+	}
+	return server, nil
+}
 
-	// Step 4: Capture combined output
-	out, err := cmd.CombinedOutput()
-	output := strings.TrimSpace(string(out))
+// location is where engines load a page from, its URL on the local server
+// or else its saved file
+func (s *Scanner) location(pg storage.Pages) string {
+	if s.server != nil {
+		if local, ok := s.server.URL(pg.Url); ok {
+			return local
+		}
+	}
+	return savedFile(pg)
+}
 
-	if err != nil {
-		return output, fmt.Errorf("pa11y dectected errors: %w", err)
+// merge combines the results of every engine that checked a page. One
+// result keeps its own report, more get a report of all their issues.
+func merge(results []Result) Result {
+	if len(results) == 1 {
+		return results[0]
+	}
+	issues := make([]storage.Issues, 0)
+	for _, r := range results {
+		issues = append(issues, r.Issues...)
 	}
+	return issuesResult(issues)
+}
 
-	return output, nil
+func (s *Scanner) savePageScan(ps storage.PageScans) {
+	if err := s.store.SavePageScan(ps); err != nil {
+		s.log.Error("Error saving scan of", ps.Page_url, ":", err)
+	}
 }
@@ -0,0 +1,286 @@
+package scanner
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"boem-web-thing/config"
+	"boem-web-thing/export"
+	"boem-web-thing/logger"
+	"boem-web-thing/storage"
+)
+
+// flakyPa11y writes a pa11y that fails the first time it scans a page and
+// always fails on pages named broken.html. Attempts are noted in the working
+// directory.
+func flakyPa11y(t *testing.T) string {
+	bin := fakePa11y(t)
+	script := `#!/bin/sh
+if [ "$1" = "--version" ]; then echo 9.0.0; exit 0; fi
+case "$1" in *broken.html) echo "Error: Failed to launch the browser process" >&2; exit 1;; esac
+tried="$(basename "$1").tried"
+if [ ! -f "$tried" ]; then touch "$tried"; echo "Error: Protocol error" >&2; exit 1; fi
+echo '[{"code":"WCAG2AA.H37","type":"error","message":"m"}]'
+exit 2
+`
+	if err := os.WriteFile(bin, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return bin
+}
+
+// newTestScanner makes a scanner over a memory store holding pages, saved
+// file name to body, in a temporary working directory. Each page is stored as
+// https://example.com/<name>, with its content type from its extension. Sites,
+// ScanWorkers and ScanTimeoutSeconds get test defaults when cfg leaves them
+// empty.
+func newTestScanner(t *testing.T, cfg *config.Config, pages map[string]string) (*Scanner, *storage.MemoryStore) {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	store := storage.NewMemory()
+	for name, body := range pages {
+		if err := os.WriteFile(name, []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+		pg := storage.Pages{
+			Url:          "https://example.com/" + name,
+			Status_code:  200,
+			Content_type: mime.TypeByExtension(filepath.Ext(name)),
+			File_path:    name,
+			Body_stored:  true,
+			Content_hash: fmt.Sprintf("%x", sha256.Sum256([]byte(body))),
+		}
+		if err := store.SavePage(pg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	log, err := logger.New(filepath.Join(t.TempDir(), "logs"), "info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { log.Close() })
+	if len(cfg.Sites) == 0 {
+		cfg.Sites = []config.Site{{AllowedHosts: []string{"example.com"}}}
+	}
+	if cfg.ScanWorkers == 0 {
+		cfg.ScanWorkers = 1
+	}
+	if cfg.ScanTimeoutSeconds == 0 {
+		cfg.ScanTimeoutSeconds = 10
+	}
+	return New(cfg, log, store), store
+}
+
+func TestScanSiteRetries(t *testing.T) {
+	s, store := newTestScanner(t, &config.Config{
+		Scanners:    []string{"pa11y"},
+		ScanWorkers: 2,
+		ScanRetries: 1,
+		Pa11yPath:   flakyPa11y(t),
+	}, map[string]string{"ok.html": "<p>hi</p>", "broken.html": "<p>hi</p>"})
+
+	if err := s.ScanSite(); err != nil {
+		t.Fatal(err)
+	}
+	scans, _ := store.GetPageScans(1)
+	if len(scans) != 2 || scans[0].Status != storage.ScanFailed || scans[0].Error == "" ||
+		scans[1].Status != storage.ScanIssues || scans[1].Issues != 1 || scans[1].Content_hash == "" {
+		t.Fatalf("first scan = %+v, want broken.html failed and ok.html scanned on its retry", scans)
+	}
+
+	// Retrying only scans the page that failed
+	if err := s.RetryFailed(); err != nil {
+		t.Fatal(err)
+	}
+	scans, _ = store.GetPageScans(2)
+	if len(scans) != 1 || scans[0].Page_url != "https://example.com/broken.html" {
+		t.Errorf("retry scanned %+v, want only broken.html", scans)
+	}
+	runs, _ := store.GetScanRuns()
+	if runs[1].Pages_scanned != 1 || runs[1].Issues_found != 1 {
+		t.Errorf("first scan run = %+v", runs[1])
+	}
+}
+
+func TestScanSiteGo(t *testing.T) {
+	page := `<html lang="en"><head><title>t</title></head><body><img src="a.png"></body></html>`
+	s, store := newTestScanner(t, &config.Config{
+		Scanners:    []string{"pa11y", "go"},
+		ScanRetries: 1,
+		Pa11yPath:   flakyPa11y(t),
+	}, map[string]string{"ok.html": page})
+
+	if err := s.ScanSite(); err != nil {
+		t.Fatal(err)
+	}
+	issues, _ := store.QueryIssues(storage.IssueQuery{ScanRunID: 1})
+	if len(issues) != 2 || issues[0].Runner != "" || issues[1].Runner != "go" || issues[1].Code != "WCAG2AA.Principle1.Guideline1_1.1_1_1.H37" {
+		t.Fatalf("issues = %+v, want pa11y's then the go checks'", issues)
+	}
+	pages, _ := store.GetPages()
+	pg := pages[0]
+	if !strings.Contains(pg.Scan_results, `"runner":"go"`) || pg.Scan_status != storage.ScanIssues {
+		t.Errorf("page scan = %q %q, want a report of both scanners' issues", pg.Scan_status, pg.Scan_results)
+	}
+}
+
+// fetchEngine loads pages from where the scanner says they are, a URL or a
+// file, reporting what it got as an issue
+type fetchEngine struct{}
+
+func (fetchEngine) ScanPage(ctx context.Context, pg storage.Pages, location string) (Result, error) {
+	var body []byte
+	if strings.HasPrefix(location, "http://") {
+		resp, err := http.Get(location)
+		if err != nil {
+			return Result{Status: storage.ScanFailed}, err
+		}
+		defer resp.Body.Close()
+		body, _ = io.ReadAll(resp.Body)
+	} else {
+		var err error
+		if body, err = os.ReadFile(location); err != nil {
+			return Result{Status: storage.ScanFailed}, err
+		}
+	}
+	return issuesResult([]storage.Issues{{Code: "fetched", Message: location + " " + string(body)}}), nil
+}
+
+func TestScanSiteServes(t *testing.T) {
+	engines["fetch"] = func(cfg *config.Config) (Engine, error) { return fetchEngine{}, nil }
+	defer delete(engines, "fetch")
+
+	cfg := &config.Config{Scanners: []string{"fetch"}}
+	s, store := newTestScanner(t, cfg, map[string]string{"index.html": "<p>home</p>", "app.js": "run()"})
+
+	if err := s.ScanSite(); err != nil {
+		t.Fatal(err)
+	}
+	issues, _ := store.QueryIssues(storage.IssueQuery{ScanRunID: 1})
+	if len(issues) != 1 || !strings.HasPrefix(issues[0].Message, "http://127.0.0.1:") || !strings.HasSuffix(issues[0].Message, "/index.html <p>home</p>") {
+		t.Fatalf("issues = %+v, want the page fetched from the local server", issues)
+	}
+
+	// Without the server engines get the saved file
+	cfg.ScanFiles = true
+	if err := s.ScanSite(); err != nil {
+		t.Fatal(err)
+	}
+	issues, _ = store.QueryIssues(storage.IssueQuery{ScanRunID: 2})
+	if len(issues) != 1 || !strings.HasPrefix(issues[0].Message, "./index.html ") {
+		t.Errorf("issues = %+v, want the saved file", issues)
+	}
+}
+
+func TestScanSiteOnlyScansSavedHTML(t *testing.T) {
+	s, store := newTestScanner(t, &config.Config{Scanners: []string{"go"}}, map[string]string{
+		"index.html": `<html lang="en"><title>t</title></html>`,
+		"style.css":  "body{}",
+	})
+	// Recorded without a body, like an oversized page or one robots.txt skipped
+	if err := store.SavePage(storage.Pages{Url: "https://example.com/big.html", Status_code: 200, Content_type: "text/html", Declared_size: 1 << 30}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.ScanSite(); err != nil {
+		t.Fatal(err)
+	}
+	scans, _ := store.GetPageScans(1)
+	if len(scans) != 1 || scans[0].Page_url != "https://example.com/index.html" || scans[0].Status != storage.ScanOK {
+		t.Errorf("scans = %+v, want only index.html", scans)
+	}
+}
+
+func TestScanSiteSkipsUnchanged(t *testing.T) {
+	page := `<html lang="en"><title>t</title><img src="a.png"></html>`
+	cfg := &config.Config{Scanners: []string{"go"}}
+	s, store := newTestScanner(t, cfg, map[string]string{"a.html": page, "b.html": page})
+	// scanned lists the pages a run scanned, leaving out unchanged ones
+	scanned := func(scanRunID int64) []string {
+		t.Helper()
+		scans, err := store.GetPageScans(scanRunID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var urls []string
+		for _, ps := range scans {
+			if ps.Status != storage.ScanUnchanged {
+				urls = append(urls, strings.TrimPrefix(ps.Page_url, "https://example.com/"))
+			}
+		}
+		return urls
+	}
+
+	if err := s.ScanSite(); err != nil {
+		t.Fatal(err)
+	}
+	if got := scanned(1); len(got) != 2 {
+		t.Fatalf("first scan = %v, want every page", got)
+	}
+
+	// Only the page whose content changed is scanned again
+	pages, _ := store.GetPages()
+	for _, pg := range pages {
+		if pg.File_path == "b.html" {
+			pg.Content_hash = "changed"
+			store.SavePage(pg)
+		}
+	}
+	if err := s.ScanSite(); err != nil {
+		t.Fatal(err)
+	}
+	if got := scanned(2); len(got) != 1 || got[0] != "b.html" {
+		t.Errorf("second scan = %v, want only b.html", got)
+	}
+
+	// The unchanged page keeps its issues in the new run, so exporting the
+	// latest run's issues still covers the whole site
+	scans, _ := store.GetPageScans(2)
+	if len(scans) != 2 || scans[0].Status != storage.ScanUnchanged || scans[0].Issues != 1 {
+		t.Errorf("second scan's page scans = %+v, want a.html unchanged with its issue", scans)
+	}
+	table, err := export.Build(store, export.DatasetIssues, export.Filter{}, []string{"scan_run_id", "url"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(table.Rows) != 2 || table.Rows[0][0] != int64(2) || table.Rows[1][0] != int64(2) {
+		t.Errorf("exported issues = %v, want both pages' issues from run 2", table.Rows)
+	}
+	runs, _ := store.GetScanRuns()
+	if runs[0].Pages_scanned != 1 || runs[0].Issues_found != 2 {
+		t.Errorf("second scan run = %+v, want 1 page scanned and 2 issues", runs[0])
+	}
+
+	// Unchanged pages stay unchanged on the next run
+	if err := s.ScanSite(); err != nil {
+		t.Fatal(err)
+	}
+	if got := scanned(3); len(got) != 0 {
+		t.Errorf("third scan = %v, want nothing scanned", got)
+	}
+
+	// Changing the scanners, or forcing, scans everything
+	cfg.Scanners = []string{"go", "go"}
+	if err := s.ScanSite(); err != nil {
+		t.Fatal(err)
+	}
+	if got := scanned(4); len(got) != 2 {
+		t.Errorf("scan with other scanners = %v, want every page", got)
+	}
+	cfg.ScanUnchanged = true
+	if err := s.ScanSite(); err != nil {
+		t.Fatal(err)
+	}
+	if got := scanned(5); len(got) != 2 {
+		t.Errorf("forced scan = %v, want every page", got)
+	}
+}
@@ -0,0 +1,163 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"boem-web-thing/storage"
+)
+
+// tool is a command line program a scanner runs. It is run directly, without
+// a shell, so file paths with spaces are passed as they are.
+type tool struct {
+	name    string   // what the tool is called in errors
+	command []string // the program to run and any arguments before the tool's own
+	env     []string
+	version string // as the tool printed it in the preflight
+}
+
+// preflightTimeout is how long tools get to print their versions
+const preflightTimeout = 30 * time.Second
+
+// errTimedOut is returned when a tool is stopped because its scan ran out of
+// time
+var errTimedOut = errors.New("timed out")
+
+// newTool sets up the environment for a tool. nodeDir, when set, is put
+// first on the PATH the tool runs with.
+func newTool(name string, nodeDir string) tool {
+	t := tool{name: name, env: os.Environ()}
+	if nodeDir != "" {
+		t.env = prependPath(t.env, nodeDir)
+	}
+	return t
+}
+
+// find looks for a program installed by npm in ./node_modules/.bin, then in
+// nodeDir and on PATH
+func find(name string, nodeDir string) (string, error) {
+	if found, err := exec.LookPath(filepath.Join("node_modules", ".bin", name)); err == nil {
+		return found, nil
+	}
+	if nodeDir != "" {
+		if found, err := exec.LookPath(filepath.Join(nodeDir, name)); err == nil {
+			return found, nil
+		}
+	}
+	return exec.LookPath(name)
+}
+
+// checkNode checks that node runs, for tools that need it
+func (t tool) checkNode(nodeDir string) error {
+	var node string
+	var err error
+	if nodeDir != "" {
+		node, err = exec.LookPath(filepath.Join(nodeDir, "node"))
+	}
+	if node == "" {
+		node, err = exec.LookPath("node")
+	}
+	if err != nil {
+		return fmt.Errorf("node not found in node_path %q or PATH, install Node.js or set node_path to the directory it is in", nodeDir)
+	}
+	if _, err := t.run(preflightTimeout, node, "--version"); err != nil {
+		return fmt.Errorf("node at %s doesn't run: %w", node, err)
+	}
+	return nil
+}
+
+// run runs a preflight command and returns what it printed
+func (t tool) run(timeout time.Duration, command ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Env = t.env
+	killGroup(cmd)
+	cmd.WaitDelay = 5 * time.Second
+	out, err := cmd.Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		return "", fmt.Errorf("no answer after %s", timeout)
+	}
+	return strings.TrimSpace(string(out)), withStderr(err)
+}
+
+// output is what a tool printed and the code it exited with
+type output struct {
+	stdout   string
+	stderr   string
+	exitCode int
+}
+
+// exec runs the tool with args, killing it if ctx ends first. Exiting with
+// an error code isn't an error here, as tools use their exit codes for what
+// they found; the error is set when the tool couldn't run or timed out.
+func (t tool) exec(ctx context.Context, args ...string) (output, error) {
+	args = append(append([]string{}, t.command[1:]...), args...)
+	cmd := exec.CommandContext(ctx, t.command[0], args...)
+	cmd.Env = t.env
+	// Stop any browser the tool starts as well as the tool, and don't wait
+	// long for anything still holding the output open
+	killGroup(cmd)
+	cmd.WaitDelay = 5 * time.Second
+
+	// npm notices on stderr are kept out of the report
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+
+	if ctx.Err() == context.DeadlineExceeded {
+		return output{}, errTimedOut
+	}
+	out := output{stdout: stdout.String(), stderr: stderr.String()}
+	if err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return output{}, fmt.Errorf("running %s: %w", t.name, err)
+		}
+		out.exitCode = exitErr.ExitCode()
+	}
+	return out, nil
+}
+
+// failed is the result of a scan a tool didn't finish
+func failed(err error) Result {
+	if errors.Is(err, errTimedOut) {
+		return Result{Status: storage.ScanTimedOut}
+	}
+	return Result{Status: storage.ScanFailed}
+}
+
+// withStderr adds what a failed command wrote to stderr to its error
+func withStderr(err error) error {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	return err
+}
+
+// prependPath puts dir first on the PATH in env
+func prependPath(env []string, dir string) []string {
+	out := make([]string, 0, len(env)+1)
+	found := false
+	for _, kv := range env {
+		key, value, _ := strings.Cut(kv, "=")
+		if strings.EqualFold(key, "PATH") {
+			kv = key + "=" + dir + string(os.PathListSeparator) + value
+			found = true
+		}
+		out = append(out, kv)
+	}
+	if !found {
+		out = append(out, "PATH="+dir)
+	}
+	return out
+}
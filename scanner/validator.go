@@ -0,0 +1,137 @@
+package scanner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"boem-web-thing/config"
+	"boem-web-thing/storage"
+)
+
+func init() {
+	Register("validator", func(cfg *config.Config) (Engine, error) {
+		return NewValidator(cfg.ValidatorPath)
+	})
+}
+
+// Validator runs the Nu Html Checker (vnu), the W3C's HTML validator
+type Validator struct {
+	tool
+}
+
+// localValidator is where npm install vnu-jar puts the validator
+var localValidator = filepath.Join("node_modules", "vnu-jar", "build", "dist", "vnu.jar")
+
+// NewValidator finds the validator and checks that it runs. validatorPath
+// is the vnu program or vnu.jar to use, a jar being run with java from PATH.
+// When empty vnu is looked for on PATH, then the jar from npm's vnu-jar.
+func NewValidator(validatorPath string) (*Validator, error) {
+	v := &Validator{tool: newTool("validator", "")}
+
+	jar := ""
+	switch {
+	case strings.HasSuffix(validatorPath, ".jar"):
+		jar = validatorPath
+	case validatorPath != "":
+		found, err := exec.LookPath(validatorPath)
+		if err != nil {
+			return nil, fmt.Errorf("validator_path %q: %w", validatorPath, err)
+		}
+		v.command = []string{found}
+	default:
+		if found, err := exec.LookPath("vnu"); err == nil {
+			v.command = []string{found}
+		} else {
+			jar = localValidator
+		}
+	}
+	if jar != "" {
+		if _, err := os.Stat(jar); err != nil {
+			return nil, fmt.Errorf("validator not found, install vnu, run npm install vnu-jar here or set validator_path")
+		}
+		java, err := exec.LookPath("java")
+		if err != nil {
+			return nil, fmt.Errorf("java not found on PATH, it is needed to run %s", jar)
+		}
+		v.command = []string{java, "-jar", jar}
+	}
+	var err error
+	if v.version, err = v.run(preflightTimeout, append(v.command, "--version")...); err != nil {
+		return nil, fmt.Errorf("validator (%s) doesn't run: %w", strings.Join(v.command, " "), err)
+	}
+	return v, nil
+}
+
+// settings is the validator's version, which changes what it finds
+func (v *Validator) settings() string {
+	return v.version
+}
+
+// ScanPage validates a stored page. The validator is told to exit with 0
+// whatever it finds, so any other exit is a failure.
+func (v *Validator) ScanPage(ctx context.Context, pg storage.Pages, location string) (Result, error) {
+	out, err := v.exec(ctx, "--format", "json", "--stdout", "--exit-zero-always", location)
+	if err != nil {
+		return failed(err), fmt.Errorf("validator on %s: %w", pg.File_path, err)
+	}
+	if out.exitCode != 0 {
+		return Result{Status: storage.ScanFailed}, fmt.Errorf("validator exited with %d: %s", out.exitCode, describeFailure(out.stderr))
+	}
+	issues, err := parseValidator(out.stdout)
+	if err != nil {
+		return Result{Status: storage.ScanFailed}, err
+	}
+	return issuesResult(issues), nil
+}
+
+// validatorMessage is one message in the validator's json output
+type validatorMessage struct {
+	Type     string `json:"type"`
+	SubType  string `json:"subType"`
+	Message  string `json:"message"`
+	Extract  string `json:"extract"`
+	LastLine int    `json:"lastLine"`
+}
+
+// parseValidator reads the issues out of the validator's json output. The
+// validator has no rule codes, so the code is the kind of message: errors,
+// warnings and info become pa11y's error, warning and notice. A message
+// about the document not being readable means the scan failed.
+func parseValidator(output string) ([]storage.Issues, error) {
+	var report struct {
+		Messages []validatorMessage `json:"messages"`
+	}
+	if err := json.Unmarshal([]byte(output), &report); err != nil {
+		return nil, fmt.Errorf("reading validator report: %w", err)
+	}
+
+	issues := make([]storage.Issues, 0, len(report.Messages))
+	for _, m := range report.Messages {
+		typ := "notice"
+		switch {
+		case m.Type == "non-document-error":
+			return nil, fmt.Errorf("validator couldn't read the page: %s", m.Message)
+		case m.Type == "error":
+			typ = "error"
+		case m.SubType == "warning":
+			typ = "warning"
+		}
+		message := m.Message
+		if m.LastLine > 0 {
+			message = fmt.Sprintf("%s (line %d)", m.Message, m.LastLine)
+		}
+		issues = append(issues, storage.Issues{
+			Code:    "vnu." + typ,
+			Type:    typ,
+			Message: message,
+			Context: m.Extract,
+			Runner:  "vnu",
+		})
+	}
+	return issues, nil
+}
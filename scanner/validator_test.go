@@ -0,0 +1,35 @@
+package scanner
+
+import "testing"
+
+func TestParseValidator(t *testing.T) {
+	output := `{"messages":[
+		{"type":"error","lastLine":3,"message":"Element “title” must not be empty.","extract":"<title></title>"},
+		{"type":"info","subType":"warning","message":"Consider adding a “lang” attribute."},
+		{"type":"info","lastLine":9,"message":"Trailing slash on void elements has no effect."}
+	]}`
+	issues, err := parseValidator(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct{ code, typ, message string }{
+		{"vnu.error", "error", "Element “title” must not be empty. (line 3)"},
+		{"vnu.warning", "warning", "Consider adding a “lang” attribute."},
+		{"vnu.notice", "notice", "Trailing slash on void elements has no effect. (line 9)"},
+	}
+	if len(issues) != len(want) {
+		t.Fatalf("parseValidator() = %+v", issues)
+	}
+	for i, w := range want {
+		if issues[i].Code != w.code || issues[i].Type != w.typ || issues[i].Message != w.message || issues[i].Runner != "vnu" {
+			t.Errorf("issue %d = %+v, want %+v", i, issues[i], w)
+		}
+	}
+	if issues[0].Context != "<title></title>" {
+		t.Errorf("context = %q", issues[0].Context)
+	}
+
+	if _, err := parseValidator(`{"messages":[{"type":"non-document-error","subType":"io","message":"File not found"}]}`); err == nil {
+		t.Error("parseValidator() should fail when the page couldn't be read")
+	}
+}
@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+)
+
+// Writes are buffered and applied by one goroutine in batched transactions,
+// as a transaction per page and link is what slows large crawls down. A batch
+// is committed once it holds batchSize writes or batchInterval has passed.
+const (
+	batchSize     = 500
+	batchInterval = 250 * time.Millisecond
+)
+
+// errClosed is returned for writes after the storage has been closed
+var errClosed = errors.New("storage is closed")
+
+// batchOp is a write to make in the current batch, or when write is nil, a
+// request to commit the batch and signal on flushed. Only flushes that report
+// hand over, and clear, the write errors.
+type batchOp struct {
+	write   func(tx *sql.Tx) error
+	flushed chan error
+	report  bool
+}
+
+// startWriter starts the goroutine that owns all batched writes
+func (s *Storage) startWriter() {
+	s.writes = make(chan batchOp, batchSize)
+	s.writerDone = make(chan struct{})
+	go s.writer()
+}
+
+func (s *Storage) writer() {
+	defer close(s.writerDone)
+
+	var (
+		tx      *sql.Tx
+		pending int
+		errs    []error // write errors since the last flush
+	)
+	commit := func() {
+		if tx == nil {
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			log.Printf("Error committing batched writes: %v", err)
+			errs = append(errs, err)
+		}
+		tx = nil
+		pending = 0
+	}
+
+	ticker := time.NewTicker(batchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case op, ok := <-s.writes:
+			if !ok {
+				commit()
+				return
+			}
+			if op.write == nil {
+				commit()
+				if op.report {
+					op.flushed <- errors.Join(errs...)
+					errs = nil
+				} else {
+					op.flushed <- nil
+				}
+				continue
+			}
+			if tx == nil {
+				var err error
+				if tx, err = s.db.Begin(); err != nil {
+					log.Printf("Error starting batched writes: %v", err)
+					errs = append(errs, err)
+					continue
+				}
+			}
+			if err := op.write(tx); err != nil {
+				log.Printf("Error in batched write: %v", err)
+				errs = append(errs, err)
+			}
+			pending++
+			if pending >= batchSize {
+				commit()
+			}
+		case <-ticker.C:
+			commit()
+		}
+	}
+}
+
+// enqueue hands a write to the writer goroutine
+func (s *Storage) enqueue(write func(tx *sql.Tx) error) error {
+	s.closeMu.RLock()
+	defer s.closeMu.RUnlock()
+	if s.closed {
+		return errClosed
+	}
+	s.writes <- batchOp{write: write}
+	return nil
+}
+
+// Flush commits any buffered writes and returns the errors from writes made
+// since the last flush.
+func (s *Storage) Flush() error {
+	return s.commitPending(true)
+}
+
+// settle commits any buffered writes so a read, or an unbatched write, sees
+// everything written before it. Write errors are left for Flush.
+func (s *Storage) settle() {
+	_ = s.commitPending(false)
+}
+
+func (s *Storage) commitPending(report bool) error {
+	s.closeMu.RLock()
+	defer s.closeMu.RUnlock()
+	if s.closed {
+		return errClosed
+	}
+	flushed := make(chan error, 1)
+	s.writes <- batchOp{flushed: flushed, report: report}
+	return <-flushed
+}
+
+// stopWriter commits what is left and waits for the writer to finish
+func (s *Storage) stopWriter() {
+	s.closeMu.Lock()
+	defer s.closeMu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	close(s.writes)
+	<-s.writerDone
+}
@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestBatchedWritesAreReadable(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "batch.db")
+	s, err := New(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runID, err := s.StartRun("https://example.com/", "{}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// More than one batch worth, so some commit on size and the rest on read
+	n := batchSize + 10
+	for i := 0; i < n; i++ {
+		u := fmt.Sprintf("https://example.com/%d", i)
+		if err := s.SavePage(Pages{Url: u, Status_code: 200, Run_id: runID}); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.SaveLink(runID, "https://example.com/", u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pages, err := s.GetRunPages(runID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != n {
+		t.Errorf("read %d pages, want %d", len(pages), n)
+	}
+	if err := s.Flush(); err != nil {
+		t.Errorf("Flush() = %v", err)
+	}
+
+	// Writes still buffered when the storage closes are kept
+	if err := s.SaveLink(runID, "https://example.com/", "https://example.com/last"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveLink(runID, "https://example.com/", "https://example.com/late"); err == nil {
+		t.Error("expected an error writing to closed storage")
+	}
+
+	s, err = New(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	links, err := s.GetRunLinks(runID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(links) != n+1 {
+		t.Errorf("read %d links, want %d", len(links), n+1)
+	}
+}
+
+func TestFlushReportsWriteErrors(t *testing.T) {
+	s, err := New(filepath.Join(t.TempDir(), "errors.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if _, err := s.db.Exec("DROP TABLE links"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveLink(1, "https://example.com/", "https://example.com/a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Flush(); err == nil {
+		t.Error("expected Flush to report the failed write")
+	}
+	if err := s.Flush(); err != nil {
+		t.Errorf("errors should be cleared once reported, got %v", err)
+	}
+}
@@ -0,0 +1,610 @@
+package storage
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"boem-web-thing/util"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// BoltStore keeps everything in a single bbolt file. Records are stored as
+// JSON, keyed so the lookups the crawler and scanner make don't need a scan:
+//
+//	pages         url -> page
+//	page_files    file path + 0 + url -> nothing, to find pages by file path
+//	observations  run id -> url -> page
+//	links         run id -> sequence -> link
+//	runs          id -> run
+//	frontier      run id -> url -> sequence and fetched flag
+//	scan_runs     id -> scan run
+//	issues        scan run id -> page url + 0 + sequence -> issue
+//	page_scans    scan run id -> page url -> page scan
+type BoltStore struct {
+	db *bolt.DB
+}
+
+var (
+	boltPages        = []byte("pages")
+	boltPageFiles    = []byte("page_files")
+	boltObservations = []byte("observations")
+	boltLinks        = []byte("links")
+	boltRuns         = []byte("runs")
+	boltFrontier     = []byte("frontier")
+	boltScanRuns     = []byte("scan_runs")
+	boltIssues       = []byte("issues")
+	boltPageScans    = []byte("page_scans")
+)
+
+// NewBolt opens (or creates) the bbolt file at the given path
+func NewBolt(path string) (*BoltStore, error) {
+	if err := util.EnsureDir(filepath.Dir(path)); err != nil {
+		return nil, fmt.Errorf("failed to ensure db dir: %w", err)
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltPages, boltPageFiles, boltObservations, boltLinks, boltRuns, boltFrontier, boltScanRuns, boltIssues, boltPageScans} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStore{db: db}, nil
+}
+
+// SavePage inserts or updates a page record, and the run's observation of it.
+// Writes from concurrent goroutines are committed together by bbolt's Batch.
+func (b *BoltStore) SavePage(pg Pages) error {
+	pg.Fetched_at = time.Now()
+	pg.Host = HostOf(pg.Url)
+	return b.db.Batch(func(tx *bolt.Tx) error {
+		pages := tx.Bucket(boltPages)
+		files := tx.Bucket(boltPageFiles)
+
+		var existing Pages
+		if found, err := getJSON(pages, []byte(pg.Url), &existing); err != nil {
+			return err
+		} else if found {
+			pg.Id = existing.Id
+			pg.Scan_results = existing.Scan_results
+			pg.Scan_status = existing.Scan_status
+			if err := files.Delete(fileKey(existing.File_path, existing.Url)); err != nil {
+				return err
+			}
+		} else {
+			seq, err := pages.NextSequence()
+			if err != nil {
+				return err
+			}
+			pg.Id = int(seq)
+			pg.Scan_results = ""
+			pg.Scan_status = ""
+		}
+		if err := putJSON(pages, []byte(pg.Url), pg); err != nil {
+			return err
+		}
+		if err := files.Put(fileKey(pg.File_path, pg.Url), nil); err != nil {
+			return err
+		}
+
+		if pg.Run_id == 0 {
+			return nil
+		}
+		observations, err := tx.Bucket(boltObservations).CreateBucketIfNotExists(itob(pg.Run_id))
+		if err != nil {
+			return err
+		}
+		seq, err := observations.NextSequence()
+		if err != nil {
+			return err
+		}
+		observed := pg
+		observed.Id = int(seq)
+		observed.Scan_results = ""
+		observed.Scan_status = ""
+		return putJSON(observations, []byte(pg.Url), observed)
+	})
+}
+
+// GetPages returns every page record in the order they were first saved
+func (b *BoltStore) GetPages() ([]Pages, error) {
+	return b.pages(nil)
+}
+
+// QueryPages returns the pages that match the query, see PageQuery
+func (b *BoltStore) QueryPages(q PageQuery) ([]Pages, error) {
+	pages, err := b.pages(q.matches)
+	return q.page(pages), err
+}
+
+// SaveScan saves the scan result on the page and on the run's observation of it
+func (b *BoltStore) SaveScan(filePath string, result string) error {
+	return b.db.Batch(func(tx *bolt.Tx) error {
+		pages := tx.Bucket(boltPages)
+		prefix := fileKey(filePath, "")
+		c := tx.Bucket(boltPageFiles).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			url := k[len(prefix):]
+			var pg Pages
+			if found, err := getJSON(pages, url, &pg); err != nil || !found {
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			pg.Scan_results = result
+			if err := putJSON(pages, url, pg); err != nil {
+				return err
+			}
+
+			observations := tx.Bucket(boltObservations).Bucket(itob(pg.Run_id))
+			if observations == nil {
+				continue
+			}
+			var observed Pages
+			if found, err := getJSON(observations, url, &observed); err != nil {
+				return err
+			} else if found {
+				observed.Scan_results = result
+				if err := putJSON(observations, url, observed); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// SaveLink records a link found on a page during a crawl run
+func (b *BoltStore) SaveLink(runID int64, fromURL, toURL string) error {
+	return b.db.Batch(func(tx *bolt.Tx) error {
+		root := tx.Bucket(boltLinks)
+		links, err := root.CreateBucketIfNotExists(itob(runID))
+		if err != nil {
+			return err
+		}
+		// Ids are unique across runs, like the SQLite table
+		seq, err := root.NextSequence()
+		if err != nil {
+			return err
+		}
+		link := Links{Id: int(seq), From_url: fromURL, To_url: toURL, Run_id: runID}
+		return putJSON(links, itob(int64(seq)), link)
+	})
+}
+
+// StartRun records the start of a crawl and returns the id of the new run
+func (b *BoltStore) StartRun(startURL string, config string) (int64, error) {
+	var id int64
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		runs := tx.Bucket(boltRuns)
+		seq, err := runs.NextSequence()
+		if err != nil {
+			return err
+		}
+		id = int64(seq)
+		run := CrawlRuns{Id: id, Start_url: startURL, Config: config, Started_at: time.Now()}
+		return putJSON(runs, itob(id), run)
+	})
+	return id, err
+}
+
+// FinishRun records how a crawl ended
+func (b *BoltStore) FinishRun(runID int64, outcome string, budget string, pages int, bytes int64) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		runs := tx.Bucket(boltRuns)
+		var run CrawlRuns
+		if found, err := getJSON(runs, itob(runID), &run); err != nil || !found {
+			return err
+		}
+		run.Finished_at = sql.NullTime{Time: time.Now(), Valid: true}
+		run.Outcome = outcome
+		run.Budget = budget
+		run.Pages_fetched = pages
+		run.Bytes_fetched = bytes
+		return putJSON(runs, itob(runID), run)
+	})
+}
+
+// GetRuns returns every crawl run, newest first
+func (b *BoltStore) GetRuns() ([]CrawlRuns, error) {
+	runs := make([]CrawlRuns, 0)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltRuns).Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			var run CrawlRuns
+			if err := json.Unmarshal(v, &run); err != nil {
+				return err
+			}
+			runs = append(runs, run)
+		}
+		return nil
+	})
+	return runs, err
+}
+
+// GetRun returns a single crawl run
+func (b *BoltStore) GetRun(runID int64) (CrawlRuns, error) {
+	var run CrawlRuns
+	err := b.db.View(func(tx *bolt.Tx) error {
+		found, err := getJSON(tx.Bucket(boltRuns), itob(runID), &run)
+		if err == nil && !found {
+			err = fmt.Errorf("no crawl run with id %d", runID)
+		}
+		return err
+	})
+	return run, err
+}
+
+// GetRunPages returns the pages as they were seen by one crawl run
+func (b *BoltStore) GetRunPages(runID int64) ([]Pages, error) {
+	pages := make([]Pages, 0)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		observations := tx.Bucket(boltObservations).Bucket(itob(runID))
+		if observations == nil {
+			return nil
+		}
+		return observations.ForEach(func(k, v []byte) error {
+			var pg Pages
+			if err := json.Unmarshal(v, &pg); err != nil {
+				return err
+			}
+			pages = append(pages, pg)
+			return nil
+		})
+	})
+	sort.Slice(pages, func(i, j int) bool { return pages[i].Id < pages[j].Id })
+	return pages, err
+}
+
+// GetRunLinks returns the links found during one crawl run
+func (b *BoltStore) GetRunLinks(runID int64) ([]Links, error) {
+	links := make([]Links, 0)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltLinks).Bucket(itob(runID))
+		if bucket == nil {
+			return nil
+		}
+		// Keys are big endian sequence numbers, so this is id order
+		return bucket.ForEach(func(k, v []byte) error {
+			var link Links
+			if err := json.Unmarshal(v, &link); err != nil {
+				return err
+			}
+			links = append(links, link)
+			return nil
+		})
+	})
+	return links, err
+}
+
+// StartScanRun records the start of an accessibility scan and returns the id
+// of the new scan run
+func (b *BoltStore) StartScanRun() (int64, error) {
+	var id int64
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		runs := tx.Bucket(boltScanRuns)
+		seq, err := runs.NextSequence()
+		if err != nil {
+			return err
+		}
+		id = int64(seq)
+		return putJSON(runs, itob(id), ScanRuns{Id: id, Started_at: time.Now()})
+	})
+	return id, err
+}
+
+// FinishScanRun records that a scan ended and what it found
+func (b *BoltStore) FinishScanRun(scanRunID int64, pages int, issues int) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		runs := tx.Bucket(boltScanRuns)
+		var run ScanRuns
+		if found, err := getJSON(runs, itob(scanRunID), &run); err != nil || !found {
+			return err
+		}
+		run.Finished_at = sql.NullTime{Time: time.Now(), Valid: true}
+		run.Pages_scanned = pages
+		run.Issues_found = issues
+		return putJSON(runs, itob(scanRunID), run)
+	})
+}
+
+// GetScanRuns returns every scan run, newest first
+func (b *BoltStore) GetScanRuns() ([]ScanRuns, error) {
+	runs := make([]ScanRuns, 0)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltScanRuns).Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			var run ScanRuns
+			if err := json.Unmarshal(v, &run); err != nil {
+				return err
+			}
+			runs = append(runs, run)
+		}
+		return nil
+	})
+	return runs, err
+}
+
+// SaveIssues replaces the issues a scan run found on a page
+func (b *BoltStore) SaveIssues(scanRunID int64, pageURL string, issues []Issues) error {
+	return b.db.Batch(func(tx *bolt.Tx) error {
+		root := tx.Bucket(boltIssues)
+		bucket, err := root.CreateBucketIfNotExists(itob(scanRunID))
+		if err != nil {
+			return err
+		}
+		prefix := fileKey(pageURL, "")
+		c := bucket.Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Seek(prefix) {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		for _, issue := range issues {
+			// Ids are unique across scan runs, like the SQLite table
+			seq, err := root.NextSequence()
+			if err != nil {
+				return err
+			}
+			issue.Id = int64(seq)
+			issue.Scan_run_id = scanRunID
+			issue.Page_url = pageURL
+			issue.Host = HostOf(pageURL)
+			// bbolt keeps the key until the transaction ends, so each needs its own
+			key := append(fileKey(pageURL, ""), itob(issue.Id)...)
+			if err := putJSON(bucket, key, issue); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SavePageScan records how a scan run's scan of a page went, and keeps the
+// status on the page
+func (b *BoltStore) SavePageScan(ps PageScans) error {
+	ps.Scanned_at = time.Now()
+	return b.db.Batch(func(tx *bolt.Tx) error {
+		scans, err := tx.Bucket(boltPageScans).CreateBucketIfNotExists(itob(ps.Scan_run_id))
+		if err != nil {
+			return err
+		}
+		if err := putJSON(scans, []byte(ps.Page_url), ps); err != nil {
+			return err
+		}
+
+		pages := tx.Bucket(boltPages)
+		var pg Pages
+		if found, err := getJSON(pages, []byte(ps.Page_url), &pg); err != nil || !found {
+			return err
+		}
+		pg.Scan_status = ps.Status
+		return putJSON(pages, []byte(ps.Page_url), pg)
+	})
+}
+
+// GetPageScans returns how a scan run's scan of each page went, in URL order
+func (b *BoltStore) GetPageScans(scanRunID int64) ([]PageScans, error) {
+	scans := make([]PageScans, 0)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltPageScans).Bucket(itob(scanRunID))
+		if bucket == nil {
+			return nil
+		}
+		// Keys are the page URLs, so this is URL order
+		return bucket.ForEach(func(k, v []byte) error {
+			var ps PageScans
+			if err := json.Unmarshal(v, &ps); err != nil {
+				return err
+			}
+			scans = append(scans, ps)
+			return nil
+		})
+	})
+	return scans, err
+}
+
+// GetLastPageScans returns how the latest scan of each page went, whichever
+// scan run it was in, in URL order
+func (b *BoltStore) GetLastPageScans() ([]PageScans, error) {
+	last := make(map[string]PageScans)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		// Scan runs are in id order, so later scans replace earlier ones
+		return tx.Bucket(boltPageScans).ForEachBucket(func(runID []byte) error {
+			return tx.Bucket(boltPageScans).Bucket(runID).ForEach(func(k, v []byte) error {
+				var ps PageScans
+				if err := json.Unmarshal(v, &ps); err != nil {
+					return err
+				}
+				last[ps.Page_url] = ps
+				return nil
+			})
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	scans := make([]PageScans, 0, len(last))
+	for _, ps := range last {
+		scans = append(scans, ps)
+	}
+	sort.Slice(scans, func(i, j int) bool { return scans[i].Page_url < scans[j].Page_url })
+	return scans, nil
+}
+
+// QueryIssues returns the issues that match the query, see IssueQuery
+func (b *BoltStore) QueryIssues(q IssueQuery) ([]Issues, error) {
+	issues := make([]Issues, 0)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		root := tx.Bucket(boltIssues)
+		read := func(bucket *bolt.Bucket) error {
+			if bucket == nil {
+				return nil
+			}
+			return bucket.ForEach(func(_, v []byte) error {
+				var issue Issues
+				if err := json.Unmarshal(v, &issue); err != nil {
+					return err
+				}
+				if q.matches(issue) {
+					issues = append(issues, issue)
+				}
+				return nil
+			})
+		}
+		if q.ScanRunID != 0 {
+			return read(root.Bucket(itob(q.ScanRunID)))
+		}
+		return root.ForEach(func(k, _ []byte) error {
+			return read(root.Bucket(k))
+		})
+	})
+	sort.Slice(issues, func(i, j int) bool { return issues[i].Id < issues[j].Id })
+	return q.page(issues), err
+}
+
+// AddToFrontier records that a run queued the URL
+func (b *BoltStore) AddToFrontier(runID int64, rawURL string) error {
+	return b.setFrontier(runID, rawURL, false)
+}
+
+// MarkFetched records that a run has fetched the URL
+func (b *BoltStore) MarkFetched(runID int64, rawURL string) error {
+	return b.setFrontier(runID, rawURL, true)
+}
+
+// GetFrontier returns the URLs a run queued but has not fetched, in queue order
+func (b *BoltStore) GetFrontier(runID int64) ([]string, error) {
+	type queued struct {
+		url string
+		seq uint64
+	}
+	var pending []queued
+	err := b.db.View(func(tx *bolt.Tx) error {
+		frontier := tx.Bucket(boltFrontier).Bucket(itob(runID))
+		if frontier == nil {
+			return nil
+		}
+		return frontier.ForEach(func(k, v []byte) error {
+			seq, fetched := decodeFrontier(v)
+			if !fetched {
+				pending = append(pending, queued{url: string(k), seq: seq})
+			}
+			return nil
+		})
+	})
+	sort.Slice(pending, func(i, j int) bool { return pending[i].seq < pending[j].seq })
+	urls := make([]string, 0, len(pending))
+	for _, q := range pending {
+		urls = append(urls, q.url)
+	}
+	return urls, err
+}
+
+// Flush has nothing to do, every write is committed before it returns
+func (b *BoltStore) Flush() error {
+	return nil
+}
+
+// Close closes the bbolt file
+func (b *BoltStore) Close() error {
+	return b.db.Close()
+}
+
+// pages reads the page records that pass keep, or all of them when keep is
+// nil, in id order
+func (b *BoltStore) pages(keep func(Pages) bool) ([]Pages, error) {
+	pages := make([]Pages, 0)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltPages).ForEach(func(k, v []byte) error {
+			var pg Pages
+			if err := json.Unmarshal(v, &pg); err != nil {
+				return err
+			}
+			if keep == nil || keep(pg) {
+				pages = append(pages, pg)
+			}
+			return nil
+		})
+	})
+	sort.Slice(pages, func(i, j int) bool { return pages[i].Id < pages[j].Id })
+	return pages, err
+}
+
+// setFrontier adds the URL to the run's frontier, keeping its place in the
+// queue if it is already there. Once fetched it stays fetched.
+func (b *BoltStore) setFrontier(runID int64, rawURL string, fetched bool) error {
+	return b.db.Batch(func(tx *bolt.Tx) error {
+		frontier, err := tx.Bucket(boltFrontier).CreateBucketIfNotExists(itob(runID))
+		if err != nil {
+			return err
+		}
+		seq, wasFetched := uint64(0), false
+		if v := frontier.Get([]byte(rawURL)); v != nil {
+			seq, wasFetched = decodeFrontier(v)
+		} else if seq, err = frontier.NextSequence(); err != nil {
+			return err
+		}
+		return frontier.Put([]byte(rawURL), encodeFrontier(seq, fetched || wasFetched))
+	})
+}
+
+// fileKey is the page_files key for a page, an empty url gives the prefix
+// of every page saved at the file path
+func fileKey(filePath string, url string) []byte {
+	return append(append([]byte(filePath), 0), url...)
+}
+
+func itob(n int64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(n))
+	return b
+}
+
+func encodeFrontier(seq uint64, fetched bool) []byte {
+	v := make([]byte, 9)
+	binary.BigEndian.PutUint64(v, seq)
+	if fetched {
+		v[8] = 1
+	}
+	return v
+}
+
+func decodeFrontier(v []byte) (uint64, bool) {
+	if len(v) < 9 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint64(v), v[8] == 1
+}
+
+func getJSON(bucket *bolt.Bucket, key []byte, v any) (bool, error) {
+	data := bucket.Get(key)
+	if data == nil {
+		return false, nil
+	}
+	return true, json.Unmarshal(data, v)
+}
+
+func putJSON(bucket *bolt.Bucket, key []byte, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(key, data)
+}
@@ -0,0 +1,373 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps everything in memory, for tests and dry runs. It behaves
+// like the SQLite store: page ids are kept when a page is saved again, and a
+// page's scan result survives a re-fetch until the next scan.
+type MemoryStore struct {
+	mu           sync.Mutex
+	pages        map[string]*Pages
+	observations map[int64]map[string]*Pages
+	links        []Links
+	runs         []CrawlRuns
+	frontier     map[int64]*memoryFrontier
+	scanRuns     []ScanRuns
+	issues       []Issues
+	pageScans    map[int64]map[string]PageScans
+	nextID       int
+}
+
+type memoryFrontier struct {
+	order   []string
+	fetched map[string]bool
+}
+
+// NewMemory makes an empty in-memory store
+func NewMemory() *MemoryStore {
+	return &MemoryStore{
+		pages:        make(map[string]*Pages),
+		observations: make(map[int64]map[string]*Pages),
+		frontier:     make(map[int64]*memoryFrontier),
+		pageScans:    make(map[int64]map[string]PageScans),
+	}
+}
+
+// SavePage inserts or updates a page record, and the run's observation of it
+func (m *MemoryStore) SavePage(pg Pages) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pg.Fetched_at = time.Now()
+	pg.Host = HostOf(pg.Url)
+	if existing, ok := m.pages[pg.Url]; ok {
+		pg.Id = existing.Id
+		pg.Scan_results = existing.Scan_results
+		pg.Scan_status = existing.Scan_status
+	} else {
+		pg.Id = m.id()
+		pg.Scan_results = ""
+		pg.Scan_status = ""
+	}
+	saved := pg
+	m.pages[pg.Url] = &saved
+
+	if pg.Run_id != 0 {
+		if m.observations[pg.Run_id] == nil {
+			m.observations[pg.Run_id] = make(map[string]*Pages)
+		}
+		observed := pg
+		observed.Id = m.id()
+		observed.Scan_results = ""
+		observed.Scan_status = ""
+		m.observations[pg.Run_id][pg.Url] = &observed
+	}
+	return nil
+}
+
+// GetPages returns every page record in the order they were first saved
+func (m *MemoryStore) GetPages() ([]Pages, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return sortedPages(m.pages, nil), nil
+}
+
+// QueryPages returns the pages that match the query, see PageQuery
+func (m *MemoryStore) QueryPages(q PageQuery) ([]Pages, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return q.page(sortedPages(m.pages, q.matches)), nil
+}
+
+// SaveScan saves the scan result on the page and on the run's observation of it
+func (m *MemoryStore) SaveScan(filePath string, result string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, pg := range m.pages {
+		if pg.File_path != filePath {
+			continue
+		}
+		pg.Scan_results = result
+		if observed, ok := m.observations[pg.Run_id][pg.Url]; ok {
+			observed.Scan_results = result
+		}
+	}
+	return nil
+}
+
+// SaveLink records a link found on a page during a crawl run
+func (m *MemoryStore) SaveLink(runID int64, fromURL, toURL string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.links = append(m.links, Links{Id: m.id(), From_url: fromURL, To_url: toURL, Run_id: runID})
+	return nil
+}
+
+// StartRun records the start of a crawl and returns the id of the new run
+func (m *MemoryStore) StartRun(startURL string, config string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	run := CrawlRuns{Id: int64(len(m.runs) + 1), Start_url: startURL, Config: config, Started_at: time.Now()}
+	m.runs = append(m.runs, run)
+	return run.Id, nil
+}
+
+// FinishRun records how a crawl ended
+func (m *MemoryStore) FinishRun(runID int64, outcome string, budget string, pages int, bytes int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if runID < 1 || int(runID) > len(m.runs) {
+		return nil
+	}
+	run := &m.runs[runID-1]
+	run.Finished_at = sql.NullTime{Time: time.Now(), Valid: true}
+	run.Outcome = outcome
+	run.Budget = budget
+	run.Pages_fetched = pages
+	run.Bytes_fetched = bytes
+	return nil
+}
+
+// GetRuns returns every crawl run, newest first
+func (m *MemoryStore) GetRuns() ([]CrawlRuns, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	runs := make([]CrawlRuns, 0, len(m.runs))
+	for i := len(m.runs) - 1; i >= 0; i-- {
+		runs = append(runs, m.runs[i])
+	}
+	return runs, nil
+}
+
+// GetRun returns a single crawl run
+func (m *MemoryStore) GetRun(runID int64) (CrawlRuns, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if runID < 1 || int(runID) > len(m.runs) {
+		return CrawlRuns{}, fmt.Errorf("no crawl run with id %d", runID)
+	}
+	return m.runs[runID-1], nil
+}
+
+// GetRunPages returns the pages as they were seen by one crawl run
+func (m *MemoryStore) GetRunPages(runID int64) ([]Pages, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return sortedPages(m.observations[runID], nil), nil
+}
+
+// GetRunLinks returns the links found during one crawl run
+func (m *MemoryStore) GetRunLinks(runID int64) ([]Links, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	links := make([]Links, 0)
+	for _, link := range m.links {
+		if link.Run_id == runID {
+			links = append(links, link)
+		}
+	}
+	return links, nil
+}
+
+// StartScanRun records the start of an accessibility scan and returns the id
+// of the new scan run
+func (m *MemoryStore) StartScanRun() (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	run := ScanRuns{Id: int64(len(m.scanRuns) + 1), Started_at: time.Now()}
+	m.scanRuns = append(m.scanRuns, run)
+	return run.Id, nil
+}
+
+// FinishScanRun records that a scan ended and what it found
+func (m *MemoryStore) FinishScanRun(scanRunID int64, pages int, issues int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if scanRunID < 1 || int(scanRunID) > len(m.scanRuns) {
+		return nil
+	}
+	run := &m.scanRuns[scanRunID-1]
+	run.Finished_at = sql.NullTime{Time: time.Now(), Valid: true}
+	run.Pages_scanned = pages
+	run.Issues_found = issues
+	return nil
+}
+
+// GetScanRuns returns every scan run, newest first
+func (m *MemoryStore) GetScanRuns() ([]ScanRuns, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	runs := make([]ScanRuns, 0, len(m.scanRuns))
+	for i := len(m.scanRuns) - 1; i >= 0; i-- {
+		runs = append(runs, m.scanRuns[i])
+	}
+	return runs, nil
+}
+
+// SaveIssues replaces the issues a scan run found on a page
+func (m *MemoryStore) SaveIssues(scanRunID int64, pageURL string, issues []Issues) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	kept := m.issues[:0]
+	for _, issue := range m.issues {
+		if issue.Scan_run_id != scanRunID || issue.Page_url != pageURL {
+			kept = append(kept, issue)
+		}
+	}
+	m.issues = kept
+	for _, issue := range issues {
+		issue.Id = int64(m.id())
+		issue.Scan_run_id = scanRunID
+		issue.Page_url = pageURL
+		issue.Host = HostOf(pageURL)
+		m.issues = append(m.issues, issue)
+	}
+	return nil
+}
+
+// SavePageScan records how a scan run's scan of a page went, and keeps the
+// status on the page
+func (m *MemoryStore) SavePageScan(ps PageScans) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ps.Scanned_at = time.Now()
+	if m.pageScans[ps.Scan_run_id] == nil {
+		m.pageScans[ps.Scan_run_id] = make(map[string]PageScans)
+	}
+	m.pageScans[ps.Scan_run_id][ps.Page_url] = ps
+	if pg, ok := m.pages[ps.Page_url]; ok {
+		pg.Scan_status = ps.Status
+	}
+	return nil
+}
+
+// GetPageScans returns how a scan run's scan of each page went, in URL order
+func (m *MemoryStore) GetPageScans(scanRunID int64) ([]PageScans, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	scans := make([]PageScans, 0, len(m.pageScans[scanRunID]))
+	for _, ps := range m.pageScans[scanRunID] {
+		scans = append(scans, ps)
+	}
+	sort.Slice(scans, func(i, j int) bool { return scans[i].Page_url < scans[j].Page_url })
+	return scans, nil
+}
+
+// GetLastPageScans returns how the latest scan of each page went, whichever
+// scan run it was in, in URL order
+func (m *MemoryStore) GetLastPageScans() ([]PageScans, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	last := make(map[string]PageScans)
+	for _, run := range m.pageScans {
+		for url, ps := range run {
+			if prev, ok := last[url]; !ok || ps.Scan_run_id > prev.Scan_run_id {
+				last[url] = ps
+			}
+		}
+	}
+	scans := make([]PageScans, 0, len(last))
+	for _, ps := range last {
+		scans = append(scans, ps)
+	}
+	sort.Slice(scans, func(i, j int) bool { return scans[i].Page_url < scans[j].Page_url })
+	return scans, nil
+}
+
+// QueryIssues returns the issues that match the query, see IssueQuery
+func (m *MemoryStore) QueryIssues(q IssueQuery) ([]Issues, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	issues := make([]Issues, 0)
+	for _, issue := range m.issues {
+		if q.matches(issue) {
+			issues = append(issues, issue)
+		}
+	}
+	return q.page(issues), nil
+}
+
+// AddToFrontier records that a run queued the URL
+func (m *MemoryStore) AddToFrontier(runID int64, rawURL string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.frontierFor(runID).add(rawURL)
+	return nil
+}
+
+// MarkFetched records that a run has fetched the URL
+func (m *MemoryStore) MarkFetched(runID int64, rawURL string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f := m.frontierFor(runID)
+	f.add(rawURL)
+	f.fetched[rawURL] = true
+	return nil
+}
+
+// GetFrontier returns the URLs a run queued but has not fetched, in queue order
+func (m *MemoryStore) GetFrontier(runID int64) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	pending := make([]string, 0)
+	if f, ok := m.frontier[runID]; ok {
+		for _, u := range f.order {
+			if !f.fetched[u] {
+				pending = append(pending, u)
+			}
+		}
+	}
+	return pending, nil
+}
+
+// Flush has nothing to do, writes are never buffered
+func (m *MemoryStore) Flush() error {
+	return nil
+}
+
+// Close has nothing to release
+func (m *MemoryStore) Close() error {
+	return nil
+}
+
+// id hands out ids, caller must hold the lock
+func (m *MemoryStore) id() int {
+	m.nextID++
+	return m.nextID
+}
+
+// frontierFor returns the run's frontier, caller must hold the lock
+func (m *MemoryStore) frontierFor(runID int64) *memoryFrontier {
+	f, ok := m.frontier[runID]
+	if !ok {
+		f = &memoryFrontier{fetched: make(map[string]bool)}
+		m.frontier[runID] = f
+	}
+	return f
+}
+
+func (f *memoryFrontier) add(rawURL string) {
+	if _, ok := f.fetched[rawURL]; !ok {
+		f.fetched[rawURL] = false
+		f.order = append(f.order, rawURL)
+	}
+}
+
+// sortedPages copies the pages that pass keep, or all of them when keep is
+// nil, in id order
+func sortedPages(pages map[string]*Pages, keep func(Pages) bool) []Pages {
+	out := make([]Pages, 0, len(pages))
+	for _, pg := range pages {
+		if keep == nil || keep(*pg) {
+			out = append(out, *pg)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Id < out[j].Id })
+	return out
+}
@@ -0,0 +1,439 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// migration is one step in the schema's history. Steps are applied in order
+// and each is recorded in schema_version once it has run. Databases made
+// before migrations existed may already have some of the columns, so steps
+// add tables and columns only when they are missing.
+type migration struct {
+	version int
+	name    string
+	up      func(tx *sql.Tx) error
+}
+
+// migrations is the full schema history. Never change a step that has been
+// released, add a new one to the end instead.
+var migrations = []migration{
+	{1, "create pages and links", func(tx *sql.Tx) error {
+		return execAll(tx, `
+		CREATE TABLE IF NOT EXISTS pages (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			url TEXT NOT NULL UNIQUE,
+			status_code INTEGER,
+			content_type TEXT,
+			file_path TEXT,
+			fetched_at DATETIME,
+			scan_results TEXT
+		)`, `
+		CREATE TABLE IF NOT EXISTS links (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			from_url TEXT NOT NULL,
+			to_url TEXT NOT NULL
+		)`)
+	}},
+	{2, "create crawl runs", func(tx *sql.Tx) error {
+		return execAll(tx, `
+		CREATE TABLE IF NOT EXISTS crawl_runs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			start_url TEXT NOT NULL,
+			started_at DATETIME,
+			finished_at DATETIME,
+			outcome TEXT,
+			budget TEXT,
+			pages_fetched INTEGER DEFAULT 0,
+			bytes_fetched INTEGER DEFAULT 0
+		)`)
+	}},
+	{3, "add page sizes and body stored flag", func(tx *sql.Tx) error {
+		return addColumns(tx, "pages",
+			"declared_size", "INTEGER DEFAULT -1",
+			"actual_size", "INTEGER DEFAULT 0",
+			"body_stored", "INTEGER DEFAULT 1",
+		)
+	}},
+	{4, "add WARC locations to pages", func(tx *sql.Tx) error {
+		return addColumns(tx, "pages",
+			"warc_filename", "TEXT DEFAULT ''",
+			"warc_offset", "INTEGER DEFAULT 0",
+		)
+	}},
+	{5, "add page charset", func(tx *sql.Tx) error {
+		return addColumns(tx, "pages", "charset", "TEXT DEFAULT ''")
+	}},
+	{6, "add crawl run history", func(tx *sql.Tx) error {
+		if err := addColumns(tx, "pages",
+			"content_hash", "TEXT DEFAULT ''",
+			"run_id", "INTEGER DEFAULT 0",
+		); err != nil {
+			return err
+		}
+		if err := addColumns(tx, "links", "run_id", "INTEGER DEFAULT 0"); err != nil {
+			return err
+		}
+		if err := addColumns(tx, "crawl_runs", "config", "TEXT DEFAULT ''"); err != nil {
+			return err
+		}
+		return execAll(tx, `
+		CREATE TABLE IF NOT EXISTS page_observations (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			run_id INTEGER NOT NULL,
+			url TEXT NOT NULL,
+			status_code INTEGER,
+			content_type TEXT,
+			file_path TEXT,
+			fetched_at DATETIME,
+			scan_results TEXT DEFAULT '',
+			declared_size INTEGER DEFAULT -1,
+			actual_size INTEGER DEFAULT 0,
+			body_stored INTEGER DEFAULT 1,
+			warc_filename TEXT DEFAULT '',
+			warc_offset INTEGER DEFAULT 0,
+			charset TEXT DEFAULT '',
+			content_hash TEXT DEFAULT '',
+			UNIQUE(run_id, url)
+		)`)
+	}},
+	{7, "add link and file path indexes", func(tx *sql.Tx) error {
+		return execAll(tx,
+			"CREATE INDEX IF NOT EXISTS idx_links_from_url ON links (from_url)",
+			"CREATE INDEX IF NOT EXISTS idx_links_to_url ON links (to_url)",
+			"CREATE INDEX IF NOT EXISTS idx_links_run_id ON links (run_id)",
+			"CREATE INDEX IF NOT EXISTS idx_pages_file_path ON pages (file_path)",
+		)
+	}},
+	{8, "create crawl frontier", func(tx *sql.Tx) error {
+		return execAll(tx, `
+		CREATE TABLE IF NOT EXISTS crawl_frontier (
+			run_id INTEGER NOT NULL,
+			url TEXT NOT NULL,
+			fetched INTEGER DEFAULT 0,
+			PRIMARY KEY (run_id, url)
+		)`)
+	}},
+	{9, "add page hosts and rewrite fetch times", func(tx *sql.Tx) error {
+		for _, table := range []string{"pages", "page_observations"} {
+			if err := addColumns(tx, table, "host", "TEXT DEFAULT ''"); err != nil {
+				return err
+			}
+			if err := backfillHosts(tx, table); err != nil {
+				return err
+			}
+		}
+		return execAll(tx, "CREATE INDEX IF NOT EXISTS idx_pages_host ON pages (host)")
+	}},
+	{10, "add response headers and timing", func(tx *sql.Tx) error {
+		for _, table := range []string{"pages", "page_observations"} {
+			if err := addColumns(tx, table,
+				"headers", "TEXT DEFAULT ''",
+				"dns_ms", "REAL DEFAULT 0",
+				"connect_ms", "REAL DEFAULT 0",
+				"tls_ms", "REAL DEFAULT 0",
+				"ttfb_ms", "REAL DEFAULT 0",
+				"total_ms", "REAL DEFAULT 0",
+			); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{11, "add redirects", func(tx *sql.Tx) error {
+		for _, table := range []string{"pages", "page_observations"} {
+			if err := addColumns(tx, table,
+				"final_url", "TEXT DEFAULT ''",
+				"redirect_status", "INTEGER DEFAULT 0",
+				"redirect_hops", "INTEGER DEFAULT 0",
+			); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{12, "create scan runs and issues", func(tx *sql.Tx) error {
+		return execAll(tx, `
+		CREATE TABLE IF NOT EXISTS scan_runs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			started_at DATETIME,
+			finished_at DATETIME,
+			pages_scanned INTEGER DEFAULT 0,
+			issues_found INTEGER DEFAULT 0
+		)`, `
+		CREATE TABLE IF NOT EXISTS issues (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			scan_run_id INTEGER NOT NULL,
+			page_url TEXT NOT NULL,
+			host TEXT DEFAULT '',
+			code TEXT DEFAULT '',
+			type TEXT DEFAULT '',
+			message TEXT DEFAULT '',
+			selector TEXT DEFAULT '',
+			context TEXT DEFAULT '',
+			runner TEXT DEFAULT ''
+		)`,
+			"CREATE INDEX IF NOT EXISTS idx_issues_scan_page ON issues (scan_run_id, page_url)",
+			"CREATE INDEX IF NOT EXISTS idx_issues_page_url ON issues (page_url)",
+			"CREATE INDEX IF NOT EXISTS idx_issues_code ON issues (code)",
+		)
+	}},
+	{13, "add scan status", func(tx *sql.Tx) error {
+		for _, table := range []string{"pages", "page_observations"} {
+			if err := addColumns(tx, table, "scan_status", "TEXT DEFAULT ''"); err != nil {
+				return err
+			}
+		}
+		return execAll(tx, `
+		CREATE TABLE IF NOT EXISTS page_scans (
+			scan_run_id INTEGER NOT NULL,
+			page_url TEXT NOT NULL,
+			status TEXT NOT NULL,
+			error TEXT DEFAULT '',
+			issues INTEGER DEFAULT 0,
+			content_hash TEXT DEFAULT '',
+			scanned_at DATETIME,
+			PRIMARY KEY (scan_run_id, page_url)
+		)`)
+	}},
+	{14, "add scan config hash", func(tx *sql.Tx) error {
+		if err := addColumns(tx, "page_scans", "config_hash", "TEXT DEFAULT ''"); err != nil {
+			return err
+		}
+		return execAll(tx, "CREATE INDEX IF NOT EXISTS idx_page_scans_page_url ON page_scans (page_url, scan_run_id)")
+	}},
+}
+
+// backfillHosts fills in the host column from each URL, and rewrites
+// fetched_at in SQLite's own time format so date filters can compare it
+func backfillHosts(tx *sql.Tx, table string) error {
+	rows, err := tx.Query(fmt.Sprintf("SELECT id, url, fetched_at FROM %s", table))
+	if err != nil {
+		return err
+	}
+	type row struct {
+		id        int64
+		host      string
+		fetchedAt sql.NullTime
+	}
+	var updates []row
+	for rows.Next() {
+		var r row
+		var rawURL string
+		if err := rows.Scan(&r.id, &rawURL, &r.fetchedAt); err != nil {
+			rows.Close()
+			return err
+		}
+		r.host = HostOf(rawURL)
+		updates = append(updates, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(fmt.Sprintf("UPDATE %s SET host = ?, fetched_at = ? WHERE id = ?", table))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, r := range updates {
+		if _, err := stmt.Exec(r.host, r.fetchedAt, r.id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LatestSchemaVersion is the version a database is at once every migration has run
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// MigrationStatus is one migration and whether this database has had it
+type MigrationStatus struct {
+	Version    int
+	Name       string
+	Applied    bool
+	Applied_at time.Time
+}
+
+// SchemaVersion returns the version the database is at, 0 if no migrations have run
+func (s *Storage) SchemaVersion() (int, error) {
+	if err := s.ensureVersionTable(); err != nil {
+		return 0, err
+	}
+	var version int
+	err := s.db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&version)
+	return version, err
+}
+
+// MigrationStatus lists every known migration and whether it has been applied
+func (s *Storage) MigrationStatus() ([]MigrationStatus, error) {
+	if err := s.ensureVersionTable(); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.Query("SELECT version, applied_at FROM schema_version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	status := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		at, ok := applied[m.version]
+		status = append(status, MigrationStatus{Version: m.version, Name: m.name, Applied: ok, Applied_at: at})
+	}
+	return status, nil
+}
+
+// Migrate brings the schema up to date. If there is anything to apply to a
+// database that already holds data, a backup is written next to it first and
+// its path returned. The migrations that ran are returned in order.
+func (s *Storage) Migrate() ([]MigrationStatus, string, error) {
+	s.settle()
+
+	current, err := s.SchemaVersion()
+	if err != nil {
+		return nil, "", err
+	}
+	if current > LatestSchemaVersion() {
+		return nil, "", fmt.Errorf("database schema is at version %d but this build only knows up to %d, use a newer build", current, LatestSchemaVersion())
+	}
+
+	var pending []migration
+	for _, m := range migrations {
+		if m.version > current {
+			pending = append(pending, m)
+		}
+	}
+	if len(pending) == 0 {
+		return nil, "", nil
+	}
+
+	backup := ""
+	hasData, err := s.hasTables()
+	if err != nil {
+		return nil, "", err
+	}
+	if hasData && s.path != "" {
+		backup = fmt.Sprintf("%s.v%d-%s.bak", s.path, current, time.Now().Format("20060102150405"))
+		if _, err := s.db.Exec("VACUUM INTO ?", backup); err != nil {
+			return nil, "", fmt.Errorf("failed to back up database before migrating: %w", err)
+		}
+	}
+
+	applied := make([]MigrationStatus, 0, len(pending))
+	for _, m := range pending {
+		at, err := s.apply(m)
+		if err != nil {
+			return applied, backup, fmt.Errorf("migration %d (%s) failed: %w", m.version, m.name, err)
+		}
+		applied = append(applied, MigrationStatus{Version: m.version, Name: m.name, Applied: true, Applied_at: at})
+	}
+	return applied, backup, nil
+}
+
+// apply runs one migration and records it, all or nothing
+func (s *Storage) apply(m migration) (time.Time, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer tx.Rollback()
+
+	if err := m.up(tx); err != nil {
+		return time.Time{}, err
+	}
+	at := time.Now()
+	if _, err := tx.Exec("INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)", m.version, m.name, at); err != nil {
+		return time.Time{}, err
+	}
+	return at, tx.Commit()
+}
+
+func (s *Storage) ensureVersionTable() error {
+	_, err := s.db.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_version (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at DATETIME
+	)`)
+	return err
+}
+
+// hasTables reports if the database holds anything besides schema_version
+func (s *Storage) hasTables() (bool, error) {
+	var n int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name NOT IN ('schema_version', 'sqlite_sequence')").Scan(&n)
+	return n > 0, err
+}
+
+func execAll(tx *sql.Tx, statements ...string) error {
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addColumns adds each column and definition pair to the table, skipping
+// columns it already has.
+func addColumns(tx *sql.Tx, table string, columnDefs ...string) error {
+	existing, err := columnNames(tx, table)
+	if err != nil {
+		return err
+	}
+	for i := 0; i+1 < len(columnDefs); i += 2 {
+		column, definition := columnDefs[i], columnDefs[i+1]
+		if existing[strings.ToLower(column)] {
+			continue
+		}
+		if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// columnNames returns the lower cased names of the table's columns
+func columnNames(tx *sql.Tx, table string) (map[string]bool, error) {
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := make(map[string]bool)
+	for rows.Next() {
+		var (
+			cid        int
+			name       string
+			colType    string
+			notNull    int
+			defaultVal sql.NullString
+			primaryKey int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultVal, &primaryKey); err != nil {
+			return nil, err
+		}
+		names[strings.ToLower(name)] = true
+	}
+	return names, rows.Err()
+}
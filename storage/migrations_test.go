@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewMigratesLegacyDatabase(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "legacy.db")
+
+	// The schema New used to create, before migrations existed
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`
+	CREATE TABLE pages (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		url TEXT NOT NULL UNIQUE,
+		status_code INTEGER,
+		content_type TEXT,
+		file_path TEXT,
+		fetched_at DATETIME,
+		scan_results TEXT
+	);
+	CREATE TABLE links (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		from_url TEXT NOT NULL,
+		to_url TEXT NOT NULL
+	);
+	ALTER TABLE pages ADD COLUMN declared_size INTEGER DEFAULT -1;
+	INSERT INTO pages (url, status_code, content_type, file_path, fetched_at, scan_results)
+	VALUES ('https://example.com/', 200, 'text/html', '_output/example.com/index.html', '2024-01-02 03:04:05.123456789 +0000 UTC m=+0.012345678', '[]');
+	`)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := New(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	version, err := s.SchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != LatestSchemaVersion() {
+		t.Errorf("SchemaVersion() = %d, want %d", version, LatestSchemaVersion())
+	}
+
+	pages, err := s.GetPages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 1 || pages[0].Url != "https://example.com/" || pages[0].Declared_size != -1 || !pages[0].Body_stored {
+		t.Errorf("existing page not kept with defaults: %+v", pages)
+	}
+
+	if pages[0].Host != "example.com" {
+		t.Errorf("host not filled in from the URL, got %q", pages[0].Host)
+	}
+	// Old fetch times are rewritten so date filters can read them
+	old, err := s.QueryPages(PageQuery{FetchedBefore: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(old) != 1 {
+		t.Errorf("QueryPages() by date found %d pages, want 1", len(old))
+	}
+
+	backups, _ := filepath.Glob(dbPath + ".v0-*.bak")
+	if len(backups) != 1 {
+		t.Fatalf("expected one backup, found %v", backups)
+	}
+
+	// Opening again has nothing to do
+	applied, backup, err := s.Migrate()
+	if err != nil || len(applied) != 0 || backup != "" {
+		t.Errorf("second Migrate() = %v, %q, %v", applied, backup, err)
+	}
+}
+
+func TestNewDatabaseSkipsBackup(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "new.db")
+	s, err := New(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if backups, _ := filepath.Glob(dbPath + ".*.bak"); len(backups) != 0 {
+		t.Errorf("expected no backup of a new database, found %v", backups)
+	}
+
+	status, err := s.MigrationStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range status {
+		if !m.Applied {
+			t.Errorf("migration %d (%s) not applied", m.Version, m.Name)
+		}
+	}
+}
+
+func TestMigrateRefusesNewerSchema(t *testing.T) {
+	s, err := New(filepath.Join(t.TempDir(), "future.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if _, err := s.db.Exec("INSERT INTO schema_version (version, name) VALUES (?, 'from the future')", LatestSchemaVersion()+1); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.Migrate(); err == nil {
+		t.Error("expected an error migrating a database newer than the build")
+	}
+}
@@ -0,0 +1,306 @@
+package storage
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"boem-web-thing/util"
+)
+
+// PageQuery selects page records. Empty fields don't filter, so the zero
+// PageQuery returns every page. Results are in id order.
+type PageQuery struct {
+	Hosts         []string  // any of these hosts, with the port if the URL has one
+	StatusCodes   []int     // any of these status codes
+	ContentTypes  []string  // media types like "text/html" or wildcards like "image/*"
+	PathPrefix    string    // URL paths starting with this, like "/news/"
+	FetchedAfter  time.Time // fetched at or after this time
+	FetchedBefore time.Time // fetched before this time
+	RunID         int64     // last fetched by this crawl run
+	ScanState     ScanState
+	ScanStatuses  []string // last scanned with any of these statuses, like ScanFailed
+	MinTotalMs    float64  // took at least this long to fetch, to find slow pages
+	Limit         int      // at most this many pages, 0 for no limit
+	Offset        int      // skip this many pages first, for paging through results
+}
+
+// ScanState filters pages on whether they have been scanned
+type ScanState string
+
+const (
+	ScanStateAny       ScanState = ""
+	ScanStateScanned   ScanState = "scanned"
+	ScanStateUnscanned ScanState = "unscanned"
+)
+
+// HostOf returns the lower cased host (and port) of a URL, as stored in the
+// host column
+func HostOf(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(parsed.Host)
+}
+
+// matches reports if the page passes every filter except the paging, for the
+// backends that filter in Go
+func (q PageQuery) matches(pg Pages) bool {
+	if len(q.Hosts) > 0 && !containsFold(q.Hosts, pg.Host) {
+		return false
+	}
+	if len(q.StatusCodes) > 0 && !containsInt(q.StatusCodes, pg.Status_code) {
+		return false
+	}
+	if len(q.ContentTypes) > 0 && !util.MatchContentType(pg.Content_type, q.ContentTypes) {
+		return false
+	}
+	if q.PathPrefix != "" {
+		parsed, err := url.Parse(pg.Url)
+		if err != nil || !strings.HasPrefix(parsed.EscapedPath(), q.PathPrefix) {
+			return false
+		}
+	}
+	if !q.FetchedAfter.IsZero() && pg.Fetched_at.Before(q.FetchedAfter) {
+		return false
+	}
+	if !q.FetchedBefore.IsZero() && !pg.Fetched_at.Before(q.FetchedBefore) {
+		return false
+	}
+	if q.RunID != 0 && pg.Run_id != q.RunID {
+		return false
+	}
+	if q.MinTotalMs > 0 && pg.Total_ms < q.MinTotalMs {
+		return false
+	}
+	if len(q.ScanStatuses) > 0 && !contains(q.ScanStatuses, pg.Scan_status) {
+		return false
+	}
+	switch q.ScanState {
+	case ScanStateScanned:
+		return pg.Scan_results != ""
+	case ScanStateUnscanned:
+		return pg.Scan_results == ""
+	}
+	return true
+}
+
+// page applies Offset and Limit to pages that already passed the filters
+func (q PageQuery) page(pages []Pages) []Pages {
+	if q.Offset > 0 {
+		if q.Offset >= len(pages) {
+			return pages[:0]
+		}
+		pages = pages[q.Offset:]
+	}
+	if q.Limit > 0 && q.Limit < len(pages) {
+		pages = pages[:q.Limit]
+	}
+	return pages
+}
+
+// where builds the SQL conditions and arguments for the filters
+func (q PageQuery) where() (string, []any) {
+	var conds []string
+	var args []any
+
+	if len(q.Hosts) > 0 {
+		conds = append(conds, "host IN ("+placeholders(len(q.Hosts))+")")
+		for _, host := range q.Hosts {
+			args = append(args, strings.ToLower(host))
+		}
+	}
+	if len(q.StatusCodes) > 0 {
+		conds = append(conds, "status_code IN ("+placeholders(len(q.StatusCodes))+")")
+		for _, code := range q.StatusCodes {
+			args = append(args, code)
+		}
+	}
+	if len(q.ContentTypes) > 0 {
+		var alternatives []string
+		for _, pattern := range q.ContentTypes {
+			pattern = strings.ToLower(strings.TrimSpace(pattern))
+			if pattern == "*" || pattern == "*/*" {
+				alternatives = append(alternatives, "1")
+			} else if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+				alternatives = append(alternatives, `lower(content_type) LIKE ? ESCAPE '\'`)
+				args = append(args, escapeLike(prefix)+"/%")
+			} else {
+				alternatives = append(alternatives, `(lower(trim(content_type)) = ? OR lower(content_type) LIKE ? ESCAPE '\')`)
+				args = append(args, pattern, escapeLike(pattern)+";%")
+			}
+		}
+		conds = append(conds, "("+strings.Join(alternatives, " OR ")+")")
+	}
+	if q.PathPrefix != "" {
+		// The path starts straight after scheme://host
+		conds = append(conds, "substr(url, instr(url, '://') + 3 + length(host), ?) = ?")
+		args = append(args, utf8.RuneCountInString(q.PathPrefix), q.PathPrefix)
+	}
+	if !q.FetchedAfter.IsZero() {
+		conds = append(conds, "julianday(fetched_at) >= julianday(?)")
+		args = append(args, q.FetchedAfter)
+	}
+	if !q.FetchedBefore.IsZero() {
+		conds = append(conds, "julianday(fetched_at) < julianday(?)")
+		args = append(args, q.FetchedBefore)
+	}
+	if q.RunID != 0 {
+		conds = append(conds, "run_id = ?")
+		args = append(args, q.RunID)
+	}
+	if q.MinTotalMs > 0 {
+		conds = append(conds, "total_ms >= ?")
+		args = append(args, q.MinTotalMs)
+	}
+	if len(q.ScanStatuses) > 0 {
+		conds = append(conds, "scan_status IN ("+placeholders(len(q.ScanStatuses))+")")
+		for _, status := range q.ScanStatuses {
+			args = append(args, status)
+		}
+	}
+	switch q.ScanState {
+	case ScanStateScanned:
+		conds = append(conds, "COALESCE(scan_results, '') != ''")
+	case ScanStateUnscanned:
+		conds = append(conds, "COALESCE(scan_results, '') = ''")
+	}
+
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// limit builds the SQL LIMIT and OFFSET for the paging
+func (q PageQuery) limit() string {
+	return limitClause(q.Limit, q.Offset)
+}
+
+// IssueQuery selects accessibility issues. Like PageQuery, empty fields don't
+// filter. Results are in the order the issues were saved.
+type IssueQuery struct {
+	ScanRunID int64    // found by this scan run
+	PageURLs  []string // on any of these pages
+	Hosts     []string // on pages with any of these hosts
+	Codes     []string // any of these rule codes
+	Types     []string // any of these types, like error or warning
+	Runners   []string // found by any of these runners
+	Limit     int
+	Offset    int
+}
+
+// matches reports if the issue passes every filter except the paging
+func (q IssueQuery) matches(issue Issues) bool {
+	switch {
+	case q.ScanRunID != 0 && issue.Scan_run_id != q.ScanRunID,
+		len(q.PageURLs) > 0 && !contains(q.PageURLs, issue.Page_url),
+		len(q.Hosts) > 0 && !containsFold(q.Hosts, issue.Host),
+		len(q.Codes) > 0 && !contains(q.Codes, issue.Code),
+		len(q.Types) > 0 && !containsFold(q.Types, issue.Type),
+		len(q.Runners) > 0 && !containsFold(q.Runners, issue.Runner):
+		return false
+	}
+	return true
+}
+
+// page applies Offset and Limit to issues that already passed the filters
+func (q IssueQuery) page(issues []Issues) []Issues {
+	if q.Offset > 0 {
+		if q.Offset >= len(issues) {
+			return issues[:0]
+		}
+		issues = issues[q.Offset:]
+	}
+	if q.Limit > 0 && q.Limit < len(issues) {
+		issues = issues[:q.Limit]
+	}
+	return issues
+}
+
+// where builds the SQL conditions and arguments for the filters
+func (q IssueQuery) where() (string, []any) {
+	var conds []string
+	var args []any
+
+	in := func(column string, values []string, lower bool) {
+		if len(values) == 0 {
+			return
+		}
+		if lower {
+			column = "lower(" + column + ")"
+		}
+		conds = append(conds, column+" IN ("+placeholders(len(values))+")")
+		for _, v := range values {
+			if lower {
+				v = strings.ToLower(v)
+			}
+			args = append(args, v)
+		}
+	}
+	if q.ScanRunID != 0 {
+		conds = append(conds, "scan_run_id = ?")
+		args = append(args, q.ScanRunID)
+	}
+	in("page_url", q.PageURLs, false)
+	in("host", q.Hosts, true)
+	in("code", q.Codes, false)
+	in("type", q.Types, true)
+	in("runner", q.Runners, true)
+
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// limitClause builds the SQL LIMIT and OFFSET for a page of results
+func limitClause(limit, offset int) string {
+	switch {
+	case limit > 0 && offset > 0:
+		return " LIMIT " + strconv.Itoa(limit) + " OFFSET " + strconv.Itoa(offset)
+	case limit > 0:
+		return " LIMIT " + strconv.Itoa(limit)
+	case offset > 0:
+		return " LIMIT -1 OFFSET " + strconv.Itoa(offset)
+	}
+	return ""
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInt(list []int, n int) bool {
+	for _, item := range list {
+		if item == n {
+			return true
+		}
+	}
+	return false
+}
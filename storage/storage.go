@@ -2,10 +2,11 @@ package storage
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
-	"log"
+	"net/http"
 	"os"
-	"strings"
+	"sync"
 	"time"
 
 	"boem-web-thing/util"
@@ -15,135 +16,617 @@ import (
 
 // Storage wraps the database connection.
 type Storage struct {
-	db *sql.DB
+	db   *sql.DB
+	path string
+
+	// Batched writes, see batch.go
+	writes     chan batchOp
+	writerDone chan struct{}
+	closeMu    sync.RWMutex
+	closed     bool
 }
 
 type Pages struct {
-	Id           int
-	Url          string
-	Status_code  int
-	Content_type string
-	File_path    string
-	Fetched_at   time.Time
-	Scan_results string
+	Id            int
+	Url           string
+	Host          string // lower cased host of the URL, with the port if it has one
+	Status_code   int
+	Content_type  string
+	File_path     string
+	Fetched_at    time.Time
+	Scan_results  string
+	Scan_status   string // how the last scan of the page went, see ScanOK
+	Declared_size int64  // Content-Length from the server, -1 if it did not say
+	Actual_size   int64  // bytes of the body that was stored, 0 when only the metadata was recorded
+	Body_stored   bool   // false when only the metadata was recorded
+	Warc_filename string
+	Warc_offset   int64
+	Charset       string // character set the saved body is in, empty for binary content
+	Content_hash  string // sha256 of the body as downloaded
+	Run_id        int64  // crawl run that last fetched the page
+	Headers       string // response headers as JSON, see Header
+	Dns_ms        float64
+	Connect_ms    float64
+	Tls_ms        float64
+	Ttfb_ms       float64 // time to first byte of the response
+	Total_ms      float64 // from the first request to the end of the body
+
+	// When fetching the URL was redirected, where it ended up, the status of
+	// the first redirect and how many redirects were followed
+	Final_url       string
+	Redirect_status int
+	Redirect_hops   int
+}
+
+// Header decodes the saved response headers
+func (pg Pages) Header() (http.Header, error) {
+	h := http.Header{}
+	if pg.Headers == "" {
+		return h, nil
+	}
+	err := json.Unmarshal([]byte(pg.Headers), &h)
+	return h, err
 }
 
 type Links struct {
 	Id       int
 	From_url string
 	To_url   string
+	Run_id   int64
+}
+
+// CrawlRuns is one crawl, with the config it ran with and how it ended
+type CrawlRuns struct {
+	Id            int64
+	Start_url     string
+	Config        string // JSON snapshot of the config, secrets removed
+	Started_at    time.Time
+	Finished_at   sql.NullTime // not valid while the crawl runs, or if it never finished
+	Outcome       string
+	Budget        string // the budgets that held the crawl back, comma separated, see crawler.Outcome
+	Pages_fetched int
+	Bytes_fetched int64
+}
+
+// ScanRuns is one accessibility scan of the saved pages
+type ScanRuns struct {
+	Id            int64
+	Started_at    time.Time
+	Finished_at   sql.NullTime // not valid while the scan runs, or if it never finished
+	Pages_scanned int          // pages scanned in this run, not counting unchanged ones
+	Issues_found  int          // issues recorded in this run, unchanged pages' included
+}
+
+// Scan statuses of a page
+const (
+	ScanOK        = "ok"        // scanned without issues
+	ScanIssues    = "issues"    // scanned and issues were found
+	ScanFailed    = "failed"    // the scanner didn't finish, like when no browser could start
+	ScanTimedOut  = "timed_out" // the scan took too long and was stopped
+	ScanUnchanged = "unchanged" // not scanned again as it hadn't changed, the last scan's issues were carried over
+)
+
+// PageScans is how one scan run's scan of a page went
+type PageScans struct {
+	Scan_run_id  int64
+	Page_url     string
+	Status       string // see ScanOK
+	Error        string // why the scan failed
+	Issues       int
+	Content_hash string // of the page that was scanned
+	Config_hash  string // of the scanner config it was scanned with
+	Scanned_at   time.Time
+}
+
+// Issues is one accessibility issue a scan found on a page
+type Issues struct {
+	Id          int64
+	Scan_run_id int64
+	Page_url    string
+	Host        string // lower cased host of the page URL, like Pages.Host
+	Code        string // rule that failed, like WCAG2AA.Principle1.Guideline1_1.1_1_1.H37
+	Type        string // error, warning or notice
+	Message     string
+	Selector    string // CSS selector of the element
+	Context     string // HTML of the element, cut short
+	Runner      string // tool that found it, like htmlcs or axe
 }
 
-// New opens (or creates) the SQLite database at the given path.
+// New opens (or creates) the SQLite database at the given path and brings its
+// schema up to date, see migrations.go.
 func New(dbPath string) (*Storage, error) {
+	s, err := Open(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := s.Migrate(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Open opens (or creates) the SQLite database at the given path without
+// touching its schema. Use New unless you are inspecting or migrating it.
+func Open(dbPath string) (*Storage, error) {
 
 	filemode := int(755) //if we are creating te database file, better make it writable
 	if err := util.EnsureFile(dbPath, os.FileMode(filemode)); err != nil {
 		return nil, fmt.Errorf("failed to ensure db file: %w", err)
 	}
 
-	db, err := sql.Open("sqlite", dbPath)
+	// Times are written in SQLite's own format so its date functions can read them
+	db, err := sql.Open("sqlite", dbPath+"?_time_format=sqlite")
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 	db.SetMaxOpenConns(1) // SQLite is single-threaded, so we limit to 1 connection
 
-	// Create tables if they don't exist
-	schema := `
-	CREATE TABLE IF NOT EXISTS pages (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		url TEXT NOT NULL UNIQUE,
-		status_code INTEGER,
-		content_type TEXT,
-		file_path TEXT,
-		fetched_at DATETIME,
-		scan_results TEXT
-	);
-	CREATE TABLE IF NOT EXISTS links (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		from_url TEXT NOT NULL,
-		to_url TEXT NOT NULL
-	);
-	`
-	if _, err := db.Exec(schema); err != nil {
-		return nil, err
+	// WAL lets the batched writes commit without rewriting the whole journal,
+	// and NORMAL sync is safe with it
+	if _, err := db.Exec("PRAGMA journal_mode=WAL; PRAGMA synchronous=NORMAL; PRAGMA busy_timeout=5000"); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to set up database: %w", err)
 	}
 
-	return &Storage{db: db}, nil
+	s := &Storage{db: db, path: dbPath}
+	s.startWriter()
+	return s, nil
 }
 
-// SavePage inserts or updates a page record.
-func (s *Storage) SavePage(url string, status int, contentType string, filePath string) error {
+// SavePage inserts or updates a page record. The pages table holds the latest
+// fetch of each URL, so when the page belongs to a crawl run it is also kept
+// as an observation of that run, which later runs don't overwrite. The write
+// is batched, errors are logged and returned by Flush.
+func (s *Storage) SavePage(pg Pages) error {
+	fetchedAt := time.Now()
+	pg.Host = HostOf(pg.Url)
+	return s.enqueue(func(tx *sql.Tx) error {
+		return savePage(tx, pg, fetchedAt)
+	})
+}
 
-	_, err := s.db.Exec(`
-	INSERT INTO pages (url, status_code, content_type, file_path, fetched_at, scan_results)
-	VALUES (?, ?, ?, ?, ?, ?)
+func savePage(tx *sql.Tx, pg Pages, fetchedAt time.Time) error {
+	_, err := tx.Exec(`
+	INSERT INTO pages (url, host, status_code, content_type, file_path, fetched_at, scan_results, declared_size, actual_size, body_stored, warc_filename, warc_offset, charset, content_hash, run_id, headers, dns_ms, connect_ms, tls_ms, ttfb_ms, total_ms, final_url, redirect_status, redirect_hops)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	ON CONFLICT(url) DO UPDATE SET
+		host=excluded.host,
 		status_code=excluded.status_code,
 		content_type=excluded.content_type,
 		file_path=excluded.file_path,
-		fetched_at=excluded.fetched_at
+		fetched_at=excluded.fetched_at,
+		declared_size=excluded.declared_size,
+		actual_size=excluded.actual_size,
+		body_stored=excluded.body_stored,
+		warc_filename=excluded.warc_filename,
+		warc_offset=excluded.warc_offset,
+		charset=excluded.charset,
+		content_hash=excluded.content_hash,
+		run_id=excluded.run_id,
+		headers=excluded.headers,
+		dns_ms=excluded.dns_ms,
+		connect_ms=excluded.connect_ms,
+		tls_ms=excluded.tls_ms,
+		ttfb_ms=excluded.ttfb_ms,
+		total_ms=excluded.total_ms,
+		final_url=excluded.final_url,
+		redirect_status=excluded.redirect_status,
+		redirect_hops=excluded.redirect_hops
 	`,
-		url, status, contentType, filePath, time.Now(), "",
+		pg.Url, pg.Host, pg.Status_code, pg.Content_type, pg.File_path, fetchedAt, "", pg.Declared_size, pg.Actual_size, pg.Body_stored, pg.Warc_filename, pg.Warc_offset, pg.Charset, pg.Content_hash, pg.Run_id, pg.Headers, pg.Dns_ms, pg.Connect_ms, pg.Tls_ms, pg.Ttfb_ms, pg.Total_ms, pg.Final_url, pg.Redirect_status, pg.Redirect_hops,
 	)
+	if err != nil {
+		return err
+	}
 
-	return err
+	if pg.Run_id != 0 {
+		_, err = tx.Exec(`
+		INSERT OR REPLACE INTO page_observations (run_id, url, host, status_code, content_type, file_path, fetched_at, declared_size, actual_size, body_stored, warc_filename, warc_offset, charset, content_hash, headers, dns_ms, connect_ms, tls_ms, ttfb_ms, total_ms, final_url, redirect_status, redirect_hops)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`,
+			pg.Run_id, pg.Url, pg.Host, pg.Status_code, pg.Content_type, pg.File_path, fetchedAt, pg.Declared_size, pg.Actual_size, pg.Body_stored, pg.Warc_filename, pg.Warc_offset, pg.Charset, pg.Content_hash, pg.Headers, pg.Dns_ms, pg.Connect_ms, pg.Tls_ms, pg.Ttfb_ms, pg.Total_ms, pg.Final_url, pg.Redirect_status, pg.Redirect_hops,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
-// SaveScan updates a page record with it's scan result.
+// SaveScan updates a page record with it's scan result. The observation from
+// the run that fetched the page gets the result too, so it is clear which
+// crawl a scan result came from. The write is batched like SavePage.
 func (s *Storage) SaveScan(filePath string, result string) error {
+	return s.enqueue(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+		UPDATE pages
+		SET scan_results = ?
+		WHERE file_path = ?
+		`,
+			result, filePath)
+		if err != nil {
+			return fmt.Errorf("updating scan result for %s: %w", filePath, err)
+		}
 
-	fmt.Println(filePath, result)
+		_, err = tx.Exec(`
+		UPDATE page_observations
+		SET scan_results = ?
+		WHERE (run_id, url) IN (SELECT run_id, url FROM pages WHERE file_path = ?)
+		`,
+			result, filePath)
+		if err != nil {
+			return fmt.Errorf("updating scan result for %s: %w", filePath, err)
+		}
+		return nil
+	})
+}
 
-	_, err := s.db.Exec(`
-	UPDATE pages
-	SET scan_results = ?
-	WHERE file_path = ?
-	`,
-		result, filePath)
+// SaveLink records a link found on a page during a crawl run. The write is
+// batched like SavePage.
+func (s *Storage) SaveLink(runID int64, fromURL, toURL string) error {
+	return s.enqueue(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+		INSERT INTO links (from_url, to_url, run_id)
+		VALUES (?, ?, ?)
+		`, fromURL, toURL, runID)
+		return err
+	})
+}
+
+// StartRun records the start of a crawl with a snapshot of its config and
+// returns the id of the new run.
+func (s *Storage) StartRun(startURL string, config string) (int64, error) {
+	s.settle()
 
+	res, err := s.db.Exec(`
+	INSERT INTO crawl_runs (start_url, config, started_at)
+	VALUES (?, ?, ?)
+	`, startURL, config, time.Now())
 	if err != nil {
-		log.Printf("Error updating scan result for %s: %v", filePath, err)
+		return 0, err
 	}
+	return res.LastInsertId()
+}
+
+// FinishRun records how a crawl ended. Outcome is completed or budget_exhausted
+// and budget names the limit that was reached, if any.
+func (s *Storage) FinishRun(runID int64, outcome string, budget string, pages int, bytes int64) error {
+	s.settle()
 
+	_, err := s.db.Exec(`
+	UPDATE crawl_runs
+	SET finished_at = ?, outcome = ?, budget = ?, pages_fetched = ?, bytes_fetched = ?
+	WHERE id = ?
+	`, time.Now(), outcome, budget, pages, bytes, runID)
 	return err
 }
 
-// SaveLink records a link found on a page.
-func (s *Storage) SaveLink(fromURL, toURL string) error {
+// AddToFrontier records that a run queued the URL. The write is batched like
+// SavePage.
+func (s *Storage) AddToFrontier(runID int64, rawURL string) error {
+	return s.enqueue(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+		INSERT OR IGNORE INTO crawl_frontier (run_id, url)
+		VALUES (?, ?)
+		`, runID, rawURL)
+		return err
+	})
+}
+
+// MarkFetched records that a run has fetched the URL. The write is batched
+// like SavePage.
+func (s *Storage) MarkFetched(runID int64, rawURL string) error {
+	return s.enqueue(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+		INSERT INTO crawl_frontier (run_id, url, fetched)
+		VALUES (?, ?, 1)
+		ON CONFLICT(run_id, url) DO UPDATE SET fetched = 1
+		`, runID, rawURL)
+		return err
+	})
+}
+
+// GetFrontier returns the URLs a run queued but has not fetched, in queue order
+func (s *Storage) GetFrontier(runID int64) ([]string, error) {
+	s.settle()
+
+	rows, err := s.db.Query("SELECT url FROM crawl_frontier WHERE run_id = ? AND fetched = 0 ORDER BY rowid", runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	urls := make([]string, 0)
+	for rows.Next() {
+		var u string
+		if err := rows.Scan(&u); err != nil {
+			return nil, err
+		}
+		urls = append(urls, u)
+	}
+	return urls, rows.Err()
+}
+
+// StartScanRun records the start of an accessibility scan and returns the id
+// of the new scan run
+func (s *Storage) StartScanRun() (int64, error) {
+	s.settle()
+
+	res, err := s.db.Exec("INSERT INTO scan_runs (started_at) VALUES (?)", time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// FinishScanRun records that a scan ended and what it found
+func (s *Storage) FinishScanRun(scanRunID int64, pages int, issues int) error {
+	s.settle()
+
 	_, err := s.db.Exec(`
-	INSERT INTO links (from_url, to_url)
-	VALUES (?, ?)
-	`, fromURL, toURL)
+	UPDATE scan_runs
+	SET finished_at = ?, pages_scanned = ?, issues_found = ?
+	WHERE id = ?
+	`, time.Now(), pages, issues, scanRunID)
 	return err
 }
 
-// Get all the output paths for the pages stored based on the configuration files
-// list of allowed hosts.
-func (s *Storage) GetPagesByAllowedHosts(allowedHost []string) ([]Pages, error) {
+// GetScanRuns returns every scan run, newest first
+func (s *Storage) GetScanRuns() ([]ScanRuns, error) {
+	s.settle()
 
-	filePaths := make([]Pages, 0)
-	query := "SELECT id, url, status_code, content_type, file_path, fetched_at, scan_results FROM pages WHERE file_path like ?"
-	allowed_hosts := "%" + strings.Join(allowedHost, "','") + "%"
-	rows, err := s.db.Query(query, allowed_hosts)
+	rows, err := s.db.Query("SELECT id, started_at, finished_at, pages_scanned, issues_found FROM scan_runs ORDER BY id DESC")
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
+	runs := make([]ScanRuns, 0)
 	for rows.Next() {
-		item := Pages{}
-		err2 := rows.Scan(&item.Id, &item.Url, &item.Status_code, &item.Content_type, &item.File_path, &item.Fetched_at, &item.Scan_results)
-		if err2 != nil {
-			panic(err2)
+		run := ScanRuns{}
+		if err := rows.Scan(&run.Id, &run.Started_at, &run.Finished_at, &run.Pages_scanned, &run.Issues_found); err != nil {
+			return nil, err
 		}
-		filePaths = append(filePaths, item)
+		runs = append(runs, run)
 	}
+	return runs, rows.Err()
+}
+
+// SaveIssues replaces the issues a scan run found on a page. The write is
+// batched like SavePage.
+func (s *Storage) SaveIssues(scanRunID int64, pageURL string, issues []Issues) error {
+	host := HostOf(pageURL)
+	return s.enqueue(func(tx *sql.Tx) error {
+		if _, err := tx.Exec("DELETE FROM issues WHERE scan_run_id = ? AND page_url = ?", scanRunID, pageURL); err != nil {
+			return fmt.Errorf("clearing issues for %s: %w", pageURL, err)
+		}
+		for _, issue := range issues {
+			_, err := tx.Exec(`
+			INSERT INTO issues (scan_run_id, page_url, host, code, type, message, selector, context, runner)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+			`, scanRunID, pageURL, host, issue.Code, issue.Type, issue.Message, issue.Selector, issue.Context, issue.Runner)
+			if err != nil {
+				return fmt.Errorf("saving issues for %s: %w", pageURL, err)
+			}
+		}
+		return nil
+	})
+}
 
-	return filePaths, nil
+// SavePageScan records how a scan run's scan of a page went, and keeps the
+// status on the page so failed scans can be found and retried. The write is
+// batched like SavePage.
+func (s *Storage) SavePageScan(ps PageScans) error {
+	scannedAt := time.Now()
+	return s.enqueue(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+		INSERT OR REPLACE INTO page_scans (scan_run_id, page_url, status, error, issues, content_hash, config_hash, scanned_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		`, ps.Scan_run_id, ps.Page_url, ps.Status, ps.Error, ps.Issues, ps.Content_hash, ps.Config_hash, scannedAt)
+		if err != nil {
+			return fmt.Errorf("saving scan of %s: %w", ps.Page_url, err)
+		}
+		if _, err := tx.Exec("UPDATE pages SET scan_status = ? WHERE url = ?", ps.Status, ps.Page_url); err != nil {
+			return fmt.Errorf("saving scan status of %s: %w", ps.Page_url, err)
+		}
+		return nil
+	})
+}
+
+// GetPageScans returns how a scan run's scan of each page went, in URL order
+func (s *Storage) GetPageScans(scanRunID int64) ([]PageScans, error) {
+	s.settle()
+
+	return s.queryPageScans(`
+	SELECT scan_run_id, page_url, status, error, issues, content_hash, config_hash, scanned_at
+	FROM page_scans WHERE scan_run_id = ? ORDER BY page_url
+	`, scanRunID)
+}
+
+// GetLastPageScans returns how the latest scan of each page went, whichever
+// scan run it was in, in URL order
+func (s *Storage) GetLastPageScans() ([]PageScans, error) {
+	s.settle()
+
+	return s.queryPageScans(`
+	SELECT scan_run_id, page_url, status, error, issues, content_hash, config_hash, scanned_at
+	FROM page_scans ps
+	WHERE scan_run_id = (SELECT MAX(scan_run_id) FROM page_scans WHERE page_url = ps.page_url)
+	ORDER BY page_url
+	`)
+}
+
+func (s *Storage) queryPageScans(query string, args ...any) ([]PageScans, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	scans := make([]PageScans, 0)
+	for rows.Next() {
+		ps := PageScans{}
+		if err := rows.Scan(&ps.Scan_run_id, &ps.Page_url, &ps.Status, &ps.Error, &ps.Issues, &ps.Content_hash, &ps.Config_hash, &ps.Scanned_at); err != nil {
+			return nil, err
+		}
+		scans = append(scans, ps)
+	}
+	return scans, rows.Err()
+}
+
+// QueryIssues returns the issues that match the query, see IssueQuery
+func (s *Storage) QueryIssues(q IssueQuery) ([]Issues, error) {
+	s.settle()
+
+	where, args := q.where()
+	rows, err := s.db.Query("SELECT id, scan_run_id, page_url, host, code, type, message, selector, context, runner FROM issues"+
+		where+" ORDER BY id"+limitClause(q.Limit, q.Offset), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	issues := make([]Issues, 0)
+	for rows.Next() {
+		item := Issues{}
+		err := rows.Scan(&item.Id, &item.Scan_run_id, &item.Page_url, &item.Host, &item.Code, &item.Type, &item.Message, &item.Selector, &item.Context, &item.Runner)
+		if err != nil {
+			return nil, err
+		}
+		issues = append(issues, item)
+	}
+	return issues, rows.Err()
+}
+
+// pageFields are the columns read into a Pages, in the order scanPages expects
+const pageFields = "id, url, host, status_code, content_type, file_path, fetched_at, scan_results, declared_size, actual_size, body_stored, warc_filename, warc_offset, charset, content_hash, run_id, headers, dns_ms, connect_ms, tls_ms, ttfb_ms, total_ms, final_url, redirect_status, redirect_hops, scan_status"
+
+// QueryPages returns the pages that match the query, see PageQuery
+func (s *Storage) QueryPages(q PageQuery) ([]Pages, error) {
+	s.settle()
+
+	where, args := q.where()
+	rows, err := s.db.Query("SELECT "+pageFields+" FROM pages"+where+" ORDER BY id"+q.limit(), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanPages(rows)
+}
+
+// GetPages returns every page record in the database
+func (s *Storage) GetPages() ([]Pages, error) {
+	s.settle()
+
+	rows, err := s.db.Query("SELECT " + pageFields + " FROM pages ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanPages(rows)
+}
+
+// GetRuns returns every crawl run, newest first
+func (s *Storage) GetRuns() ([]CrawlRuns, error) {
+	s.settle()
+
+	rows, err := s.db.Query("SELECT " + runFields + " FROM crawl_runs ORDER BY id DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := make([]CrawlRuns, 0)
+	for rows.Next() {
+		run, err := scanRun(rows)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+// GetRun returns a single crawl run
+func (s *Storage) GetRun(runID int64) (CrawlRuns, error) {
+	s.settle()
+
+	row := s.db.QueryRow("SELECT "+runFields+" FROM crawl_runs WHERE id = ?", runID)
+	run, err := scanRun(row)
+	if err == sql.ErrNoRows {
+		return run, fmt.Errorf("no crawl run with id %d", runID)
+	}
+	return run, err
+}
+
+// GetRunPages returns the pages as they were seen by one crawl run
+func (s *Storage) GetRunPages(runID int64) ([]Pages, error) {
+	s.settle()
+
+	// page_observations shares the pages columns, so pageFields reads both
+	rows, err := s.db.Query("SELECT "+pageFields+" FROM page_observations WHERE run_id = ? ORDER BY id", runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanPages(rows)
+}
+
+// GetRunLinks returns the links found during one crawl run
+func (s *Storage) GetRunLinks(runID int64) ([]Links, error) {
+	s.settle()
+
+	rows, err := s.db.Query("SELECT id, from_url, to_url, run_id FROM links WHERE run_id = ? ORDER BY id", runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := make([]Links, 0)
+	for rows.Next() {
+		item := Links{}
+		if err := rows.Scan(&item.Id, &item.From_url, &item.To_url, &item.Run_id); err != nil {
+			return nil, err
+		}
+		links = append(links, item)
+	}
+	return links, rows.Err()
+}
+
+// runFields are the columns read into a CrawlRuns, in the order scanRun expects
+const runFields = "id, start_url, config, started_at, finished_at, outcome, budget, pages_fetched, bytes_fetched"
+
+// scanRun reads a row selected with runFields
+func scanRun(row interface{ Scan(...any) error }) (CrawlRuns, error) {
+	run := CrawlRuns{}
+	var outcome, budget sql.NullString
+	err := row.Scan(&run.Id, &run.Start_url, &run.Config, &run.Started_at, &run.Finished_at, &outcome, &budget, &run.Pages_fetched, &run.Bytes_fetched)
+	run.Outcome = outcome.String
+	run.Budget = budget.String
+	return run, err
+}
+
+// scanPages reads rows selected with pageFields
+func scanPages(rows *sql.Rows) ([]Pages, error) {
+	pages := make([]Pages, 0)
+	for rows.Next() {
+		item := Pages{}
+		err := rows.Scan(&item.Id, &item.Url, &item.Host, &item.Status_code, &item.Content_type, &item.File_path, &item.Fetched_at, &item.Scan_results, &item.Declared_size, &item.Actual_size, &item.Body_stored, &item.Warc_filename, &item.Warc_offset, &item.Charset, &item.Content_hash, &item.Run_id, &item.Headers, &item.Dns_ms, &item.Connect_ms, &item.Tls_ms, &item.Ttfb_ms, &item.Total_ms, &item.Final_url, &item.Redirect_status, &item.Redirect_hops, &item.Scan_status)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, item)
+	}
+	return pages, rows.Err()
 }
 
-// Close closes the database connection.
+// Close commits any buffered writes and closes the database connection.
 func (s *Storage) Close() error {
+	s.stopWriter()
 	return s.db.Close()
 }
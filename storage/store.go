@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"fmt"
+)
+
+// Store is what the crawler, scanner and commands need from storage. SQLite
+// (Storage) is the default, bbolt keeps everything in a single embedded file
+// without cgo or SQL, and the memory store is for tests and dry runs.
+type Store interface {
+	// Pages hold the latest fetch of each URL
+	SavePage(pg Pages) error
+	GetPages() ([]Pages, error)
+	QueryPages(q PageQuery) ([]Pages, error)
+
+	// Scans are saved against the page saved at filePath
+	SaveScan(filePath string, result string) error
+
+	// Links found on pages during a crawl run
+	SaveLink(runID int64, fromURL, toURL string) error
+
+	// Crawl runs and what each one saw
+	StartRun(startURL string, config string) (int64, error)
+	FinishRun(runID int64, outcome string, budget string, pages int, bytes int64) error
+	GetRuns() ([]CrawlRuns, error)
+	GetRun(runID int64) (CrawlRuns, error)
+	GetRunPages(runID int64) ([]Pages, error)
+	GetRunLinks(runID int64) ([]Links, error)
+
+	// Scan runs and the accessibility issues each one found, see IssueQuery
+	StartScanRun() (int64, error)
+	FinishScanRun(scanRunID int64, pages int, issues int) error
+	GetScanRuns() ([]ScanRuns, error)
+	SaveIssues(scanRunID int64, pageURL string, issues []Issues) error
+	QueryIssues(q IssueQuery) ([]Issues, error)
+	SavePageScan(ps PageScans) error
+	GetPageScans(scanRunID int64) ([]PageScans, error)
+	GetLastPageScans() ([]PageScans, error)
+
+	// The frontier is every URL a run queued, and whether it has been fetched
+	AddToFrontier(runID int64, rawURL string) error
+	MarkFetched(runID int64, rawURL string) error
+	GetFrontier(runID int64) ([]string, error)
+
+	// Flush makes sure earlier writes are saved and returns any errors from
+	// writes that were buffered
+	Flush() error
+	Close() error
+}
+
+// Storage backends
+const (
+	BackendSQLite = "sqlite"
+	BackendBolt   = "bbolt"
+	BackendMemory = "memory"
+)
+
+var (
+	_ Store = (*Storage)(nil)
+	_ Store = (*BoltStore)(nil)
+	_ Store = (*MemoryStore)(nil)
+)
+
+// NewStore opens the named backend at path. An empty backend means SQLite.
+// The memory backend ignores the path.
+func NewStore(backend string, path string) (Store, error) {
+	switch backend {
+	case BackendSQLite, "":
+		return New(path)
+	case BackendBolt:
+		return NewBolt(path)
+	case BackendMemory:
+		return NewMemory(), nil
+	}
+	return nil, fmt.Errorf("unknown storage backend %q, use sqlite, bbolt or memory", backend)
+}
@@ -0,0 +1,356 @@
+package storage
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestStores runs the same checks against every backend, so they stay
+// interchangeable
+func TestStores(t *testing.T) {
+	backends := map[string]func(t *testing.T) Store{
+		BackendSQLite: func(t *testing.T) Store {
+			s, err := NewStore(BackendSQLite, filepath.Join(t.TempDir(), "test.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return s
+		},
+		BackendBolt: func(t *testing.T) Store {
+			s, err := NewStore(BackendBolt, filepath.Join(t.TempDir(), "test.bolt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return s
+		},
+		BackendMemory: func(t *testing.T) Store {
+			return NewMemory()
+		},
+	}
+	for name, open := range backends {
+		t.Run(name, func(t *testing.T) {
+			s := open(t)
+			defer s.Close()
+			testPagesAndScans(t, s)
+			testQueryPages(t, s)
+			testRunsAndLinks(t, s)
+			testFrontier(t, s)
+			testScanRunsAndIssues(t, s)
+			testPageScans(t, s)
+		})
+	}
+}
+
+func testPagesAndScans(t *testing.T, s Store) {
+	first := Pages{Url: "https://example.com/", Status_code: 200, File_path: "_output/example.com/index.html"}
+	other := Pages{Url: "https://other.example/", Status_code: 404, File_path: "_output/other.example/index.html"}
+	for _, pg := range []Pages{first, other} {
+		if err := s.SavePage(pg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.SaveScan(first.File_path, "[]"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Saving the page again keeps its id and its last scan
+	first.Status_code = 301
+	if err := s.SavePage(first); err != nil {
+		t.Fatal(err)
+	}
+
+	pages, err := s.GetPages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 2 || pages[0].Url != first.Url || pages[1].Url != other.Url {
+		t.Fatalf("GetPages() = %+v", pages)
+	}
+	if pages[0].Status_code != 301 || pages[0].Scan_results != "[]" || pages[0].Id >= pages[1].Id {
+		t.Errorf("resaved page = %+v", pages[0])
+	}
+
+	pages, err = s.QueryPages(PageQuery{Hosts: []string{"Other.Example"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 1 || pages[0].Url != other.Url || pages[0].Host != "other.example" {
+		t.Errorf("QueryPages() by host = %+v", pages)
+	}
+}
+
+func testQueryPages(t *testing.T, s Store) {
+	before := time.Now()
+	saved := []Pages{
+		{Url: "https://a.example/", Status_code: 200, Content_type: "text/html; charset=utf-8", File_path: "a/index.html", Run_id: 5},
+		{Url: "https://a.example/news/1", Status_code: 200, Content_type: "text/html", File_path: "a/news/1", Run_id: 5},
+		{Url: "https://a.example/news/logo.png", Status_code: 200, Content_type: "image/png", File_path: "a/news/logo.png", Run_id: 5},
+		{Url: "https://b.example:8080/news/2", Status_code: 404, Content_type: "text/html", File_path: "b/news/2", Run_id: 6, Total_ms: 2500},
+	}
+	for _, pg := range saved {
+		if err := s.SavePage(pg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.SaveScan("a/news/1", "[]"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		q    PageQuery
+		want []string
+	}{
+		{"two hosts", PageQuery{Hosts: []string{"a.example", "b.example:8080"}, StatusCodes: []int{404}}, []string{"https://b.example:8080/news/2"}},
+		{"content type", PageQuery{Hosts: []string{"a.example"}, ContentTypes: []string{"text/html"}}, []string{"https://a.example/", "https://a.example/news/1"}},
+		{"content type wildcard", PageQuery{ContentTypes: []string{"image/*"}}, []string{"https://a.example/news/logo.png"}},
+		{"path prefix", PageQuery{PathPrefix: "/news/", ContentTypes: []string{"text/html"}}, []string{"https://a.example/news/1", "https://b.example:8080/news/2"}},
+		{"run", PageQuery{RunID: 6}, []string{"https://b.example:8080/news/2"}},
+		{"scanned", PageQuery{RunID: 5, ScanState: ScanStateScanned}, []string{"https://a.example/news/1"}},
+		{"unscanned", PageQuery{RunID: 5, ScanState: ScanStateUnscanned}, []string{"https://a.example/", "https://a.example/news/logo.png"}},
+		{"fetched after", PageQuery{FetchedAfter: before, Limit: 2, Offset: 1}, []string{"https://a.example/news/1", "https://a.example/news/logo.png"}},
+		{"fetched before", PageQuery{FetchedBefore: before, RunID: 5}, nil},
+		{"slow", PageQuery{MinTotalMs: 1000}, []string{"https://b.example:8080/news/2"}},
+		{"offset past the end", PageQuery{RunID: 5, Offset: 10}, nil},
+	}
+	for _, tt := range tests {
+		pages, err := s.QueryPages(tt.q)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		var got []string
+		for _, pg := range pages {
+			got = append(got, pg.Url)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: QueryPages() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func testRunsAndLinks(t *testing.T, s Store) {
+	runID, err := s.StartRun("https://example.com/", `{"start_url":"https://example.com/"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pg := Pages{Url: "https://example.com/a", Status_code: 200, File_path: "_output/example.com/a", Content_hash: "abc", Run_id: runID,
+		Headers: `{"Cache-Control":["no-store"]}`, Ttfb_ms: 12.5, Total_ms: 40}
+	if err := s.SavePage(pg); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveScan(pg.File_path, `[{"code":"x"}]`); err != nil {
+		t.Fatal(err)
+	}
+	for _, to := range []string{"https://example.com/b", "https://example.com/c"} {
+		if err := s.SaveLink(runID, pg.Url, to); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.SaveLink(runID+1, pg.Url, "https://example.com/other-run"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.FinishRun(runID, "completed", "", 1, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	run, err := s.GetRun(runID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.Outcome != "completed" || !run.Finished_at.Valid || run.Pages_fetched != 1 || run.Bytes_fetched != 10 || run.Config == "" {
+		t.Errorf("GetRun() = %+v", run)
+	}
+	if _, err := s.GetRun(runID + 100); err == nil {
+		t.Error("expected an error for a missing run")
+	}
+	runs, err := s.GetRuns()
+	if err != nil || len(runs) != 1 || runs[0].Id != runID {
+		t.Errorf("GetRuns() = %+v, %v", runs, err)
+	}
+
+	observed, err := s.GetRunPages(runID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(observed) != 1 || observed[0].Url != pg.Url || observed[0].Content_hash != "abc" || observed[0].Scan_results != `[{"code":"x"}]` {
+		t.Errorf("GetRunPages() = %+v", observed)
+	} else if h, err := observed[0].Header(); err != nil || h.Get("Cache-Control") != "no-store" || observed[0].Ttfb_ms != 12.5 || observed[0].Total_ms != 40 {
+		t.Errorf("headers and timing not kept: %+v, %v", observed[0], err)
+	}
+
+	links, err := s.GetRunLinks(runID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var targets []string
+	for _, l := range links {
+		targets = append(targets, l.To_url)
+	}
+	if want := []string{"https://example.com/b", "https://example.com/c"}; !reflect.DeepEqual(targets, want) {
+		t.Errorf("GetRunLinks() targets = %v, want %v", targets, want)
+	}
+}
+
+func testFrontier(t *testing.T, s Store) {
+	for _, u := range []string{"https://example.com/1", "https://example.com/2", "https://example.com/3", "https://example.com/1"} {
+		if err := s.AddToFrontier(7, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.MarkFetched(7, "https://example.com/2"); err != nil {
+		t.Fatal(err)
+	}
+	// Queuing a fetched URL again doesn't make it pending
+	if err := s.AddToFrontier(7, "https://example.com/2"); err != nil {
+		t.Fatal(err)
+	}
+
+	pending, err := s.GetFrontier(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"https://example.com/1", "https://example.com/3"}; !reflect.DeepEqual(pending, want) {
+		t.Errorf("GetFrontier() = %v, want %v", pending, want)
+	}
+	if pending, err := s.GetFrontier(8); err != nil || len(pending) != 0 {
+		t.Errorf("GetFrontier() of an unknown run = %v, %v", pending, err)
+	}
+}
+
+func testScanRunsAndIssues(t *testing.T, s Store) {
+	first, err := s.StartScanRun()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.StartScanRun()
+	if err != nil || second <= first {
+		t.Fatalf("StartScanRun() = %d, %v after %d", second, err, first)
+	}
+
+	home := "https://Example.com/"
+	save := func(scanRunID int64, pageURL string, codes ...string) {
+		var issues []Issues
+		for _, code := range codes {
+			issues = append(issues, Issues{Code: code, Type: "error", Message: "m", Runner: "htmlcs"})
+		}
+		if err := s.SaveIssues(scanRunID, pageURL, issues); err != nil {
+			t.Fatal(err)
+		}
+	}
+	save(first, home, "A", "B")
+	save(second, home, "A", "B", "C")
+	save(second, "https://other.example/", "A")
+	// Saving a page's issues again replaces them
+	save(second, home, "A", "C")
+	if err := s.FinishScanRun(second, 2, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	issues, err := s.QueryIssues(IssueQuery{ScanRunID: second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, issue := range issues {
+		got = append(got, issue.Page_url+" "+issue.Code)
+	}
+	if want := []string{"https://other.example/ A", home + " A", home + " C"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("QueryIssues() = %v, want %v", got, want)
+	}
+	if issues[1].Host != "example.com" || issues[1].Scan_run_id != second || issues[1].Runner != "htmlcs" {
+		t.Errorf("saved issue = %+v", issues[1])
+	}
+
+	issues, err = s.QueryIssues(IssueQuery{Hosts: []string{"EXAMPLE.com"}, Codes: []string{"A"}, Types: []string{"Error"}})
+	if err != nil || len(issues) != 2 {
+		t.Errorf("QueryIssues() by host, code and type = %+v, %v, want one from each scan run", issues, err)
+	}
+	issues, err = s.QueryIssues(IssueQuery{ScanRunID: second, Limit: 1, Offset: 2})
+	if err != nil || len(issues) != 1 || issues[0].Code != "C" {
+		t.Errorf("QueryIssues() paged = %+v, %v", issues, err)
+	}
+
+	runs, err := s.GetScanRuns()
+	if err != nil || len(runs) != 2 {
+		t.Fatalf("GetScanRuns() = %+v, %v", runs, err)
+	}
+	if runs[0].Id != second || !runs[0].Finished_at.Valid || runs[0].Issues_found != 3 || runs[1].Finished_at.Valid {
+		t.Errorf("GetScanRuns() = %+v, want the finished run first", runs)
+	}
+}
+
+func testPageScans(t *testing.T, s Store) {
+	ok := Pages{Url: "https://scans.example/ok", File_path: "ok.html"}
+	failed := Pages{Url: "https://scans.example/failed", File_path: "failed.html"}
+	for _, pg := range []Pages{ok, failed} {
+		if err := s.SavePage(pg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	scanRunID, err := s.StartScanRun()
+	if err != nil {
+		t.Fatal(err)
+	}
+	scans := []PageScans{
+		{Scan_run_id: scanRunID, Page_url: ok.Url, Status: ScanIssues, Issues: 2, Content_hash: "abc"},
+		{Scan_run_id: scanRunID, Page_url: failed.Url, Status: ScanTimedOut, Error: "took too long"},
+		// Scanning a page again in the same run replaces its scan
+		{Scan_run_id: scanRunID, Page_url: failed.Url, Status: ScanFailed, Error: "no browser"},
+	}
+	for _, ps := range scans {
+		if err := s.SavePageScan(ps); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := s.GetPageScans(scanRunID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Page_url != failed.Url || got[0].Status != ScanFailed || got[0].Error != "no browser" ||
+		got[1].Issues != 2 || got[1].Content_hash != "abc" || got[1].Scanned_at.IsZero() {
+		t.Errorf("GetPageScans() = %+v", got)
+	}
+
+	// The last status is kept on the page, and re-fetching the page keeps it
+	if err := s.SavePage(failed); err != nil {
+		t.Fatal(err)
+	}
+	pages, err := s.QueryPages(PageQuery{ScanStatuses: []string{ScanFailed, ScanTimedOut}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 1 || pages[0].Url != failed.Url || pages[0].Scan_status != ScanFailed {
+		t.Errorf("QueryPages() of failed scans = %+v", pages)
+	}
+	// The last scans come from whichever run scanned each page last
+	nextRunID, err := s.StartScanRun()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SavePageScan(PageScans{Scan_run_id: nextRunID, Page_url: ok.Url, Status: ScanOK, Content_hash: "def", Config_hash: "cfg"}); err != nil {
+		t.Fatal(err)
+	}
+	last, err := s.GetLastPageScans()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(last) != 2 || last[0].Scan_run_id != scanRunID || last[0].Status != ScanFailed ||
+		last[1].Scan_run_id != nextRunID || last[1].Content_hash != "def" || last[1].Config_hash != "cfg" {
+		t.Errorf("GetLastPageScans() = %+v", last)
+	}
+}
+
+func TestNewStoreUnknownBackend(t *testing.T) {
+	if _, err := NewStore("postgres", "x"); err == nil {
+		t.Error("expected an error for an unknown backend")
+	}
+}
@@ -4,12 +4,18 @@ import (
 	"crypto/sha1"
 	"encoding/hex"
 	"fmt"
+	"io"
+	"mime"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
 	"regexp"
 	"strings"
+
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/unicode"
 )
 
 // EnsureDir creates a directory if it doesn't exist.
@@ -115,6 +121,73 @@ func URLToFilePath(baseDir string, rawURL string) (string, error) {
 	return SafeJoin(baseDir, "/"+hostPath, path)
 }
 
+// mirrorExtensions are the file extensions a browser expects for common
+// content types. The first one is used when a file needs an extension added.
+var mirrorExtensions = map[string][]string{
+	"text/html":                {".html", ".htm"},
+	"application/xhtml+xml":    {".xhtml", ".html", ".htm"},
+	"text/css":                 {".css"},
+	"text/javascript":          {".js", ".mjs"},
+	"application/javascript":   {".js", ".mjs"},
+	"application/json":         {".json"},
+	"text/plain":               {".txt"},
+	"image/png":                {".png"},
+	"image/jpeg":               {".jpg", ".jpeg"},
+	"image/gif":                {".gif"},
+	"image/svg+xml":            {".svg"},
+	"image/webp":               {".webp"},
+	"image/x-icon":             {".ico"},
+	"image/vnd.microsoft.icon": {".ico"},
+	"font/woff":                {".woff"},
+	"font/woff2":               {".woff2"},
+	"application/pdf":          {".pdf"},
+}
+
+// MirrorFilePath maps a URL to a local file path that a browser can open from
+// disk. Unlike URLToFilePath the query hash goes before the extension, and the
+// extension is made to match the content type, so /about served as HTML is
+// saved as about.html and style.css?v=2 as style_<hash>.css.
+func MirrorFilePath(baseDir string, rawURL string, contentType string) (string, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return URLToFilePath(baseDir, rawURL)
+	}
+
+	hostPath := SanitizeFilename(parsed.Host)
+	p := parsed.Path
+	if strings.HasSuffix(p, "/") || p == "" {
+		p += "index.html"
+	}
+
+	ext := path.Ext(p)
+	stem := strings.TrimSuffix(p, ext)
+	if parsed.RawQuery != "" {
+		hash := sha1.Sum([]byte(parsed.RawQuery))
+		stem += "_" + hex.EncodeToString(hash[:])
+	}
+	p = stem + ext
+
+	mediaType := MediaType(contentType)
+	wanted, ok := mirrorExtensions[mediaType]
+	if !ok && mediaType != "" {
+		wanted, _ = mime.ExtensionsByType(mediaType)
+	}
+	if len(wanted) > 0 {
+		matched := false
+		for _, w := range wanted {
+			if strings.EqualFold(ext, w) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			p += wanted[0]
+		}
+	}
+
+	return SafeJoin(baseDir, "/"+hostPath, p)
+}
+
 // StripHTMLFile removes the HTML file from the path, if present.
 func StripHTMLFile(p string) string {
 	ext := path.Ext(p)
@@ -129,3 +202,43 @@ func StripHTMLFile(p string) string {
 	}
 	return p
 }
+
+// MediaType returns the lower case media type of a Content-Type header,
+// without any parameters like charset.
+func MediaType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType, _, _ = strings.Cut(contentType, ";")
+	}
+	return strings.ToLower(strings.TrimSpace(mediaType))
+}
+
+// MatchContentType reports whether the Content-Type header matches any of the
+// patterns. Patterns are media types like "text/html" or wildcards like "image/*".
+func MatchContentType(contentType string, patterns []string) bool {
+	mediaType := MediaType(contentType)
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if pattern == "*" || pattern == "*/*" || pattern == mediaType {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(pattern, "/*"); ok && strings.HasPrefix(mediaType, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// UTF8Reader transcodes r from the named charset to UTF-8 for parsing. The
+// bytes saved on disk are left as the server sent them, so anything reading
+// a saved page goes through this with the page's charset.
+func UTF8Reader(r io.Reader, charsetName string) io.Reader {
+	if charsetName == "" {
+		return r
+	}
+	enc, _ := charset.Lookup(charsetName)
+	if enc == nil || enc == encoding.Nop || enc == unicode.UTF8 {
+		return r
+	}
+	return enc.NewDecoder().Reader(r)
+}
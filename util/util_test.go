@@ -1,6 +1,10 @@
 package util
 
-import "testing"
+import (
+	"io"
+	"strings"
+	"testing"
+)
 
 // TestSanitizePathSegment tests individual path segment sanitization
 func TestSanitizePathSegment(t *testing.T) {
@@ -41,3 +45,70 @@ func TestStripHTMLFile(t *testing.T) {
 		}
 	}
 }
+
+func TestMatchContentType(t *testing.T) {
+	patterns := []string{"text/html", "image/*"}
+	tests := map[string]bool{
+		"text/html":                true,
+		"text/html; charset=utf-8": true,
+		"TEXT/HTML":                true,
+		"image/png":                true,
+		"image/svg+xml":            true,
+		"text/css":                 false,
+		"video/mp4":                false,
+		"":                         false,
+	}
+
+	for input, expected := range tests {
+		result := MatchContentType(input, patterns)
+		if result != expected {
+			t.Errorf("MatchContentType(%q) = %v; want %v", input, result, expected)
+		}
+	}
+}
+
+func TestMirrorFilePath(t *testing.T) {
+	tests := []struct {
+		url         string
+		contentType string
+		expected    string
+	}{
+		{"https://example.com/", "text/html", "out/example.com/index.html"},
+		{"https://example.com/about", "text/html; charset=utf-8", "out/example.com/about.html"},
+		{"https://example.com/about/", "text/html", "out/example.com/about/index.html"},
+		{"https://example.com/page.htm", "text/html", "out/example.com/page.htm"},
+		{"https://example.com/page.php", "text/html", "out/example.com/page.php.html"},
+		{"https://example.com/style.css?v=2", "text/css", "out/example.com/style_f67bbdbffcd3cf2f59ffd2a6100c994fd6c148f9.css"},
+		{"https://example.com/logo", "image/png", "out/example.com/logo.png"},
+		{"https://example.com/file.bin", "", "out/example.com/file.bin"},
+	}
+
+	for _, tt := range tests {
+		result, err := MirrorFilePath("out", tt.url, tt.contentType)
+		if err != nil {
+			t.Errorf("MirrorFilePath(%q, %q) error: %v", tt.url, tt.contentType, err)
+			continue
+		}
+		if result != tt.expected {
+			t.Errorf("MirrorFilePath(%q, %q) = %q; want %q", tt.url, tt.contentType, result, tt.expected)
+		}
+	}
+}
+
+func TestUTF8Reader(t *testing.T) {
+	tests := []struct {
+		charset string
+		in      string
+		want    string
+	}{
+		{"windows-1252", "caf\xe9", "café"},
+		{"utf-8", "café", "café"},
+		{"", "caf\xe9", "caf\xe9"},
+	}
+	for _, tt := range tests {
+		got, _ := io.ReadAll(UTF8Reader(strings.NewReader(tt.in), tt.charset))
+		if string(got) != tt.want {
+			t.Errorf("UTF8Reader(%q, %q) = %q; want %q", tt.in, tt.charset, got, tt.want)
+		}
+	}
+}
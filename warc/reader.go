@@ -0,0 +1,135 @@
+package warc
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Record is a single WARC record read back from a file
+type Record struct {
+	Header textproto.MIMEHeader
+	Block  []byte
+	Offset int64 // where the record's gzip member starts in the file
+}
+
+// Type is the WARC-Type of the record, e.g. response or request
+func (r *Record) Type() string {
+	return r.Header.Get("WARC-Type")
+}
+
+// TargetURI is the URL the record is about
+func (r *Record) TargetURI() string {
+	return r.Header.Get("WARC-Target-URI")
+}
+
+// ReadRecord reads the record starting at offset in a file written by Writer
+func ReadRecord(path string, offset int64) (*Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	gz, err := gzip.NewReader(bufio.NewReader(f))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	gz.Multistream(false)
+
+	rec, err := readRecord(bufio.NewReader(gz))
+	if err != nil {
+		return nil, err
+	}
+	rec.Offset = offset
+	return rec, nil
+}
+
+// Scan calls fn for every record in the file, in order. Scanning stops at the
+// first error returned by fn.
+func Scan(path string, fn func(rec *Record) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// gzip reads straight from a bufio.Reader without reading ahead of it, so the
+	// start of each member is what we've read from the file less what is buffered
+	counter := &countingReader{r: f}
+	br := bufio.NewReader(counter)
+
+	var gz *gzip.Reader
+	for {
+		offset := counter.n - int64(br.Buffered())
+		if gz == nil {
+			gz, err = gzip.NewReader(br)
+		} else {
+			err = gz.Reset(br)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading gzip member at %d: %w", offset, err)
+		}
+		gz.Multistream(false)
+
+		rec, err := readRecord(bufio.NewReader(gz))
+		if err != nil {
+			return fmt.Errorf("reading record at %d: %w", offset, err)
+		}
+		rec.Offset = offset
+		// Drain what is left of the member so the next one starts cleanly
+		if _, err := io.Copy(io.Discard, gz); err != nil {
+			return err
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+}
+
+func readRecord(r *bufio.Reader) (*Record, error) {
+	tp := textproto.NewReader(r)
+	version, err := tp.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(version, "WARC/") {
+		return nil, fmt.Errorf("not a WARC record, found %q", version)
+	}
+	header, err := tp.ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("bad Content-Length: %w", err)
+	}
+	block := make([]byte, length)
+	if _, err := io.ReadFull(r, block); err != nil {
+		return nil, err
+	}
+	return &Record{Header: header, Block: block}, nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
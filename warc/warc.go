@@ -0,0 +1,293 @@
+package warc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"boem-web-thing/util"
+)
+
+// Version is the WARC format version written in every record
+const Version = "WARC/1.1"
+
+// Writer appends WARC records to gzip compressed files, one gzip member per
+// record so any record can be read on its own from its offset. When a file
+// grows past maxBytes the next record starts a new file.
+type Writer struct {
+	dir      string
+	prefix   string
+	maxBytes int64
+	software string
+
+	mu     sync.Mutex
+	file   *os.File
+	name   string
+	size   int64
+	serial int
+}
+
+// Location is where a record was written, so it can be found again later
+type Location struct {
+	Filename string
+	Offset   int64
+}
+
+// NewWriter makes a Writer that puts files into dir, named from the prefix.
+// A maxBytes of zero or less means files never roll over.
+func NewWriter(dir, prefix string, maxBytes int64, software string) (*Writer, error) {
+	if err := util.EnsureDir(dir); err != nil {
+		return nil, fmt.Errorf("failed to create warc dir: %w", err)
+	}
+	if prefix == "" {
+		prefix = "crawl"
+	}
+	return &Writer{
+		dir:      dir,
+		prefix:   prefix,
+		maxBytes: maxBytes,
+		software: software,
+	}, nil
+}
+
+// WriteExchange writes a response record for the response and its body, and a
+// request record for the request that produced it. The body is read from
+// bodyPath as the response body has already been saved to disk. Any
+// redirects the client followed to get the response are written first, each
+// as its own exchange, so the archive has every URL that was asked for. The
+// location of the final response record is returned.
+//
+// Go has already parsed the headers and undone any transfer encoding, so the
+// HTTP blocks are rebuilt from the parsed values rather than the raw bytes.
+func (w *Writer) WriteExchange(resp *http.Response, bodyPath string) (Location, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	date := time.Now().UTC()
+
+	var hops []*http.Response
+	for hop := resp.Request.Response; hop != nil; hop = hop.Request.Response {
+		hops = append([]*http.Response{hop}, hops...)
+	}
+	for _, hop := range hops {
+		// The client throws a redirect's body away, so it is archived empty
+		fields := hop.Header.Clone()
+		fields.Set("Content-Length", "0")
+		fields.Del("Transfer-Encoding")
+		if _, err := w.writeExchange(hop, fields, bytes.NewReader(nil), 0, date); err != nil {
+			return Location{}, err
+		}
+	}
+
+	body, err := os.Open(bodyPath)
+	if err != nil {
+		return Location{}, err
+	}
+	defer body.Close()
+	bodyInfo, err := body.Stat()
+	if err != nil {
+		return Location{}, err
+	}
+	return w.writeExchange(resp, resp.Header, body, bodyInfo.Size(), date)
+}
+
+// writeExchange writes the response and request records for one response,
+// with fields in place of the response's headers. Caller must hold the lock.
+func (w *Writer) writeExchange(resp *http.Response, fields http.Header, body io.ReadSeeker, size int64, date time.Time) (Location, error) {
+	targetURI := resp.Request.URL.String()
+
+	payloadDigest := sha1.New()
+	if _, err := io.Copy(payloadDigest, body); err != nil {
+		return Location{}, err
+	}
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return Location{}, err
+	}
+
+	var head bytes.Buffer
+	fmt.Fprintf(&head, "%s %s\r\n", resp.Proto, resp.Status)
+	// The session cookies a login sets don't belong in an archive either
+	if err := fields.WriteSubset(&head, redactedHeaders); err != nil {
+		return Location{}, err
+	}
+	head.WriteString("\r\n")
+
+	blockDigest := sha1.New()
+	blockDigest.Write(head.Bytes())
+	if _, err := io.Copy(blockDigest, body); err != nil {
+		return Location{}, err
+	}
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return Location{}, err
+	}
+
+	if err := w.rollover(); err != nil {
+		return Location{}, err
+	}
+
+	responseID := newRecordID()
+	loc := Location{Filename: w.name, Offset: w.size}
+	headers := []header{
+		{"WARC-Type", "response"},
+		{"WARC-Record-ID", responseID},
+		{"WARC-Date", date.Format(time.RFC3339Nano)},
+		{"WARC-Target-URI", targetURI},
+		{"WARC-Payload-Digest", "sha1:" + base32Digest(payloadDigest.Sum(nil))},
+		{"WARC-Block-Digest", "sha1:" + base32Digest(blockDigest.Sum(nil))},
+		{"Content-Type", "application/http;msgtype=response"},
+	}
+	block := io.MultiReader(bytes.NewReader(head.Bytes()), body)
+	if err := w.writeRecord(headers, block, int64(head.Len())+size); err != nil {
+		return Location{}, err
+	}
+
+	var req bytes.Buffer
+	requestURI := resp.Request.URL.RequestURI()
+	fmt.Fprintf(&req, "%s %s HTTP/1.1\r\n", resp.Request.Method, requestURI)
+	fmt.Fprintf(&req, "Host: %s\r\n", resp.Request.URL.Host)
+	// Credentials and session cookies don't belong in an archive
+	if err := resp.Request.Header.WriteSubset(&req, redactedHeaders); err != nil {
+		return Location{}, err
+	}
+	req.WriteString("\r\n")
+
+	headers = []header{
+		{"WARC-Type", "request"},
+		{"WARC-Record-ID", newRecordID()},
+		{"WARC-Date", date.Format(time.RFC3339Nano)},
+		{"WARC-Target-URI", targetURI},
+		{"WARC-Concurrent-To", responseID},
+		{"WARC-Block-Digest", "sha1:" + base32Digest(sha1Sum(req.Bytes()))},
+		{"Content-Type", "application/http;msgtype=request"},
+	}
+	if err := w.writeRecord(headers, bytes.NewReader(req.Bytes()), int64(req.Len())); err != nil {
+		return Location{}, err
+	}
+
+	return loc, nil
+}
+
+// Close finishes the current file
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+// redactedHeaders are left out of archived requests and responses
+var redactedHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+}
+
+type header struct {
+	name  string
+	value string
+}
+
+// rollover opens the first file, or a new one once the current file is full.
+// Every new file starts with a warcinfo record. Caller must hold the lock.
+func (w *Writer) rollover() error {
+	if w.file != nil && (w.maxBytes <= 0 || w.size < w.maxBytes) {
+		return nil
+	}
+	if w.file != nil {
+		if err := w.file.Close(); err != nil {
+			return err
+		}
+		w.file = nil
+	}
+
+	w.serial++
+	name := fmt.Sprintf("%s-%s-%05d.warc.gz", w.prefix, time.Now().UTC().Format("20060102150405"), w.serial)
+	f, err := os.OpenFile(filepath.Join(w.dir, name), os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	w.file = f
+	w.name = name
+	w.size = 0
+
+	info := fmt.Sprintf("software: %s\r\nformat: WARC File Format 1.1\r\n", w.software)
+	headers := []header{
+		{"WARC-Type", "warcinfo"},
+		{"WARC-Record-ID", newRecordID()},
+		{"WARC-Date", time.Now().UTC().Format(time.RFC3339Nano)},
+		{"WARC-Filename", name},
+		{"Content-Type", "application/warc-fields"},
+	}
+	return w.writeRecord(headers, bytes.NewReader([]byte(info)), int64(len(info)))
+}
+
+// writeRecord writes one record as its own gzip member. Caller must hold the lock.
+func (w *Writer) writeRecord(headers []header, block io.Reader, length int64) error {
+	counter := &countingWriter{w: w.file}
+	gz := gzip.NewWriter(counter)
+
+	var head bytes.Buffer
+	head.WriteString(Version + "\r\n")
+	for _, h := range headers {
+		fmt.Fprintf(&head, "%s: %s\r\n", h.name, h.value)
+	}
+	fmt.Fprintf(&head, "Content-Length: %d\r\n\r\n", length)
+
+	if _, err := gz.Write(head.Bytes()); err != nil {
+		return err
+	}
+	if _, err := io.Copy(gz, block); err != nil {
+		return err
+	}
+	if _, err := gz.Write([]byte("\r\n\r\n")); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	w.size += counter.n
+	return nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// newRecordID makes a random (version 4) UUID URN for WARC-Record-ID
+func newRecordID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("<urn:uuid:%x-%x-%x-%x-%x>", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+func sha1Sum(b []byte) []byte {
+	sum := sha1.Sum(b)
+	return sum[:]
+}
+
+func base32Digest(sum []byte) string {
+	return base32.StdEncoding.EncodeToString(sum)
+}
@@ -0,0 +1,276 @@
+package warc
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWriteExchange(t *testing.T) {
+	dir := t.TempDir()
+	bodyPath := filepath.Join(dir, "body.html")
+	if err := os.WriteFile(bodyPath, []byte("<html><body>hello</body></html>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := NewWriter(dir, "test", 0, "boem-web-thing")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, _ := url.Parse("https://example.com/page?id=1")
+	resp := &http.Response{
+		Status:     "200 OK",
+		StatusCode: 200,
+		Proto:      "HTTP/1.1",
+		Header:     http.Header{"Content-Type": {"text/html"}, "Set-Cookie": {"session=secret; Path=/"}},
+		Request:    &http.Request{Method: "GET", URL: u, Header: http.Header{"Cookie": {"session=secret"}, "Accept": {"text/html"}}},
+	}
+
+	first, err := w.WriteExchange(resp, bodyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := w.WriteExchange(resp, bodyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if first.Filename != second.Filename {
+		t.Errorf("records split across %q and %q without a size limit", first.Filename, second.Filename)
+	}
+	if second.Offset <= first.Offset {
+		t.Errorf("second offset %d is not after first offset %d", second.Offset, first.Offset)
+	}
+
+	// Each record is its own gzip member, so reading from the offset gives just that record
+	f, err := os.Open(filepath.Join(dir, second.Filename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Seek(second.Offset, 0); err != nil {
+		t.Fatal(err)
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz.Multistream(false)
+	r := bufio.NewReader(gz)
+
+	line, _ := r.ReadString('\n')
+	if line != Version+"\r\n" {
+		t.Errorf("first line = %q; want %q", line, Version+"\r\n")
+	}
+	rest := make([]byte, 4096)
+	n, _ := r.Read(rest)
+	record := string(rest[:n])
+	for _, want := range []string{"WARC-Type: response", "WARC-Target-URI: https://example.com/page?id=1", "HTTP/1.1 200 OK", "hello"} {
+		if !strings.Contains(record, want) {
+			t.Errorf("record does not contain %q:\n%s", want, record)
+		}
+	}
+
+	// Session cookies are left out of both the response and request records
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	all, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, err := io.ReadAll(all)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(archive), "secret") {
+		t.Errorf("archive contains the session cookie:\n%s", archive)
+	}
+	if !strings.Contains(string(archive), "Accept: text/html") {
+		t.Errorf("archive lost the other request headers:\n%s", archive)
+	}
+}
+
+func TestRollover(t *testing.T) {
+	dir := t.TempDir()
+	bodyPath := filepath.Join(dir, "body.txt")
+	if err := os.WriteFile(bodyPath, []byte(strings.Repeat("x", 2048)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := NewWriter(filepath.Join(dir, "warc"), "test", 1, "boem-web-thing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	u, _ := url.Parse("https://example.com/")
+	resp := &http.Response{
+		Status:  "200 OK",
+		Proto:   "HTTP/1.1",
+		Header:  http.Header{},
+		Request: &http.Request{Method: "GET", URL: u, Header: http.Header{}},
+	}
+
+	first, err := w.WriteExchange(resp, bodyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := w.WriteExchange(resp, bodyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Filename == second.Filename {
+		t.Errorf("both records written to %q, want a new file after the size limit", first.Filename)
+	}
+}
+
+func TestScanAndReadRecord(t *testing.T) {
+	dir := t.TempDir()
+	bodyPath := filepath.Join(dir, "body.html")
+	if err := os.WriteFile(bodyPath, []byte("<p>archived</p>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := NewWriter(dir, "test", 0, "boem-web-thing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var written []Location
+	for _, page := range []string{"https://example.com/a", "https://example.com/b"} {
+		u, _ := url.Parse(page)
+		resp := &http.Response{
+			Status:  "200 OK",
+			Proto:   "HTTP/1.1",
+			Header:  http.Header{"Content-Type": {"text/html"}},
+			Request: &http.Request{Method: "GET", URL: u, Header: http.Header{}},
+		}
+		loc, err := w.WriteExchange(resp, bodyPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		written = append(written, loc)
+	}
+	w.Close()
+
+	path := filepath.Join(dir, written[0].Filename)
+	var responses []*Record
+	types := map[string]int{}
+	err = Scan(path, func(rec *Record) error {
+		types[rec.Type()]++
+		if rec.Type() == "response" {
+			responses = append(responses, rec)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if types["warcinfo"] != 1 || types["request"] != 2 || types["response"] != 2 {
+		t.Errorf("record types = %v; want 1 warcinfo, 2 request, 2 response", types)
+	}
+	for i, rec := range responses {
+		if rec.Offset != written[i].Offset {
+			t.Errorf("response %d found at offset %d; written at %d", i, rec.Offset, written[i].Offset)
+		}
+	}
+
+	rec, err := ReadRecord(path, written[1].Offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.TargetURI() != "https://example.com/b" {
+		t.Errorf("TargetURI() = %q; want %q", rec.TargetURI(), "https://example.com/b")
+	}
+	if !strings.HasSuffix(string(rec.Block), "<p>archived</p>") {
+		t.Errorf("block does not end with the body: %q", rec.Block)
+	}
+}
+
+func TestWriteExchangeRedirect(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/docs", http.RedirectHandler("/docs/", http.StatusMovedPermanently))
+	mux.HandleFunc("/docs/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		io.WriteString(w, "<p>docs</p>")
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	bodyPath := filepath.Join(dir, "body.html")
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err := os.WriteFile(bodyPath, body, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := NewWriter(dir, "test", 0, "boem-web-thing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	loc, err := w.WriteExchange(resp, bodyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	// The redirect is archived under the URL that was asked for, then the
+	// page it led to
+	type archived struct {
+		uri    string
+		status int
+		body   string
+	}
+	var got []archived
+	err = Scan(filepath.Join(dir, loc.Filename), func(rec *Record) error {
+		if rec.Type() != "response" {
+			return nil
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(rec.Block)), nil)
+		if err != nil {
+			return err
+		}
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		got = append(got, archived{rec.TargetURI(), resp.StatusCode, string(body)})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []archived{
+		{server.URL + "/docs", http.StatusMovedPermanently, ""},
+		{server.URL + "/docs/", http.StatusOK, "<p>docs</p>"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("responses = %+v; want %+v", got, want)
+	}
+
+	rec, err := ReadRecord(filepath.Join(dir, loc.Filename), loc.Offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.TargetURI() != server.URL+"/docs/" {
+		t.Errorf("returned location is for %q; want the final page", rec.TargetURI())
+	}
+}